@@ -0,0 +1,160 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+// requestsPerMinute is how many requests a single IP can make per minute
+const requestsPerMinute = 30
+
+type ShutdownsService interface {
+	GetShutdownsTable() (models.ShutdownsTable, bool, error)
+}
+
+// Schedule is the public representation of the stored shutdowns table
+type Schedule struct {
+	Date      string              `json:"date"`
+	Periods   []models.Period     `json:"periods"`
+	Groups    map[string][]string `json:"groups"`
+	FetchedAt time.Time           `json:"fetched_at"`
+}
+
+var statusNames = map[models.Status]string{
+	models.ON:    "on",
+	models.OFF:   "off",
+	models.MAYBE: "maybe",
+}
+
+func newSchedule(table models.ShutdownsTable) Schedule {
+	groups := make(map[string][]string, len(table.Groups))
+	for num, group := range table.Groups {
+		statuses := make([]string, 0, len(group.Items))
+		for _, item := range group.Items {
+			statuses = append(statuses, statusNames[item])
+		}
+		groups[num] = statuses
+	}
+	return Schedule{
+		Date:      table.Date,
+		Periods:   table.Periods,
+		Groups:    groups,
+		FetchedAt: table.UpdatedAt,
+	}
+}
+
+// NewServer returns server of the public API on addr, or nil if addr is empty, so the API is never exposed unless
+// explicitly configured
+func NewServer(addr string, shutdowns ShutdownsService) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	limiter := newIPLimiter(requestsPerMinute, time.Minute, time.Now)
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/public/schedule", limiter.wrap(scheduleHandler(shutdowns)))
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// scheduleHandler serves the current schedule. The bot keeps the current day only, so there is no date parameter.
+// ETag lets clients poll cheaply with If-None-Match
+func scheduleHandler(shutdowns ShutdownsService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		table, ok, err := shutdowns.GetShutdownsTable()
+		if err != nil {
+			slog.Error("failed to get shutdowns table", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "schedule is not available yet", http.StatusNotFound)
+			return
+		}
+
+		body, err := json.Marshal(newSchedule(table))
+		if err != nil {
+			slog.Error("failed to marshal schedule", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, err = w.Write(body); err != nil {
+			slog.Debug("failed to write schedule response", "error", err)
+		}
+	})
+}
+
+// ipLimiter allows limit requests per window from a single IP. Counters are reset all at once when window passes,
+// so memory is bounded by the number of IPs seen within a window
+type ipLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mx      sync.Mutex
+	started time.Time
+	counts  map[string]int
+}
+
+func newIPLimiter(limit int, window time.Duration, now func() time.Time) *ipLimiter {
+	return &ipLimiter{
+		limit:   limit,
+		window:  window,
+		now:     now,
+		started: now(),
+		counts:  make(map[string]int),
+	}
+}
+
+func (l *ipLimiter) allow(ip string) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if now := l.now(); now.Sub(l.started) >= l.window {
+		l.started = now
+		l.counts = make(map[string]int)
+	}
+	if l.counts[ip] >= l.limit {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+func (l *ipLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !l.allow(ip) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(l.window.Seconds())))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type fakeShutdowns struct {
+	table models.ShutdownsTable
+	ok    bool
+}
+
+func (s *fakeShutdowns) GetShutdownsTable() (models.ShutdownsTable, bool, error) {
+	return s.table, s.ok, nil
+}
+
+func get(t *testing.T, server *http.Server, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/public/schedule", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNewServer(t *testing.T) {
+	if server := NewServer("", &fakeShutdowns{}); server != nil {
+		t.Errorf("NewServer() without address = %v, want nil", server)
+	}
+
+	fetchedAt := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	shutdowns := &fakeShutdowns{}
+	server := NewServer("127.0.0.1:0", shutdowns)
+
+	if rec := get(t, server, "10.0.0.1:1000", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("status before table is loaded = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	shutdowns.table = models.ShutdownsTable{
+		Date:      "15 січня",
+		Periods:   []models.Period{{From: "00:00", To: "12:00"}, {From: "12:00", To: "24:00"}},
+		Groups:    map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.ON, models.MAYBE}}},
+		UpdatedAt: fetchedAt,
+	}
+	shutdowns.ok = true
+	rec := get(t, server, "10.0.0.1:1000", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got Schedule
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	want := Schedule{
+		Date:      "15 січня",
+		Periods:   shutdowns.table.Periods,
+		Groups:    map[string][]string{"1": {"on", "maybe"}},
+		FetchedAt: fetchedAt,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("schedule = %+v, want %+v", got, want)
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("ETag is not set")
+	}
+	rec = get(t, server, "10.0.0.1:1000", http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("conditional request status = %d with %d bytes, want %d without body",
+			rec.Code, rec.Body.Len(), http.StatusNotModified)
+	}
+
+	shutdowns.table.Groups["1"] = models.ShutdownGroup{Number: 1, Items: []models.Status{models.OFF, models.OFF}}
+	if rec = get(t, server, "10.0.0.1:1000", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusOK {
+		t.Errorf("status after schedule change = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestIPLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	l := newIPLimiter(2, time.Minute, func() time.Time { return now })
+	handler := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	status := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := status("10.0.0.1:1000"); got != want {
+			t.Errorf("request %d status = %d, want %d", i, got, want)
+		}
+	}
+	if got := status("10.0.0.2:1000"); got != http.StatusOK {
+		t.Errorf("other IP status = %d, want %d", got, http.StatusOK)
+	}
+	// another port of the same IP shares the limit
+	if got := status("10.0.0.1:2000"); got != http.StatusTooManyRequests {
+		t.Errorf("same IP status = %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	now = now.Add(time.Minute)
+	if got := status("10.0.0.1:1000"); got != http.StatusOK {
+		t.Errorf("status after window = %d, want %d", got, http.StatusOK)
+	}
+}
@@ -3,8 +3,10 @@ package dal
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"go.etcd.io/bbolt"
 
@@ -14,6 +16,37 @@ import (
 const shutdownsBucket = "shutdowns"
 const subscriptionsBucket = "subscriptions"
 const notificationsBucket = "notifications"
+const channelPostsBucket = "channel_posts"
+const appStateBucket = "app_state"
+const tombstonesBucket = "tombstones"
+const purgeLogBucket = "purge_log"
+const importNoncesBucket = "import_nonces"
+const countdownPostsBucket = "countdown_posts"
+const scheduleRevisionsBucket = "schedule_revisions"
+const deliveryLogBucket = "delivery_log"
+
+// buckets are all buckets the store expects to exist
+var buckets = []string{
+	shutdownsBucket, subscriptionsBucket, notificationsBucket, channelPostsBucket, appStateBucket, tombstonesBucket,
+	purgeLogBucket, importNoncesBucket, countdownPostsBucket, scheduleRevisionsBucket, deliveryLogBucket,
+}
+
+// purgeLogCapacity is the number of latest purge events kept
+const purgeLogCapacity = 1000
+
+// scheduleRevisionsCapacity is the number of latest revisions kept per date. Dates which didn't get new revisions
+// for scheduleRevisionsRetention are removed
+const scheduleRevisionsCapacity = 50
+const scheduleRevisionsRetention = 7 * 24 * time.Hour
+
+// deliveryLogCapacity is the number of latest entries kept per chat. Entries which ended more than
+// deliveryLogRetention ago are removed
+const deliveryLogCapacity = 10
+const deliveryLogRetention = 48 * time.Hour
+
+const maintenanceKey = "maintenance"
+const suspendedGroupsKey = "suspended_groups"
+const dailySummaryKey = "daily_summary"
 
 type BoltDBStore struct {
 	db *bbolt.DB
@@ -80,11 +113,26 @@ func (s *BoltDBStore) SubscriptionGetAll() ([]models.Subscription, error) {
 	return res, err
 }
 
+// SubscriptionPut stores subscription incrementing its version. It returns models.ErrConflict if the stored
+// subscription was changed or removed since the given one was read
 func (s *BoltDBStore) SubscriptionPut(sub models.Subscription) (models.Subscription, error) {
 	err := s.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(subscriptionsBucket))
 
 		id := i64tob(sub.ChatID)
+		version := 0
+		if data := b.Get(id); data != nil {
+			var stored models.Subscription
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return fmt.Errorf("failed to unmarshal subscription for chatID=%d: %w", sub.ChatID, err)
+			}
+			version = stored.Version
+		}
+		if sub.Version != version {
+			return fmt.Errorf("%w: chatID=%d, version=%d, stored=%d", models.ErrConflict, sub.ChatID, sub.Version, version)
+		}
+		sub.Version++
+
 		data, err := json.Marshal(&sub)
 		if err != nil {
 			return fmt.Errorf("failed to marshal subscription for chatID=%d: %w", sub.ChatID, err)
@@ -99,27 +147,177 @@ func (s *BoltDBStore) SubscriptionPut(sub models.Subscription) (models.Subscript
 	return sub, err
 }
 
-func (s *BoltDBStore) SubscriptionPurge(chatID int64) error {
-	ns, err := s.NotificationGetAll()
-	if err != nil {
-		return fmt.Errorf("failed to get queued notifications: %w", err)
-	}
-
+// SubscriptionRecordNotification counts notification delivered to the chat. It is a single transaction, so it doesn't
+// overwrite concurrent subscription changes
+func (s *BoltDBStore) SubscriptionRecordNotification(chatID int64, at time.Time) error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(subscriptionsBucket))
 
-		if err := b.Delete(i64tob(chatID)); err != nil {
+		id := i64tob(chatID)
+		data := b.Get(id)
+		if data == nil {
+			return nil
+		}
+		var sub models.Subscription
+		if err := json.Unmarshal(data, &sub); err != nil {
+			return fmt.Errorf("failed to unmarshal subscription for chatID=%d: %w", chatID, err)
+		}
+		sub.NotificationsSent++
+		sub.LastDeliveredAt = at
+		sub.Version++
+
+		data, err := json.Marshal(&sub)
+		if err != nil {
+			return fmt.Errorf("failed to marshal subscription for chatID=%d: %w", chatID, err)
+		}
+		return b.Put(id, data)
+	})
+}
+
+// SubscriptionPurge removes subscription and all queued notifications of the chat in a single transaction
+func (s *BoltDBStore) SubscriptionPurge(chatID int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(subscriptionsBucket)).Delete(i64tob(chatID)); err != nil {
 			return fmt.Errorf("failed to delete subscriber with id=%d: %w", chatID, err)
 		}
+		if err := tx.Bucket([]byte(countdownPostsBucket)).Delete(i64tob(chatID)); err != nil {
+			return fmt.Errorf("failed to delete countdown post of subscriber with id=%d: %w", chatID, err)
+		}
+		if err := tx.Bucket([]byte(deliveryLogBucket)).Delete(i64tob(chatID)); err != nil {
+			return fmt.Errorf("failed to delete delivery log of subscriber with id=%d: %w", chatID, err)
+		}
 
-		b = tx.Bucket([]byte(notificationsBucket))
-		for _, n := range ns {
-			if n.Target != chatID {
-				continue
+		b := tx.Bucket([]byte(notificationsBucket))
+		// keys are collected first, as deleting while iterating with a cursor skips records
+		var keys [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			var n models.Notification
+			if err := json.Unmarshal(v, &n); err != nil {
+				return fmt.Errorf("failed to unmarshal notification: %w", err)
+			}
+			if n.Target == chatID {
+				keys = append(keys, k)
 			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return fmt.Errorf("failed to delete notification with id=%d: %w", binary.BigEndian.Uint64(k), err)
+			}
+		}
+
+		return nil
+	})
+}
 
-			if err := b.Delete(itob(n.ID)); err != nil {
-				return fmt.Errorf("failed to delete notification with id=%d: %w", n.ID, err)
+// SubscriptionUpdateAll applies fn to every subscription in a single transaction and stores the ones fn reports as
+// changed. It returns the number of changed subscriptions
+func (s *BoltDBStore) SubscriptionUpdateAll(fn func(sub *models.Subscription) bool) (int, error) {
+	changed := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(subscriptionsBucket))
+		updates := make(map[string][]byte)
+		if err := b.ForEach(func(k, v []byte) error {
+			var sub models.Subscription
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return fmt.Errorf("failed to unmarshal subscription: %w", err)
+			}
+			if !fn(&sub) {
+				return nil
+			}
+			sub.Version++
+			data, err := json.Marshal(&sub)
+			if err != nil {
+				return fmt.Errorf("failed to marshal subscription for chatID=%d: %w", sub.ChatID, err)
+			}
+			updates[string(k)] = data
+			return nil
+		}); err != nil {
+			return err
+		}
+		// records are put after iteration, as modifying bucket while iterating with ForEach is not allowed
+		for k, data := range updates {
+			if err := b.Put([]byte(k), data); err != nil {
+				return fmt.Errorf("failed to put subscription: %w", err)
+			}
+		}
+		changed = len(updates)
+		return nil
+	})
+	return changed, err
+}
+
+// SubscriptionMigrate moves subscription, its queued notifications and countdown post of the group chat upgraded to
+// a supergroup to the new chat ID. If the new chat is subscribed already, the old subscription is merged into it, see
+// mergeSubscription
+func (s *BoltDBStore) SubscriptionMigrate(from, to int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		subs := tx.Bucket([]byte(subscriptionsBucket))
+		data := subs.Get(i64tob(from))
+		if data == nil {
+			return nil
+		}
+
+		var sub models.Subscription
+		if err := json.Unmarshal(data, &sub); err != nil {
+			return fmt.Errorf("failed to unmarshal subscription: %w", err)
+		}
+		if existing := subs.Get(i64tob(to)); existing == nil {
+			sub.ChatID = to
+			sub.ChatType = models.ChatTypeSuperGroup
+			// messages of the old chat can't be edited or deleted through the new chat ID
+			sub.LastMessageID = 0
+		} else {
+			var target models.Subscription
+			if err := json.Unmarshal(existing, &target); err != nil {
+				return fmt.Errorf("failed to unmarshal subscription: %w", err)
+			}
+			mergeSubscription(&target, sub)
+			sub = target
+		}
+		migrated, err := json.Marshal(sub)
+		if err != nil {
+			return fmt.Errorf("failed to marshal subscription: %w", err)
+		}
+		if err = subs.Put(i64tob(to), migrated); err != nil {
+			return fmt.Errorf("failed to put subscription with id=%d: %w", to, err)
+		}
+		if err := subs.Delete(i64tob(from)); err != nil {
+			return fmt.Errorf("failed to delete subscription with id=%d: %w", from, err)
+		}
+		if err := tx.Bucket([]byte(countdownPostsBucket)).Delete(i64tob(from)); err != nil {
+			return fmt.Errorf("failed to delete countdown post of subscription with id=%d: %w", from, err)
+		}
+		if err := tx.Bucket([]byte(deliveryLogBucket)).Delete(i64tob(from)); err != nil {
+			return fmt.Errorf("failed to delete delivery log of subscription with id=%d: %w", from, err)
+		}
+
+		b := tx.Bucket([]byte(notificationsBucket))
+		// notifications are collected first, as modifying while iterating with a cursor is not safe
+		retargeted := make(map[string][]byte)
+		if err := b.ForEach(func(k, v []byte) error {
+			var n models.Notification
+			if err := json.Unmarshal(v, &n); err != nil {
+				return fmt.Errorf("failed to unmarshal notification: %w", err)
+			}
+			if n.Target != from {
+				return nil
+			}
+			n.Target = to
+			data, err := json.Marshal(n)
+			if err != nil {
+				return fmt.Errorf("failed to marshal notification: %w", err)
+			}
+			retargeted[string(k)] = data
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k, v := range retargeted {
+			if err := b.Put([]byte(k), v); err != nil {
+				return fmt.Errorf("failed to put notification with id=%d: %w", binary.BigEndian.Uint64([]byte(k)), err)
 			}
 		}
 
@@ -127,6 +325,32 @@ func (s *BoltDBStore) SubscriptionPurge(chatID int64) error {
 	})
 }
 
+// mergeSubscription adds groups of the old chat subscription, with their mutes, to the subscription of the new chat.
+// Settings and delivery state of the new chat are kept, as they are the latest choice of the chat
+func mergeSubscription(dst *models.Subscription, src models.Subscription) {
+	if dst.Groups == nil {
+		dst.Groups = make(map[string]string, len(src.Groups))
+	}
+	for groupNum, hash := range src.Groups {
+		if _, ok := dst.Groups[groupNum]; ok {
+			continue
+		}
+		dst.Groups[groupNum] = hash
+		if until, ok := src.MutedGroups[groupNum]; ok {
+			if dst.MutedGroups == nil {
+				dst.MutedGroups = make(map[string]time.Time)
+			}
+			dst.MutedGroups[groupNum] = until
+		}
+	}
+	dst.UpdatesSent += src.UpdatesSent
+	dst.NotificationsSent += src.NotificationsSent
+	if src.CreatedAt.Before(dst.CreatedAt) && !src.CreatedAt.IsZero() {
+		dst.CreatedAt = src.CreatedAt
+	}
+	dst.Version++
+}
+
 func (s *BoltDBStore) ShutdownsTableGet(key string) (models.ShutdownsTable, bool, error) {
 	var res models.ShutdownsTable
 	found := false
@@ -155,6 +379,119 @@ func (s *BoltDBStore) ShutdownsTablePut(t models.ShutdownsTable) (models.Shutdow
 	return t, err
 }
 
+// ScheduleRevisionAdd appends the revision to the date revisions unless it is the same as the latest one. Dates with
+// the latest revision older than retention are removed in the same transaction
+func (s *BoltDBStore) ScheduleRevisionAdd(date string, rev models.ScheduleRevision) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(scheduleRevisionsBucket))
+
+		var revs []models.ScheduleRevision
+		if data := b.Get([]byte(date)); data != nil {
+			if err := json.Unmarshal(data, &revs); err != nil {
+				return fmt.Errorf("failed to unmarshal schedule revisions of date=%s: %w", date, err)
+			}
+		}
+		if len(revs) > 0 && revs[len(revs)-1].Hash == rev.Hash {
+			return nil
+		}
+		revs = append(revs, rev)
+		if len(revs) > scheduleRevisionsCapacity {
+			revs = revs[len(revs)-scheduleRevisionsCapacity:]
+		}
+		data, err := json.Marshal(revs)
+		if err != nil {
+			return fmt.Errorf("failed to marshal schedule revisions: %w", err)
+		}
+		if err = b.Put([]byte(date), data); err != nil {
+			return err
+		}
+
+		// keys are collected first, as deleting while iterating with a cursor skips records
+		var expired [][]byte
+		if err = b.ForEach(func(k, v []byte) error {
+			var revs []models.ScheduleRevision
+			if err := json.Unmarshal(v, &revs); err != nil {
+				return fmt.Errorf("failed to unmarshal schedule revisions of date=%s: %w", k, err)
+			}
+			if len(revs) == 0 || rev.FirstSeen.Sub(revs[len(revs)-1].FirstSeen) > scheduleRevisionsRetention {
+				expired = append(expired, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err = b.Delete(k); err != nil {
+				return fmt.Errorf("failed to delete schedule revisions of date=%s: %w", k, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltDBStore) ScheduleRevisionGetAll(date string) ([]models.ScheduleRevision, error) {
+	res := make([]models.ScheduleRevision, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(scheduleRevisionsBucket)).Get([]byte(date))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &res)
+	})
+	return res, err
+}
+
+// DeliveryLogAdd appends entries of multiple chats in a single transaction. Entry with the same outcome as the latest
+// one of the chat is skipped, so a chat gets a new entry only when its outcome changes
+func (s *BoltDBStore) DeliveryLogAdd(entries map[int64]models.DeliveryLogEntry) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(deliveryLogBucket))
+		for chatID, entry := range entries {
+			id := i64tob(chatID)
+			var log []models.DeliveryLogEntry
+			if data := b.Get(id); data != nil {
+				if err := json.Unmarshal(data, &log); err != nil {
+					return fmt.Errorf("failed to unmarshal delivery log of chatID=%d: %w", chatID, err)
+				}
+			}
+			if len(log) > 0 && log[len(log)-1].SameOutcome(entry) {
+				continue
+			}
+
+			log = append(log, entry)
+			first := 0
+			// entry lasts until the next one starts
+			for first < len(log)-1 && entry.At.Sub(log[first+1].At) > deliveryLogRetention {
+				first++
+			}
+			if len(log)-first > deliveryLogCapacity {
+				first = len(log) - deliveryLogCapacity
+			}
+			data, err := json.Marshal(log[first:])
+			if err != nil {
+				return fmt.Errorf("failed to marshal delivery log of chatID=%d: %w", chatID, err)
+			}
+			if err = b.Put(id, data); err != nil {
+				return fmt.Errorf("failed to put delivery log of chatID=%d: %w", chatID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeliveryLogGet returns delivery log of the chat starting from the oldest entry
+func (s *BoltDBStore) DeliveryLogGet(chatID int64) ([]models.DeliveryLogEntry, error) {
+	res := make([]models.DeliveryLogEntry, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(deliveryLogBucket)).Get(i64tob(chatID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &res)
+	})
+	return res, err
+}
+
 func (s *BoltDBStore) NotificationGetAll() ([]models.Notification, error) {
 	res := make([]models.Notification, 0)
 	err := s.db.View(func(tx *bbolt.Tx) error {
@@ -194,6 +531,313 @@ func (s *BoltDBStore) NotificationDelete(id int) error {
 	})
 }
 
+func (s *BoltDBStore) ChannelPostGet(channelID int64) (models.ChannelPost, bool, error) {
+	var res models.ChannelPost
+	found := false
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(channelPostsBucket)).Get(i64tob(channelID))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &res)
+	})
+
+	return res, found, err
+}
+
+func (s *BoltDBStore) ChannelPostPut(p models.ChannelPost) (models.ChannelPost, error) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("failed to marshal channel post: %w", err)
+		}
+		return tx.Bucket([]byte(channelPostsBucket)).Put(i64tob(p.ChannelID), data)
+	})
+
+	return p, err
+}
+
+func (s *BoltDBStore) CountdownPostGet(chatID int64) (models.CountdownPost, bool, error) {
+	var res models.CountdownPost
+	found := false
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(countdownPostsBucket)).Get(i64tob(chatID))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &res)
+	})
+
+	return res, found, err
+}
+
+func (s *BoltDBStore) CountdownPostPut(p models.CountdownPost) (models.CountdownPost, error) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("failed to marshal countdown post: %w", err)
+		}
+		return tx.Bucket([]byte(countdownPostsBucket)).Put(i64tob(p.ChatID), data)
+	})
+
+	return p, err
+}
+
+func (s *BoltDBStore) CountdownPostDelete(chatID int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(countdownPostsBucket)).Delete(i64tob(chatID))
+	})
+}
+
+func (s *BoltDBStore) MaintenanceGet() (models.Maintenance, error) {
+	var res models.Maintenance
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(appStateBucket)).Get([]byte(maintenanceKey))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &res)
+	})
+
+	return res, err
+}
+
+func (s *BoltDBStore) MaintenancePut(m models.Maintenance) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed to marshal maintenance: %w", err)
+		}
+		return tx.Bucket([]byte(appStateBucket)).Put([]byte(maintenanceKey), data)
+	})
+}
+
+func (s *BoltDBStore) TombstoneGet(chatID int64) (models.Tombstone, bool, error) {
+	var res models.Tombstone
+	found := false
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(tombstonesBucket)).Get(i64tob(chatID))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &res)
+	})
+
+	return res, found, err
+}
+
+func (s *BoltDBStore) TombstoneGetAll() ([]models.Tombstone, error) {
+	var res []models.Tombstone
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(tombstonesBucket)).ForEach(func(_, v []byte) error {
+			var t models.Tombstone
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("failed to unmarshal tombstone: %w", err)
+			}
+			res = append(res, t)
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (s *BoltDBStore) TombstonePut(t models.Tombstone) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Errorf("failed to marshal tombstone for chatID=%d: %w", t.ChatID, err)
+		}
+		return tx.Bucket([]byte(tombstonesBucket)).Put(i64tob(t.ChatID), data)
+	})
+}
+
+func (s *BoltDBStore) TombstoneDelete(chatID int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(tombstonesBucket)).Delete(i64tob(chatID))
+	})
+}
+
+// PurgeLogAdd appends the event removing the oldest one when capacity is exceeded
+func (s *BoltDBStore) PurgeLogAdd(e models.PurgeEvent) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(purgeLogBucket))
+		id, _ := b.NextSequence() //nolint:errcheck
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to marshal purge event: %w", err)
+		}
+		if err = b.Put(itob(int(id)), data); err != nil {
+			return fmt.Errorf("failed to put purge event: %w", err)
+		}
+		if id > purgeLogCapacity {
+			return b.Delete(itob(int(id - purgeLogCapacity)))
+		}
+		return nil
+	})
+}
+
+// PurgeLogGetAll returns purge events starting from the latest one
+func (s *BoltDBStore) PurgeLogGetAll() ([]models.PurgeEvent, error) {
+	res := make([]models.PurgeEvent, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(purgeLogBucket)).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var e models.PurgeEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("failed to unmarshal purge event: %w", err)
+			}
+			res = append(res, e)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s *BoltDBStore) SuspendedGroupsGet() (map[string]models.GroupSuspension, error) {
+	res := make(map[string]models.GroupSuspension)
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(appStateBucket)).Get([]byte(suspendedGroupsKey))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &res)
+	})
+
+	return res, err
+}
+
+func (s *BoltDBStore) SuspendedGroupsPut(groups map[string]models.GroupSuspension) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(groups)
+		if err != nil {
+			return fmt.Errorf("failed to marshal suspended groups: %w", err)
+		}
+		return tx.Bucket([]byte(appStateBucket)).Put([]byte(suspendedGroupsKey), data)
+	})
+}
+
+// ImportNonceUse stores the nonce unless it is stored already. Expired nonces are removed in the same transaction,
+// as there are only a few of them
+func (s *BoltDBStore) ImportNonceUse(nonce string, expiresAt time.Time) (bool, error) {
+	fresh := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(importNoncesBucket))
+		if b.Get([]byte(nonce)) != nil {
+			return nil
+		}
+
+		now := time.Now()
+		var expired [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			var t time.Time
+			if err := t.UnmarshalText(v); err != nil {
+				return fmt.Errorf("failed to unmarshal nonce=%s expiration: %w", k, err)
+			}
+			if !now.Before(t) {
+				expired = append(expired, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return fmt.Errorf("failed to delete expired nonce=%s: %w", k, err)
+			}
+		}
+
+		data, err := expiresAt.MarshalText()
+		if err != nil {
+			return fmt.Errorf("failed to marshal nonce expiration: %w", err)
+		}
+		if err = b.Put([]byte(nonce), data); err != nil {
+			return fmt.Errorf("failed to put nonce: %w", err)
+		}
+		fresh = true
+		return nil
+	})
+	return fresh, err
+}
+
+// ImportNonceRelease deletes the nonce, e.g. when the import using it failed
+func (s *BoltDBStore) ImportNonceRelease(nonce string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(importNoncesBucket)).Delete([]byte(nonce)); err != nil {
+			return fmt.Errorf("failed to delete nonce: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *BoltDBStore) DailySummaryGet() (models.DailySummary, error) {
+	var res models.DailySummary
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(appStateBucket)).Get([]byte(dailySummaryKey))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &res)
+	})
+
+	return res, err
+}
+
+func (s *BoltDBStore) DailySummaryPut(summary models.DailySummary) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return fmt.Errorf("failed to marshal daily summary: %w", err)
+		}
+		return tx.Bucket([]byte(appStateBucket)).Put([]byte(dailySummaryKey), data)
+	})
+}
+
+// dbStatsBudget limits time spent on gathering storage stats, so a huge database doesn't stall the caller
+const dbStatsBudget = 2 * time.Second
+
+// DBStats returns per bucket key counts and sizes, database file size and ratio of free pages gathered in a single
+// read transaction
+func (s *BoltDBStore) DBStats() (models.DBStats, error) {
+	var res models.DBStats
+	deadline := time.Now().Add(dbStatsBudget)
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		res.FileSize = tx.Size()
+		if res.FileSize > 0 {
+			dbStats := s.db.Stats()
+			free := int64(dbStats.FreePageN+dbStats.PendingPageN) * int64(s.db.Info().PageSize)
+			res.FreePageRatio = float64(free) / float64(res.FileSize)
+		}
+
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if time.Now().After(deadline) {
+				res.Partial = true
+				return nil
+			}
+			stats := b.Stats()
+			res.Buckets = append(res.Buckets, models.BucketStats{
+				Name: string(name),
+				Keys: stats.KeyN,
+				Size: stats.BranchInuse + stats.LeafInuse + stats.InlineBucketInuse,
+			})
+			return nil
+		})
+	})
+
+	return res, err
+}
+
 func (s *BoltDBStore) Close() error {
 	return s.db.Close()
 }
@@ -215,13 +859,43 @@ func NewBoltDBStore(path string) *BoltDBStore {
 		panic(fmt.Errorf("open bolt db: %w", err))
 	}
 
-	mustBucket(db, shutdownsBucket)
-	mustBucket(db, subscriptionsBucket)
-	mustBucket(db, notificationsBucket)
+	for _, name := range buckets {
+		mustBucket(db, name)
+	}
 
 	return &BoltDBStore{db: db}
 }
 
+// NewReadOnlyBoltDBStore opens existing database, e.g. a synced copy on a standby host, without taking a write lock.
+// All writes fail with bbolt.ErrDatabaseReadOnly
+func NewReadOnlyBoltDBStore(path string) *BoltDBStore {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true}) //nolint:gomnd
+	if err != nil {
+		slog.Error("failed to open bolt db in read only mode", "error", err, "path", path)
+		panic(fmt.Errorf("open bolt db in read only mode: %w", err))
+	}
+
+	return &BoltDBStore{db: db}
+}
+
+// Check verifies that all expected buckets exist and can be read. Read only store doesn't create buckets, so
+// a database copy from an older version fails here instead of on every scheduled task
+func (s *BoltDBStore) Check() error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		var errs []error
+		for _, name := range buckets {
+			b := tx.Bucket([]byte(name))
+			if b == nil {
+				errs = append(errs, fmt.Errorf("bucket %q not found", name))
+				continue
+			}
+			// a sample read makes sure bucket pages are readable
+			b.Cursor().First()
+		}
+		return errors.Join(errs...)
+	})
+}
+
 func mustBucket(db *bbolt.DB, name string) {
 	if err := db.Update(func(tx *bbolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(name))
@@ -256,10 +930,22 @@ func (r *SubscriptionBoltDBRepo) Put(sub models.Subscription) (models.Subscripti
 	return r.delegate.SubscriptionPut(sub)
 }
 
+func (r *SubscriptionBoltDBRepo) RecordNotification(chatID int64, at time.Time) error {
+	return r.delegate.SubscriptionRecordNotification(chatID, at)
+}
+
 func (r *SubscriptionBoltDBRepo) Purge(chatID int64) error {
 	return r.delegate.SubscriptionPurge(chatID)
 }
 
+func (r *SubscriptionBoltDBRepo) Migrate(from, to int64) error {
+	return r.delegate.SubscriptionMigrate(from, to)
+}
+
+func (r *SubscriptionBoltDBRepo) UpdateAll(fn func(sub *models.Subscription) bool) (int, error) {
+	return r.delegate.SubscriptionUpdateAll(fn)
+}
+
 func NewSubscriptionRepo(delegate *BoltDBStore) *SubscriptionBoltDBRepo {
 	return &SubscriptionBoltDBRepo{delegate: delegate}
 }
@@ -280,6 +966,38 @@ func NewShutdownsRepo(delegate *BoltDBStore) *ShutdownBoltsDBRepo {
 	return &ShutdownBoltsDBRepo{delegate: delegate}
 }
 
+type DeliveryLogRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *DeliveryLogRepo) Add(entries map[int64]models.DeliveryLogEntry) error {
+	return r.delegate.DeliveryLogAdd(entries)
+}
+
+func (r *DeliveryLogRepo) Get(chatID int64) ([]models.DeliveryLogEntry, error) {
+	return r.delegate.DeliveryLogGet(chatID)
+}
+
+func NewDeliveryLogRepo(delegate *BoltDBStore) *DeliveryLogRepo {
+	return &DeliveryLogRepo{delegate: delegate}
+}
+
+type ScheduleRevisionRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *ScheduleRevisionRepo) Add(date string, rev models.ScheduleRevision) error {
+	return r.delegate.ScheduleRevisionAdd(date, rev)
+}
+
+func (r *ScheduleRevisionRepo) GetAll(date string) ([]models.ScheduleRevision, error) {
+	return r.delegate.ScheduleRevisionGetAll(date)
+}
+
+func NewScheduleRevisionRepo(delegate *BoltDBStore) *ScheduleRevisionRepo {
+	return &ScheduleRevisionRepo{delegate: delegate}
+}
+
 type NotificationRepo struct {
 	delegate *BoltDBStore
 }
@@ -299,3 +1017,143 @@ func (r *NotificationRepo) Delete(id int) error {
 func NewNotificationRepo(delegate *BoltDBStore) *NotificationRepo {
 	return &NotificationRepo{delegate: delegate}
 }
+
+type ChannelPostRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *ChannelPostRepo) Get(channelID int64) (models.ChannelPost, bool, error) {
+	return r.delegate.ChannelPostGet(channelID)
+}
+
+func (r *ChannelPostRepo) Put(p models.ChannelPost) (models.ChannelPost, error) {
+	return r.delegate.ChannelPostPut(p)
+}
+
+func NewChannelPostRepo(delegate *BoltDBStore) *ChannelPostRepo {
+	return &ChannelPostRepo{delegate: delegate}
+}
+
+type CountdownPostRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *CountdownPostRepo) Get(chatID int64) (models.CountdownPost, bool, error) {
+	return r.delegate.CountdownPostGet(chatID)
+}
+
+func (r *CountdownPostRepo) Put(p models.CountdownPost) (models.CountdownPost, error) {
+	return r.delegate.CountdownPostPut(p)
+}
+
+func (r *CountdownPostRepo) Delete(chatID int64) error {
+	return r.delegate.CountdownPostDelete(chatID)
+}
+
+func NewCountdownPostRepo(delegate *BoltDBStore) *CountdownPostRepo {
+	return &CountdownPostRepo{delegate: delegate}
+}
+
+type MaintenanceRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *MaintenanceRepo) Get() (models.Maintenance, error) {
+	return r.delegate.MaintenanceGet()
+}
+
+func (r *MaintenanceRepo) Put(m models.Maintenance) error {
+	return r.delegate.MaintenancePut(m)
+}
+
+func NewMaintenanceRepo(delegate *BoltDBStore) *MaintenanceRepo {
+	return &MaintenanceRepo{delegate: delegate}
+}
+
+type TombstoneRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *TombstoneRepo) Get(chatID int64) (models.Tombstone, bool, error) {
+	return r.delegate.TombstoneGet(chatID)
+}
+
+func (r *TombstoneRepo) GetAll() ([]models.Tombstone, error) {
+	return r.delegate.TombstoneGetAll()
+}
+
+func (r *TombstoneRepo) Put(t models.Tombstone) error {
+	return r.delegate.TombstonePut(t)
+}
+
+func (r *TombstoneRepo) Delete(chatID int64) error {
+	return r.delegate.TombstoneDelete(chatID)
+}
+
+func NewTombstoneRepo(delegate *BoltDBStore) *TombstoneRepo {
+	return &TombstoneRepo{delegate: delegate}
+}
+
+type PurgeLogRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *PurgeLogRepo) Add(e models.PurgeEvent) error {
+	return r.delegate.PurgeLogAdd(e)
+}
+
+func (r *PurgeLogRepo) GetAll() ([]models.PurgeEvent, error) {
+	return r.delegate.PurgeLogGetAll()
+}
+
+func NewPurgeLogRepo(delegate *BoltDBStore) *PurgeLogRepo {
+	return &PurgeLogRepo{delegate: delegate}
+}
+
+type SuspendedGroupsRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *SuspendedGroupsRepo) Get() (map[string]models.GroupSuspension, error) {
+	return r.delegate.SuspendedGroupsGet()
+}
+
+func (r *SuspendedGroupsRepo) Put(groups map[string]models.GroupSuspension) error {
+	return r.delegate.SuspendedGroupsPut(groups)
+}
+
+func NewSuspendedGroupsRepo(delegate *BoltDBStore) *SuspendedGroupsRepo {
+	return &SuspendedGroupsRepo{delegate: delegate}
+}
+
+type ImportNonceRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *ImportNonceRepo) Use(nonce string, expiresAt time.Time) (bool, error) {
+	return r.delegate.ImportNonceUse(nonce, expiresAt)
+}
+
+func (r *ImportNonceRepo) Release(nonce string) error {
+	return r.delegate.ImportNonceRelease(nonce)
+}
+
+func NewImportNonceRepo(delegate *BoltDBStore) *ImportNonceRepo {
+	return &ImportNonceRepo{delegate: delegate}
+}
+
+type DailySummaryRepo struct {
+	delegate *BoltDBStore
+}
+
+func (r *DailySummaryRepo) Get() (models.DailySummary, error) {
+	return r.delegate.DailySummaryGet()
+}
+
+func (r *DailySummaryRepo) Put(summary models.DailySummary) error {
+	return r.delegate.DailySummaryPut(summary)
+}
+
+func NewDailySummaryRepo(delegate *BoltDBStore) *DailySummaryRepo {
+	return &DailySummaryRepo{delegate: delegate}
+}
@@ -0,0 +1,382 @@
+package dal
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+func TestBoltDBStore_SubscriptionPurge(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	for _, chatID := range []int64{1, 2} {
+		if _, err := store.SubscriptionPut(models.Subscription{ChatID: chatID, Groups: map[string]string{"1": ""}}); err != nil {
+			t.Fatalf("SubscriptionPut() error = %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := store.NotificationPut(models.Notification{Target: chatID, Msg: "msg"}); err != nil {
+				t.Fatalf("NotificationPut() error = %v", err)
+			}
+		}
+	}
+
+	if err := store.SubscriptionPurge(1); err != nil {
+		t.Fatalf("SubscriptionPurge() error = %v", err)
+	}
+
+	if exists, _ := store.SubscriptionExists(1); exists {
+		t.Errorf("purged subscription exists")
+	}
+	if exists, _ := store.SubscriptionExists(2); !exists {
+		t.Errorf("other subscription is purged")
+	}
+	ns, err := store.NotificationGetAll()
+	if err != nil {
+		t.Fatalf("NotificationGetAll() error = %v", err)
+	}
+	if len(ns) != 3 {
+		t.Errorf("notifications left = %d, want 3", len(ns))
+	}
+	for _, n := range ns {
+		if n.Target == 1 {
+			t.Errorf("notification %d of purged chat is left", n.ID)
+		}
+	}
+}
+
+func TestBoltDBStore_SubscriptionRecordNotification(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	sub := models.Subscription{ChatID: 1, Groups: map[string]string{"1": "hash1"}, UpdatesSent: 5}
+	if _, err := store.SubscriptionPut(sub); err != nil {
+		t.Fatalf("SubscriptionPut() error = %v", err)
+	}
+
+	at := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := store.SubscriptionRecordNotification(1, at); err != nil {
+			t.Fatalf("SubscriptionRecordNotification() error = %v", err)
+		}
+	}
+	if err := store.SubscriptionRecordNotification(2, at); err != nil {
+		t.Fatalf("SubscriptionRecordNotification() of missing subscription error = %v", err)
+	}
+
+	got, _, err := store.SubscriptionGet(1)
+	if err != nil {
+		t.Fatalf("SubscriptionGet() error = %v", err)
+	}
+	if got.NotificationsSent != 2 || !got.LastDeliveredAt.Equal(at) {
+		t.Errorf("NotificationsSent = %d, LastDeliveredAt = %v, want 2, %v", got.NotificationsSent, got.LastDeliveredAt, at)
+	}
+	if got.UpdatesSent != 5 || got.Groups["1"] != "hash1" {
+		t.Errorf("other fields changed: %+v", got)
+	}
+	if exists, _ := store.SubscriptionExists(2); exists {
+		t.Errorf("missing subscription is created")
+	}
+}
+
+func TestBoltDBStore_DBStats(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.NotificationPut(models.Notification{Target: 1, Msg: "msg"}); err != nil {
+			t.Fatalf("NotificationPut() error = %v", err)
+		}
+	}
+
+	stats, err := store.DBStats()
+	if err != nil {
+		t.Fatalf("DBStats() error = %v", err)
+	}
+	if stats.FileSize == 0 || stats.Partial {
+		t.Errorf("DBStats() file size = %d, partial = %t", stats.FileSize, stats.Partial)
+	}
+	keys := make(map[string]int)
+	for _, b := range stats.Buckets {
+		keys[b.Name] = b.Keys
+	}
+	if keys[notificationsBucket] != 3 || keys[subscriptionsBucket] != 0 {
+		t.Errorf("DBStats() bucket keys = %v", keys)
+	}
+}
+
+func TestReadOnlyBoltDBStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store := NewBoltDBStore(path)
+	if _, err := store.SubscriptionPut(models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}}); err != nil {
+		t.Fatalf("SubscriptionPut() error = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	store = NewReadOnlyBoltDBStore(path)
+	defer store.Close()
+
+	if _, ok, err := store.SubscriptionGet(1); err != nil || !ok {
+		t.Fatalf("SubscriptionGet() = %v, %v, want existing subscription", ok, err)
+	}
+
+	writes := map[string]func() error{
+		"SubscriptionPut": func() error {
+			_, err := store.SubscriptionPut(models.Subscription{ChatID: 2, Groups: map[string]string{"1": ""}})
+			return err
+		},
+		"SubscriptionPurge": func() error { return store.SubscriptionPurge(1) },
+		"MaintenancePut":    func() error { return store.MaintenancePut(models.Maintenance{Enabled: true}) },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, bbolt.ErrDatabaseReadOnly) {
+			t.Errorf("%s() error = %v, want %v", name, err, bbolt.ErrDatabaseReadOnly)
+		}
+	}
+	if exists, _ := store.SubscriptionExists(1); !exists {
+		t.Errorf("subscription is removed from read only store")
+	}
+}
+
+func TestBoltDBStore_ScheduleRevisionAdd(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	start := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	add := func(date, hash string, at time.Time) {
+		t.Helper()
+		if err := store.ScheduleRevisionAdd(date, models.ScheduleRevision{Hash: hash, FirstSeen: at}); err != nil {
+			t.Fatalf("ScheduleRevisionAdd() error = %v", err)
+		}
+	}
+
+	add("10 червня", "a", start)
+	add("10 червня", "a", start.Add(time.Minute))
+	for i := 0; i < scheduleRevisionsCapacity+5; i++ {
+		add("11 червня", strconv.Itoa(i), start.Add(time.Duration(i)*time.Minute))
+	}
+
+	revs, err := store.ScheduleRevisionGetAll("10 червня")
+	if err != nil {
+		t.Fatalf("ScheduleRevisionGetAll() error = %v", err)
+	}
+	if len(revs) != 1 || !revs[0].FirstSeen.Equal(start) {
+		t.Errorf("revisions = %v, want the first one only", revs)
+	}
+	revs, _ = store.ScheduleRevisionGetAll("11 червня")
+	if len(revs) != scheduleRevisionsCapacity || revs[0].Hash != "5" {
+		t.Errorf("revisions = %d starting with %s, want %d starting with 5", len(revs), revs[0].Hash,
+			scheduleRevisionsCapacity)
+	}
+
+	add("20 червня", "b", start.Add(scheduleRevisionsRetention+time.Hour))
+	if revs, _ = store.ScheduleRevisionGetAll("10 червня"); len(revs) != 0 {
+		t.Errorf("expired revisions = %v, want none", revs)
+	}
+}
+
+func TestBoltDBStore_SubscriptionMigrate(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	const from, to = int64(-123), int64(-100123)
+	sub := models.Subscription{ChatID: from, ChatType: models.ChatTypeGroup, Groups: map[string]string{"1": "hash"},
+		LastMessageID: 10}
+	if _, err := store.SubscriptionPut(sub); err != nil {
+		t.Fatalf("SubscriptionPut() error = %v", err)
+	}
+	for _, target := range []int64{from, 1} {
+		if _, err := store.NotificationPut(models.Notification{Target: target, Msg: "msg"}); err != nil {
+			t.Fatalf("NotificationPut() error = %v", err)
+		}
+	}
+	if _, err := store.CountdownPostPut(models.CountdownPost{ChatID: from, MessageID: 11}); err != nil {
+		t.Fatalf("CountdownPostPut() error = %v", err)
+	}
+
+	if err := store.SubscriptionMigrate(from, to); err != nil {
+		t.Fatalf("SubscriptionMigrate() error = %v", err)
+	}
+
+	if exists, _ := store.SubscriptionExists(from); exists {
+		t.Errorf("old subscription exists")
+	}
+	got, ok, err := store.SubscriptionGet(to)
+	if err != nil || !ok {
+		t.Fatalf("SubscriptionGet() = %v, %v, want migrated subscription", ok, err)
+	}
+	if got.ChatType != models.ChatTypeSuperGroup || got.Groups["1"] != "hash" || got.LastMessageID != 0 {
+		t.Errorf("migrated subscription = %+v", got)
+	}
+	ns, _ := store.NotificationGetAll()
+	targets := map[int64]int{}
+	for _, n := range ns {
+		targets[n.Target]++
+	}
+	if targets[to] != 1 || targets[from] != 0 || targets[1] != 1 {
+		t.Errorf("notification targets = %v, want the old chat retargeted", targets)
+	}
+	if _, ok, _ := store.CountdownPostGet(from); ok {
+		t.Errorf("countdown post of the old chat is left")
+	}
+
+	// repeated migration, e.g. from a concurrent send, is a no-op
+	if err = store.SubscriptionMigrate(from, to); err != nil {
+		t.Fatalf("SubscriptionMigrate() error = %v", err)
+	}
+	if exists, _ := store.SubscriptionExists(to); !exists {
+		t.Errorf("migrated subscription is removed")
+	}
+}
+
+func TestBoltDBStore_SubscriptionMigrate_Merge(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	const from, to = int64(-123), int64(-100123)
+	mutedUntil := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	for _, sub := range []models.Subscription{
+		{ChatID: from, Groups: map[string]string{"1": "old", "2": "hash2"}, Settings: models.Settings{KeepHistory: true},
+			MutedGroups: map[string]time.Time{"2": mutedUntil}, UpdatesSent: 3},
+		{ChatID: to, Groups: map[string]string{"1": "new"}, Settings: models.Settings{AlwaysSilent: true},
+			LastMessageID: 20, UpdatesSent: 2},
+	} {
+		if _, err := store.SubscriptionPut(sub); err != nil {
+			t.Fatalf("SubscriptionPut() error = %v", err)
+		}
+	}
+
+	if err := store.SubscriptionMigrate(from, to); err != nil {
+		t.Fatalf("SubscriptionMigrate() error = %v", err)
+	}
+
+	if exists, _ := store.SubscriptionExists(from); exists {
+		t.Errorf("old subscription exists")
+	}
+	got, _, err := store.SubscriptionGet(to)
+	if err != nil {
+		t.Fatalf("SubscriptionGet() error = %v", err)
+	}
+	want := map[string]string{"1": "new", "2": "hash2"}
+	if !reflect.DeepEqual(got.Groups, want) {
+		t.Errorf("merged groups = %v, want %v", got.Groups, want)
+	}
+	if !got.MutedGroups["2"].Equal(mutedUntil) {
+		t.Errorf("merged muted groups = %v, want group 2 muted", got.MutedGroups)
+	}
+	if !got.Settings.AlwaysSilent || got.Settings.KeepHistory || got.LastMessageID != 20 || got.UpdatesSent != 5 {
+		t.Errorf("merged subscription = %+v, want settings and state of the new chat", got)
+	}
+}
+
+func TestBoltDBStore_SubscriptionPut_Conflict(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	sub, err := store.SubscriptionPut(models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}})
+	if err != nil || sub.Version != 1 {
+		t.Fatalf("SubscriptionPut() = %v, %v, want version 1", sub, err)
+	}
+	if _, err = store.SubscriptionPut(sub); err != nil {
+		t.Fatalf("SubscriptionPut() error = %v", err)
+	}
+	// sub is stale now
+	if _, err = store.SubscriptionPut(sub); !errors.Is(err, models.ErrConflict) {
+		t.Errorf("SubscriptionPut() of stale subscription error = %v, want %v", err, models.ErrConflict)
+	}
+	if err = store.SubscriptionRecordNotification(1, time.Now()); err != nil {
+		t.Fatalf("SubscriptionRecordNotification() error = %v", err)
+	}
+	if got, _, _ := store.SubscriptionGet(1); got.Version != 3 {
+		t.Errorf("version = %d after recorded notification, want 3", got.Version)
+	}
+
+	if err = store.SubscriptionPurge(1); err != nil {
+		t.Fatalf("SubscriptionPurge() error = %v", err)
+	}
+	if _, err = store.SubscriptionPut(sub); !errors.Is(err, models.ErrConflict) {
+		t.Errorf("SubscriptionPut() of purged subscription error = %v, want %v", err, models.ErrConflict)
+	}
+}
+
+func TestBoltDBStore_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store := NewBoltDBStore(path)
+	if err := store.Check(); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	if err := store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket([]byte(countdownPostsBucket))
+	}); err != nil {
+		t.Fatalf("failed to delete bucket: %v", err)
+	}
+	store.Close()
+
+	store = NewReadOnlyBoltDBStore(path)
+	defer store.Close()
+	err := store.Check()
+	if err == nil || !strings.Contains(err.Error(), countdownPostsBucket) {
+		t.Errorf("Check() error = %v, want missing %q bucket", err, countdownPostsBucket)
+	}
+}
+
+func TestBoltDBStore_DeliveryLogAdd(t *testing.T) {
+	store := NewBoltDBStore(filepath.Join(t.TempDir(), "test.db"))
+	defer store.Close()
+
+	start := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	add := func(at time.Time, reason models.DeliveryReason) {
+		t.Helper()
+		if err := store.DeliveryLogAdd(map[int64]models.DeliveryLogEntry{
+			1: {At: at, Delivery: models.DeliveryNone, Reason: reason},
+		}); err != nil {
+			t.Fatalf("DeliveryLogAdd() error = %v", err)
+		}
+	}
+
+	add(start, models.ReasonNoChange)
+	add(start.Add(time.Minute), models.ReasonNoChange)
+	add(start.Add(2*time.Minute), models.ReasonMuted)
+	log, err := store.DeliveryLogGet(1)
+	if err != nil {
+		t.Fatalf("DeliveryLogGet() error = %v", err)
+	}
+	if len(log) != 2 || !log[0].At.Equal(start) || log[1].Reason != models.ReasonMuted {
+		t.Errorf("log = %+v, want the same outcome kept once since its first run", log)
+	}
+
+	// entries which ended long ago are removed
+	later := start.Add(deliveryLogRetention + time.Hour)
+	add(later, models.ReasonNoChange)
+	if log, _ = store.DeliveryLogGet(1); len(log) != 2 || log[0].Reason != models.ReasonMuted {
+		t.Errorf("log = %+v, want entry which lasted until now kept", log)
+	}
+	add(later.Add(deliveryLogRetention+time.Hour), models.ReasonMuted)
+	if log, _ = store.DeliveryLogGet(1); len(log) != 2 || !log[0].At.Equal(later) {
+		t.Errorf("log = %+v, want long lasting entry kept", log)
+	}
+
+	for i := 0; i < deliveryLogCapacity*2; i++ {
+		add(later.Add(time.Duration(i)*time.Minute), models.DeliveryReason(strconv.Itoa(i)))
+	}
+	if log, _ = store.DeliveryLogGet(1); len(log) != deliveryLogCapacity {
+		t.Errorf("log size = %d, want %d", len(log), deliveryLogCapacity)
+	}
+
+	if err = store.SubscriptionPurge(1); err != nil {
+		t.Fatalf("SubscriptionPurge() error = %v", err)
+	}
+	if log, _ = store.DeliveryLogGet(1); len(log) != 0 {
+		t.Errorf("log = %+v after purge, want empty", log)
+	}
+}
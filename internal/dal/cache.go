@@ -0,0 +1,132 @@
+package dal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+// SubscriptionRepository is implemented by SubscriptionBoltDBRepo
+type SubscriptionRepository interface {
+	Size() (int, error)
+	Exists(chatID int64) (bool, error)
+	Get(chatID int64) (models.Subscription, bool, error)
+	GetAll() ([]models.Subscription, error)
+	Put(sub models.Subscription) (models.Subscription, error)
+	RecordNotification(chatID int64, at time.Time) error
+	Purge(chatID int64) error
+	Migrate(from, to int64) error
+	UpdateAll(fn func(sub *models.Subscription) bool) (int, error)
+}
+
+// CachedSubscriptionRepo keeps snapshot of all subscriptions for ttl, so periodic tasks running close to each other
+// share one read. The snapshot is invalidated on every write made through the repo
+type CachedSubscriptionRepo struct {
+	delegate SubscriptionRepository
+	ttl      time.Duration
+	now      func() time.Time
+
+	mx        sync.Mutex
+	snapshot  []models.Subscription
+	expiresAt time.Time
+}
+
+func (r *CachedSubscriptionRepo) Size() (int, error) {
+	return r.delegate.Size()
+}
+
+func (r *CachedSubscriptionRepo) Exists(chatID int64) (bool, error) {
+	return r.delegate.Exists(chatID)
+}
+
+func (r *CachedSubscriptionRepo) Get(chatID int64) (models.Subscription, bool, error) {
+	return r.delegate.Get(chatID)
+}
+
+// GetAll returns copies of the snapshot subscriptions, as callers modify them before putting back
+func (r *CachedSubscriptionRepo) GetAll() ([]models.Subscription, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.snapshot == nil || !r.now().Before(r.expiresAt) {
+		subs, err := r.delegate.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		r.snapshot = subs
+		r.expiresAt = r.now().Add(r.ttl)
+	}
+
+	res := make([]models.Subscription, len(r.snapshot))
+	for i, sub := range r.snapshot {
+		res[i] = copySubscription(sub)
+	}
+	return res, nil
+}
+
+func (r *CachedSubscriptionRepo) Put(sub models.Subscription) (models.Subscription, error) {
+	r.invalidate()
+	res, err := r.delegate.Put(sub)
+	r.invalidate()
+	return res, err
+}
+
+func (r *CachedSubscriptionRepo) RecordNotification(chatID int64, at time.Time) error {
+	r.invalidate()
+	err := r.delegate.RecordNotification(chatID, at)
+	r.invalidate()
+	return err
+}
+
+func (r *CachedSubscriptionRepo) Purge(chatID int64) error {
+	r.invalidate()
+	err := r.delegate.Purge(chatID)
+	r.invalidate()
+	return err
+}
+
+func (r *CachedSubscriptionRepo) Migrate(from, to int64) error {
+	r.invalidate()
+	err := r.delegate.Migrate(from, to)
+	r.invalidate()
+	return err
+}
+
+func (r *CachedSubscriptionRepo) UpdateAll(fn func(sub *models.Subscription) bool) (int, error) {
+	r.invalidate()
+	n, err := r.delegate.UpdateAll(fn)
+	r.invalidate()
+	return n, err
+}
+
+// invalidate is called before and after write, so a snapshot read concurrently with the write is not kept
+func (r *CachedSubscriptionRepo) invalidate() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.snapshot = nil
+}
+
+func copySubscription(sub models.Subscription) models.Subscription {
+	groups := make(map[string]string, len(sub.Groups))
+	for k, v := range sub.Groups {
+		groups[k] = v
+	}
+	sub.Groups = groups
+	if sub.MutedGroups != nil {
+		muted := make(map[string]time.Time, len(sub.MutedGroups))
+		for k, v := range sub.MutedGroups {
+			muted[k] = v
+		}
+		sub.MutedGroups = muted
+	}
+	return sub
+}
+
+func NewCachedSubscriptionRepo(delegate SubscriptionRepository, ttl time.Duration) *CachedSubscriptionRepo {
+	return &CachedSubscriptionRepo{
+		delegate: delegate,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
@@ -0,0 +1,110 @@
+package dal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type countingSubscriptionRepo struct {
+	SubscriptionRepository
+	subs  map[int64]models.Subscription
+	reads int
+}
+
+func (r *countingSubscriptionRepo) GetAll() ([]models.Subscription, error) {
+	r.reads++
+	res := make([]models.Subscription, 0, len(r.subs))
+	for _, sub := range r.subs {
+		res = append(res, copySubscription(sub))
+	}
+	return res, nil
+}
+
+func (r *countingSubscriptionRepo) Put(sub models.Subscription) (models.Subscription, error) {
+	r.subs[sub.ChatID] = sub
+	return sub, nil
+}
+
+func (r *countingSubscriptionRepo) Purge(chatID int64) error {
+	delete(r.subs, chatID)
+	return nil
+}
+
+func newCachedFixture() (*CachedSubscriptionRepo, *countingSubscriptionRepo, *time.Time) {
+	delegate := &countingSubscriptionRepo{subs: map[int64]models.Subscription{
+		1: {ChatID: 1, Groups: map[string]string{"1": "hash1"}, MutedGroups: map[string]time.Time{"1": {}}},
+	}}
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	repo := NewCachedSubscriptionRepo(delegate, 10*time.Second)
+	repo.now = func() time.Time { return now }
+	return repo, delegate, &now
+}
+
+func TestCachedSubscriptionRepo_GetAll(t *testing.T) {
+	repo, delegate, now := newCachedFixture()
+
+	subs, _ := repo.GetAll()
+	subs[0].Groups["1"] = "modified"
+	delete(subs[0].MutedGroups, "1")
+	subs, _ = repo.GetAll()
+	if delegate.reads != 1 {
+		t.Errorf("reads = %d, want 1", delegate.reads)
+	}
+	if got := subs[0].Groups["1"]; got != "hash1" {
+		t.Errorf("snapshot is modified by caller, group hash = %q", got)
+	}
+	if _, ok := subs[0].MutedGroups["1"]; !ok {
+		t.Errorf("snapshot is modified by caller, muted groups = %v", subs[0].MutedGroups)
+	}
+
+	*now = now.Add(10 * time.Second)
+	_, _ = repo.GetAll()
+	if delegate.reads != 2 {
+		t.Errorf("reads after ttl = %d, want 2", delegate.reads)
+	}
+}
+
+func TestCachedSubscriptionRepo_Invalidation(t *testing.T) {
+	repo, delegate, _ := newCachedFixture()
+
+	_, _ = repo.GetAll()
+	if _, err := repo.Put(models.Subscription{ChatID: 2, Groups: map[string]string{"2": ""}}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if subs, _ := repo.GetAll(); len(subs) != 2 {
+		t.Errorf("subscriptions after put = %d, want 2", len(subs))
+	}
+
+	if err := repo.Purge(1); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if subs, _ := repo.GetAll(); len(subs) != 1 {
+		t.Errorf("subscriptions after purge = %d, want 1", len(subs))
+	}
+	if delegate.reads != 3 {
+		t.Errorf("reads = %d, want 3", delegate.reads)
+	}
+}
+
+// BenchmarkCachedSubscriptionRepo_GetAll reports delegate reads for two tasks reading subscriptions on every tick
+func BenchmarkCachedSubscriptionRepo_GetAll(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		_, delegate, _ := newCachedFixture()
+		for i := 0; i < b.N; i++ {
+			_, _ = delegate.GetAll()
+			_, _ = delegate.GetAll()
+		}
+		b.ReportMetric(float64(delegate.reads)/float64(b.N), "reads/op")
+	})
+	b.Run("cached", func(b *testing.B) {
+		repo, delegate, now := newCachedFixture()
+		for i := 0; i < b.N; i++ {
+			_, _ = repo.GetAll()
+			_, _ = repo.GetAll()
+			*now = now.Add(5 * time.Second)
+		}
+		b.ReportMetric(float64(delegate.reads)/float64(b.N), "reads/op")
+	})
+}
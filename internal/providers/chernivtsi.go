@@ -26,10 +26,29 @@ func ChernivtsiShutdowns() (models.ShutdownsTable, error) {
 	if err != nil {
 		return models.ShutdownsTable{}, fmt.Errorf("failed to parse shutdowns page: %w", err)
 	}
+	res.UpdatedAt = time.Now()
+	res.Source = url
 
 	return res, nil
 }
 
+// CheckChernivtsiShutdowns makes sure shutdowns page responds
+func CheckChernivtsiShutdowns(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check shutdowns page=%s: %w", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to check shutdowns page=%s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to check shutdowns page=%s: status=%s", url, resp.Status)
+	}
+	return nil
+}
+
 func loadPage() ([]byte, error) {
 	// nolint:gomnd
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
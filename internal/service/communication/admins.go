@@ -0,0 +1,45 @@
+package communication
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type NotificationQueue interface {
+	Put(n models.Notification) (models.Notification, error)
+}
+
+// AdminNotifier queues notifications to bot admins, so they are delivered with the rest of queued notifications
+type AdminNotifier struct {
+	queue NotificationQueue
+
+	adminIDsMx sync.RWMutex
+	adminIDs   []int64
+}
+
+func (n *AdminNotifier) NotifyAdmins(msg string) {
+	n.adminIDsMx.RLock()
+	defer n.adminIDsMx.RUnlock()
+
+	for _, id := range n.adminIDs {
+		if _, err := n.queue.Put(models.Notification{Target: id, Msg: msg, Admin: true}); err != nil {
+			slog.Error("failed to queue admin notification", "error", err, "adminID", id)
+		}
+	}
+}
+
+func (n *AdminNotifier) SetAdminIDs(ids []int64) {
+	n.adminIDsMx.Lock()
+	defer n.adminIDsMx.Unlock()
+
+	n.adminIDs = ids
+}
+
+func NewAdminNotifier(queue NotificationQueue, adminIDs []int64) *AdminNotifier {
+	return &AdminNotifier{
+		queue:    queue,
+		adminIDs: adminIDs,
+	}
+}
@@ -1,14 +1,17 @@
 package communication
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/Roma7-7-7/sso-notifier/models"
 )
 
 type MessageSender interface {
-	Send(chatID int64, msg string) error
+	Send(chatID int64, msg string, silent bool) (int, error)
 }
 
 type NotificationRepository interface {
@@ -16,45 +19,102 @@ type NotificationRepository interface {
 	Delete(id int) error
 }
 
+type SubscriptionRepository interface {
+	Get(chatID int64) (models.Subscription, bool, error)
+	RecordNotification(chatID int64, at time.Time) error
+}
+
 type Service struct {
-	repo   NotificationRepository
-	sender MessageSender
+	repo    NotificationRepository
+	subRepo SubscriptionRepository
+	sender  MessageSender
 
 	notifyTaskMx sync.Mutex
 }
 
 func (s *Service) SendMessage(chatID int64, msg string) error {
-	return s.sender.Send(chatID, msg)
+	_, err := s.sender.Send(chatID, msg, false)
+	return err
 }
 
-func (s *Service) SendQueuedNotifications() {
+// SendQueuedNotifications sends queued notifications and reports deliveries. It stops between notifications once ctx
+// is done
+func (s *Service) SendQueuedNotifications(ctx context.Context) (models.RunReport, error) {
+	return s.sendQueued(ctx, false)
+}
+
+// SendAdminNotifications sends only queued notifications to bot admins, e.g. during maintenance when subscribers
+// are not notified, while alerts about the provider matter the most. Other notifications stay in the queue
+func (s *Service) SendAdminNotifications(ctx context.Context) (models.RunReport, error) {
+	return s.sendQueued(ctx, true)
+}
+
+func (s *Service) sendQueued(ctx context.Context, adminsOnly bool) (models.RunReport, error) {
 	s.notifyTaskMx.Lock()
 	defer s.notifyTaskMx.Unlock()
 
 	ns, err := s.repo.GetAll()
 	if err != nil {
-		slog.Error("failed to get queued notifications", "error", err)
-		return
+		return models.RunReport{}, fmt.Errorf("failed to get queued notifications: %w", err)
 	}
-	for _, n := range ns {
+	var report models.RunReport
+	now := time.Now()
+	for i, n := range ns {
+		if ctx.Err() != nil {
+			slog.Warn("sending notifications interrupted", "left", len(ns)-i)
+			return report, ctx.Err()
+		}
+		if adminsOnly && !n.Admin {
+			continue
+		}
 		subID := slog.Int64("subscriberID", n.Target)
 		notificationID := slog.Int("notificationID", n.ID)
 
-		if err = s.sender.Send(n.Target, n.Msg); err != nil {
+		sub, exists, err := s.subRepo.Get(n.Target)
+		if err != nil {
+			slog.Error("failed to get subscription", "error", err, subID, notificationID)
+			report.Record(models.DeliveryFailed)
+			continue
+		}
+		if exists && (sub.IsMuted(now) || sub.IsDisabled()) {
+			// notification stays in the queue until mute is over or the chat is enabled again
+			report.Record(models.DeliverySkipped)
+			continue
+		}
+
+		messageID, err := s.sender.Send(n.Target, n.Msg, false)
+		if err != nil {
 			slog.Error("failed to send notification", "error", err, subID, notificationID)
+			report.Record(models.DeliveryFailed)
 			continue
 		}
+		if messageID == 0 {
+			report.Record(models.DeliveryDisabled)
+		} else {
+			report.Record(models.DeliverySent)
+		}
+		// notification to the chat which blocked the bot is not delivered
+		if exists && messageID != 0 {
+			if err = s.subRepo.RecordNotification(n.Target, time.Now()); err != nil {
+				slog.Error("failed to record notification delivery", "error", err, subID, notificationID)
+			}
+		}
 		if err = s.repo.Delete(n.ID); err != nil {
 			slog.Error("failed to delete notification from queue", "error", err, subID, notificationID)
 			continue
 		}
 		slog.Debug("notification sent", subID, notificationID)
 	}
+
+	return report, nil
 }
 
-func NewNotificationService(repo NotificationRepository, sender MessageSender) *Service {
+func NewNotificationService(
+	repo NotificationRepository, subRepo SubscriptionRepository, sender MessageSender,
+) *Service {
 	return &Service{
-		repo:   repo,
-		sender: sender,
+		repo:    repo,
+		subRepo: subRepo,
+		sender:  sender,
 	}
 }
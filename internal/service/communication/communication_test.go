@@ -0,0 +1,80 @@
+package communication
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type memoryQueue struct {
+	ns map[int]models.Notification
+}
+
+func (q *memoryQueue) Put(n models.Notification) (models.Notification, error) {
+	n.ID = len(q.ns) + 1
+	q.ns[n.ID] = n
+	return n, nil
+}
+
+func (q *memoryQueue) GetAll() ([]models.Notification, error) {
+	res := make([]models.Notification, 0, len(q.ns))
+	for _, n := range q.ns {
+		res = append(res, n)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+func (q *memoryQueue) Delete(id int) error {
+	delete(q.ns, id)
+	return nil
+}
+
+type noSubscriptions struct{}
+
+func (noSubscriptions) Get(int64) (models.Subscription, bool, error) {
+	return models.Subscription{}, false, nil
+}
+
+func (noSubscriptions) RecordNotification(int64, time.Time) error { return nil }
+
+type recordingSender struct {
+	sent []int64
+}
+
+func (s *recordingSender) Send(chatID int64, _ string, _ bool) (int, error) {
+	s.sent = append(s.sent, chatID)
+	return 1, nil
+}
+
+func TestService_SendAdminNotifications(t *testing.T) {
+	queue := &memoryQueue{ns: make(map[int]models.Notification)}
+	NewAdminNotifier(queue, []int64{100}).NotifyAdmins("groups mismatch")
+	if _, err := queue.Put(models.Notification{Target: 1, Msg: "group suspended"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	sender := &recordingSender{}
+	s := NewNotificationService(queue, noSubscriptions{}, sender)
+
+	report, err := s.SendAdminNotifications(context.Background())
+	if err != nil {
+		t.Fatalf("SendAdminNotifications() error = %v", err)
+	}
+	if !reflect.DeepEqual(sender.sent, []int64{100}) || report.Sent != 1 {
+		t.Errorf("sent to %v, report = %+v, want admin notification only", sender.sent, report)
+	}
+	if len(queue.ns) != 1 {
+		t.Fatalf("queued = %v, want subscriber notification kept", queue.ns)
+	}
+
+	if _, err = s.SendQueuedNotifications(context.Background()); err != nil {
+		t.Fatalf("SendQueuedNotifications() error = %v", err)
+	}
+	if !reflect.DeepEqual(sender.sent, []int64{100, 1}) || len(queue.ns) != 0 {
+		t.Errorf("sent to %v, queued = %v, want the rest sent", sender.sent, queue.ns)
+	}
+}
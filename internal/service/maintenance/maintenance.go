@@ -0,0 +1,69 @@
+package maintenance
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+const defaultText = "Бот працює в режимі обслуговування. Деякі функції можуть бути тимчасово недоступні."
+
+type Repository interface {
+	Get() (models.Maintenance, error)
+	Put(m models.Maintenance) error
+}
+
+// Service keeps maintenance state in memory as it is checked on every update and scheduler tick
+type Service struct {
+	repo Repository
+
+	mx    sync.RWMutex
+	state models.Maintenance
+}
+
+func (s *Service) IsEnabled() bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.state.Enabled
+}
+
+func (s *Service) Banner() string {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return "⚠️ " + s.state.Text
+}
+
+func (s *Service) Enable(text string) error {
+	if text == "" {
+		text = defaultText
+	}
+	return s.put(models.Maintenance{Enabled: true, Text: text})
+}
+
+func (s *Service) Disable() error {
+	return s.put(models.Maintenance{})
+}
+
+func (s *Service) put(m models.Maintenance) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err := s.repo.Put(m); err != nil {
+		return fmt.Errorf("failed to put maintenance: %w", err)
+	}
+	s.state = m
+	return nil
+}
+
+func NewMaintenanceService(repo Repository) (*Service, error) {
+	state, err := repo.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get maintenance: %w", err)
+	}
+
+	return &Service{
+		repo:  repo,
+		state: state,
+	}, nil
+}
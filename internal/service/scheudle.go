@@ -1,59 +1,421 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
 	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
 )
 
 type ShutdownsService interface {
-	RefreshShutdownsTable()
+	RefreshShutdownsTable() error
 }
 
 type SubscriptionService interface {
-	SendUpdates()
+	SendUpdates(ctx context.Context) (models.RunReport, error)
+	PurgeExpiredTombstones() error
 }
 
 type CommunicationService interface {
-	SendQueuedNotifications()
+	SendQueuedNotifications(ctx context.Context) (models.RunReport, error)
+	SendAdminNotifications(ctx context.Context) (models.RunReport, error)
+}
+
+type ChannelPublisher interface {
+	Publish() error
+}
+
+type CountdownPublisher interface {
+	Publish() error
+}
+
+type MaintenanceService interface {
+	IsEnabled() bool
+}
+
+type AdminNotifier interface {
+	NotifyAdmins(msg string)
+}
+
+type DailySummary interface {
+	Run(tasks []models.TaskStatus) error
 }
 
 const refreshTableInterval = 5 * time.Minute
 const sendUpdatesInterval = 5 * time.Second
 const notificationInterval = 5 * time.Minute
+const publishChannelsInterval = 1 * time.Minute
+const countdownInterval = 1 * time.Minute
+const purgeTombstonesInterval = 1 * time.Hour
+const dailySummaryInterval = 5 * time.Minute
+const warmUpTimeout = 90 * time.Second
+
+// failureRatioThreshold is the share of failed deliveries in a sending task run which admins are notified about.
+// Runs with less than minEscalationAttempts deliveries are too small to tell and admins are notified at most once per
+// escalationCooldown per task
+const failureRatioThreshold = 0.5
+const minEscalationAttempts = 5
+const escalationCooldown = 1 * time.Hour
+
+// task is a periodic scheduler task. Aligned tasks fire on multiples of interval since Kyiv midnight shifted by
+// offset (e.g. interval=20m and offset=10m fires at :10, :30 and :50), others fire right after start
+type task struct {
+	name     string
+	interval time.Duration
+	aligned  bool
+	offset   time.Duration
+	// needsTable is set for tasks which use shutdowns table, so they can't run without the refresh task
+	needsTable bool
+	run        func(ctx context.Context) error
+}
 
 type Scheduler struct {
 	shutdownsService    ShutdownsService
 	subscriptionService SubscriptionService
 	notificationService CommunicationService
+	channelPublisher    ChannelPublisher
+	countdownPublisher  CountdownPublisher
+	maintenanceService  MaintenanceService
+	dailySummary        DailySummary
+	notifier            AdminNotifier
+	// disabled holds names of tasks which are not run by this instance
+	disabled map[string]bool
+
+	clock    clock.Clock
+	location *time.Location
+
+	statusesMx sync.Mutex
+	statuses   map[string]models.TaskStatus
+	// running holds names of tasks which are in progress, so a slow task is never run concurrently with itself
+	running map[string]bool
+	// escalatedAt is the last time admins were notified about failing deliveries of the task
+	escalatedAt map[string]time.Time
+	// wg tracks task loops and runs, so shutdown can wait for them
+	wg sync.WaitGroup
 }
 
-func (s *Scheduler) RefreshTable() {
-	for {
-		s.shutdownsService.RefreshShutdownsTable()
-		time.Sleep(refreshTableInterval)
+// Start refreshes shutdowns table once before starting periodic tasks,
+// so they never observe an empty or stale store right after boot. Tasks are stopped once ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	if !s.disabled[refreshTableTaskName] {
+		s.warmUp(ctx)
+	}
+
+	tasks := s.enabledTasks()
+	s.wg.Add(len(tasks))
+	for _, t := range tasks {
+		go s.loop(ctx, t)
 	}
 }
 
-func (s *Scheduler) SendUpdates() {
-	for {
-		s.subscriptionService.SendUpdates()
-		time.Sleep(sendUpdatesInterval)
+// Wait waits for task runs in progress to notice stop. It returns false if they are still running after timeout,
+// remaining work is abandoned then and picked up after restart
+func (s *Scheduler) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-s.clock.After(timeout):
+		return false
+	}
+}
+
+func (s *Scheduler) tasks() []task {
+	return []task{
+		s.refreshTableTask(),
+		{
+			name:       "send_updates",
+			interval:   sendUpdatesInterval,
+			needsTable: true,
+			run:        s.unlessMaintenance(s.reporting("send_updates", s.subscriptionService.SendUpdates)),
+		},
+		{
+			name:     "send_notifications",
+			interval: notificationInterval,
+			run:      s.reporting("send_notifications", s.sendNotifications),
+		},
+		{
+			name:       "publish_to_channels",
+			interval:   publishChannelsInterval,
+			needsTable: true,
+			run:        s.unlessMaintenance(withoutContext(s.channelPublisher.Publish)),
+		},
+		{
+			name:       "update_countdowns",
+			interval:   countdownInterval,
+			needsTable: true,
+			run:        s.unlessMaintenance(withoutContext(s.countdownPublisher.Publish)),
+		},
+		{
+			name:     "purge_tombstones",
+			interval: purgeTombstonesInterval,
+			run:      withoutContext(s.subscriptionService.PurgeExpiredTombstones),
+		},
+		{
+			name:     "daily_summary",
+			interval: dailySummaryInterval,
+			aligned:  true,
+			run: withoutContext(func() error {
+				return s.dailySummary.Run(s.TaskStatuses())
+			}),
+		},
+	}
+}
+
+func (s *Scheduler) enabledTasks() []task {
+	res := make([]task, 0)
+	for _, t := range s.tasks() {
+		if !s.disabled[t.name] {
+			res = append(res, t)
+		}
 	}
+	return res
 }
 
-func (s *Scheduler) SendNotificationsTask() {
+const refreshTableTaskName = "refresh_table"
+
+func (s *Scheduler) refreshTableTask() task {
+	return task{
+		name:     refreshTableTaskName,
+		interval: refreshTableInterval,
+		aligned:  true,
+		run:      withoutContext(s.shutdownsService.RefreshShutdownsTable),
+	}
+}
+
+func (s *Scheduler) warmUp(ctx context.Context) {
+	done := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		// warm up is a run of the refresh task, so the periodic one is skipped if warm up is still in progress
+		s.runTask(ctx, s.refreshTableTask())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("shutdowns table warmed up")
+	case <-s.clock.After(warmUpTimeout):
+		slog.Warn("shutdowns table warm up timed out, starting tasks anyway", "timeout", warmUpTimeout)
+	}
+}
+
+// loop must be counted in wg by the caller. Runs are added to wg by the loop itself, so the counter never drops to
+// zero while the loop may still start a run
+func (s *Scheduler) loop(ctx context.Context, t task) {
+	defer s.wg.Done()
+
+	if t.aligned {
+		delay := firstTickDelay(s.clock.Now().In(s.location), t.interval, t.offset)
+		slog.Debug("aligning task first tick", "task", t.name, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(delay):
+		}
+	}
+
+	ticker := s.clock.Ticker(t.interval)
+	defer ticker.Stop()
 	for {
-		s.notificationService.SendQueuedNotifications()
-		time.Sleep(notificationInterval)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runTask(ctx, t)
+		}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
 	}
 }
 
+// runTask runs the task unless its previous run is still in progress. Skipped ticks are counted in the task status
+func (s *Scheduler) runTask(ctx context.Context, t task) {
+	if ctx.Err() != nil {
+		return
+	}
+	if !s.acquire(t.name) {
+		slog.Warn("skipping scheduler task, previous run is in progress", "task", t.name)
+		return
+	}
+
+	start := s.clock.Now()
+	err := t.run(ctx)
+
+	s.statusesMx.Lock()
+	defer s.statusesMx.Unlock()
+	s.running[t.name] = false
+	status := s.statuses[t.name]
+	status.Name = t.name
+	status.LastStart = start
+	status.Duration = s.clock.Now().Sub(start)
+	if err != nil {
+		slog.Error("scheduler task failed", "task", t.name, "error", err)
+		status.LastError = err.Error()
+	} else {
+		status.LastSuccess = status.LastStart
+		status.LastError = ""
+	}
+	s.statuses[t.name] = status
+}
+
+func (s *Scheduler) acquire(name string) bool {
+	s.statusesMx.Lock()
+	defer s.statusesMx.Unlock()
+
+	if s.running[name] {
+		status := s.statuses[name]
+		status.Name = name
+		status.Skipped++
+		s.statuses[name] = status
+		return false
+	}
+	s.running[name] = true
+	return true
+}
+
+// TaskStatuses returns last run status of each task in the order tasks are defined
+func (s *Scheduler) TaskStatuses() []models.TaskStatus {
+	s.statusesMx.Lock()
+	defer s.statusesMx.Unlock()
+
+	res := make([]models.TaskStatus, 0, len(s.statuses))
+	for _, t := range s.tasks() {
+		status, ok := s.statuses[t.name]
+		if !ok {
+			status = models.TaskStatus{Name: t.name, Disabled: s.disabled[t.name]}
+		}
+		res = append(res, status)
+	}
+	return res
+}
+
+func (s *Scheduler) unlessMaintenance(run func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if s.maintenanceService.IsEnabled() {
+			return nil
+		}
+		return run(ctx)
+	}
+}
+
+// sendNotifications keeps delivering notifications to admins during maintenance, so they get alerts about the provider
+// while subscribers are not notified
+func (s *Scheduler) sendNotifications(ctx context.Context) (models.RunReport, error) {
+	if s.maintenanceService.IsEnabled() {
+		return s.notificationService.SendAdminNotifications(ctx)
+	}
+	return s.notificationService.SendQueuedNotifications(ctx)
+}
+
+// reporting adapts sending tasks. The run report is logged, kept in the task status and escalated to admins when too
+// many deliveries fail
+func (s *Scheduler) reporting(
+	name string, run func(ctx context.Context) (models.RunReport, error),
+) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		report, err := run(ctx)
+		if report.Attempted > 0 {
+			slog.Info("sending task report", "task", name, "attempted", report.Attempted, "sent", report.Sent,
+				"failed", report.Failed, "disabled", report.Disabled, "skipped", report.Skipped)
+		}
+
+		s.statusesMx.Lock()
+		status := s.statuses[name]
+		status.Report = report
+		s.statuses[name] = status
+		escalate := report.Attempted >= minEscalationAttempts && report.FailureRatio() > failureRatioThreshold &&
+			s.clock.Now().Sub(s.escalatedAt[name]) >= escalationCooldown
+		if escalate {
+			s.escalatedAt[name] = s.clock.Now()
+		}
+		s.statusesMx.Unlock()
+
+		if escalate {
+			s.notifier.NotifyAdmins(fmt.Sprintf("⚠️ %s: не доставлено %d з %d повідомлень",
+				name, report.Failed, report.Attempted))
+		}
+		return err
+	}
+}
+
+// withoutContext adapts short tasks which are not interrupted on stop
+func withoutContext(run func() error) func(ctx context.Context) error {
+	return func(context.Context) error {
+		return run()
+	}
+}
+
+// firstTickDelay returns delay until the next multiple of interval since midnight shifted by offset.
+// Midnight is taken in the location of now
+func firstTickDelay(now time.Time, interval, offset time.Duration) time.Duration {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	rem := (now.Sub(midnight) - offset) % interval
+	if rem < 0 {
+		rem += interval
+	}
+	if rem == 0 {
+		return 0
+	}
+	return interval - rem
+}
+
+// NewScheduler creates scheduler running all tasks except disabled ones, e.g. on an instance serving only a part of
+// the features. Tasks which use shutdowns table can't be enabled without "refresh_table"
 func NewScheduler(
 	shutdownsService ShutdownsService, subscriptionService SubscriptionService, notificationService CommunicationService,
-) *Scheduler {
+	channelPublisher ChannelPublisher, countdownPublisher CountdownPublisher, maintenanceService MaintenanceService,
+	dailySummary DailySummary, notifier AdminNotifier, disabledTasks []string, clk clock.Clock,
+) (*Scheduler, error) {
 
-	return &Scheduler{
+	s := &Scheduler{
 		shutdownsService:    shutdownsService,
 		subscriptionService: subscriptionService,
 		notificationService: notificationService,
+		channelPublisher:    channelPublisher,
+		countdownPublisher:  countdownPublisher,
+		maintenanceService:  maintenanceService,
+		dailySummary:        dailySummary,
+		notifier:            notifier,
+		disabled:            make(map[string]bool, len(disabledTasks)),
+
+		clock:       clk,
+		location:    clock.MustKyiv(),
+		statuses:    make(map[string]models.TaskStatus),
+		running:     make(map[string]bool),
+		escalatedAt: make(map[string]time.Time),
 	}
+
+	known := make(map[string]bool)
+	for _, t := range s.tasks() {
+		known[t.name] = true
+	}
+	for _, name := range disabledTasks {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown task %q", name)
+		}
+		s.disabled[name] = true
+	}
+	if s.disabled[refreshTableTaskName] {
+		for _, t := range s.enabledTasks() {
+			if t.needsTable {
+				return nil, fmt.Errorf("task %q can't be enabled without %q", t.name, refreshTableTaskName)
+			}
+		}
+	}
+
+	return s, nil
 }
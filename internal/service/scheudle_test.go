@@ -0,0 +1,457 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
+)
+
+func Test_firstTickDelay(t *testing.T) {
+	kyiv := clock.MustKyiv()
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		interval time.Duration
+		offset   time.Duration
+		want     time.Duration
+	}{
+		{
+			name:     "exactly on boundary",
+			now:      time.Date(2024, 6, 10, 12, 30, 0, 0, kyiv),
+			interval: 30 * time.Minute,
+			want:     0,
+		},
+		{
+			name:     "right after boundary",
+			now:      time.Date(2024, 6, 10, 12, 7, 0, 0, kyiv),
+			interval: 30 * time.Minute,
+			want:     23 * time.Minute,
+		},
+		{
+			name:     "with seconds",
+			now:      time.Date(2024, 6, 10, 12, 29, 30, 0, kyiv),
+			interval: 30 * time.Minute,
+			want:     30 * time.Second,
+		},
+		{
+			name:     "offset after boundary",
+			now:      time.Date(2024, 6, 10, 12, 7, 0, 0, kyiv),
+			interval: 20 * time.Minute,
+			offset:   10 * time.Minute,
+			want:     3 * time.Minute,
+		},
+		{
+			name:     "offset before boundary",
+			now:      time.Date(2024, 6, 10, 12, 15, 0, 0, kyiv),
+			interval: 20 * time.Minute,
+			offset:   10 * time.Minute,
+			want:     15 * time.Minute,
+		},
+		{
+			name:     "offset right after midnight",
+			now:      time.Date(2024, 6, 10, 0, 2, 0, 0, kyiv),
+			interval: 20 * time.Minute,
+			offset:   10 * time.Minute,
+			want:     8 * time.Minute,
+		},
+		{
+			name:     "kyiv midnight from utc time",
+			now:      time.Date(2024, 6, 10, 20, 55, 0, 0, time.UTC).In(kyiv),
+			interval: 5 * time.Minute,
+			want:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstTickDelay(tt.now, tt.interval, tt.offset); got != tt.want {
+				t.Errorf("firstTickDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type countingTask struct {
+	runs atomic.Int32
+}
+
+func (t *countingTask) run() error {
+	t.runs.Add(1)
+	return nil
+}
+
+type countingShutdownsService struct {
+	countingTask
+	// release blocks refresh runs until it is closed, if set
+	release chan struct{}
+}
+
+func (s *countingShutdownsService) RefreshShutdownsTable() error {
+	if s.release != nil {
+		<-s.release
+	}
+	return s.run()
+}
+
+type countingSubscriptionService struct{ countingTask }
+
+func (s *countingSubscriptionService) SendUpdates(context.Context) (models.RunReport, error) {
+	return models.RunReport{}, s.run()
+}
+
+func (s *countingSubscriptionService) PurgeExpiredTombstones() error { return nil }
+
+type countingCommunicationService struct {
+	countingTask
+	adminRuns atomic.Int32
+}
+
+func (s *countingCommunicationService) SendQueuedNotifications(context.Context) (models.RunReport, error) {
+	return models.RunReport{}, s.run()
+}
+
+func (s *countingCommunicationService) SendAdminNotifications(context.Context) (models.RunReport, error) {
+	s.adminRuns.Add(1)
+	return models.RunReport{}, nil
+}
+
+type countingChannelPublisher struct{ countingTask }
+
+func (p *countingChannelPublisher) Publish() error { return p.run() }
+
+type noopSummary struct{}
+
+func (noopSummary) Run([]models.TaskStatus) error { return nil }
+
+type fakeNotifier struct {
+	msgs []string
+}
+
+func (n *fakeNotifier) NotifyAdmins(msg string) { n.msgs = append(n.msgs, msg) }
+
+type maintenanceStub struct {
+	enabled atomic.Bool
+}
+
+func (m *maintenanceStub) IsEnabled() bool {
+	return m.enabled.Load()
+}
+
+type schedulerFixture struct {
+	start         time.Time
+	clock         *clock.Mock
+	shutdowns     *countingShutdownsService
+	subscriptions *countingSubscriptionService
+	notifications *countingCommunicationService
+	channels      *countingChannelPublisher
+	maintenance   *maintenanceStub
+	notifier      *fakeNotifier
+	scheduler     *Scheduler
+}
+
+func newSchedulerFixture(now time.Time) *schedulerFixture {
+	f := &schedulerFixture{
+		start:         now,
+		clock:         clock.NewMock(now),
+		shutdowns:     &countingShutdownsService{},
+		subscriptions: &countingSubscriptionService{},
+		notifications: &countingCommunicationService{},
+		channels:      &countingChannelPublisher{},
+		maintenance:   &maintenanceStub{},
+		notifier:      &fakeNotifier{},
+	}
+	f.scheduler = f.newScheduler(nil)
+	return f
+}
+
+func (f *schedulerFixture) newScheduler(disabledTasks []string) *Scheduler {
+	s, err := NewScheduler(f.shutdowns, f.subscriptions, f.notifications, f.channels, &countingChannelPublisher{},
+		f.maintenance, noopSummary{}, f.notifier, disabledTasks, f.clock)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// advance moves the clock step by step waiting for tasks to pick up their ticks
+func (f *schedulerFixture) advance(t *testing.T, step time.Duration, steps int) {
+	t.Helper()
+
+	for i := 0; i < steps; i++ {
+		expectedUpdates := f.subscriptions.runs.Load() + 1
+		f.clock.Advance(step)
+		waitFor(t, func() bool {
+			return f.clock.Waiters() == f.expectedWaiters() && f.subscriptions.runs.Load() == expectedUpdates &&
+				f.idle()
+		})
+	}
+}
+
+// idle returns true when no task is in progress, so the next tick is not skipped
+func (f *schedulerFixture) idle() bool {
+	f.scheduler.statusesMx.Lock()
+	defer f.scheduler.statusesMx.Unlock()
+	for _, running := range f.scheduler.running {
+		if running {
+			return false
+		}
+	}
+	return true
+}
+
+// expectedWaiters is a ticker per task plus warm up timeout timer which stays registered until it expires
+func (f *schedulerFixture) expectedWaiters() int {
+	res := len(f.scheduler.enabledTasks())
+	if f.clock.Now().Sub(f.start) < warmUpTimeout && !f.scheduler.disabled[refreshTableTaskName] {
+		res++
+	}
+	return res
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (f *schedulerFixture) assertRuns(t *testing.T, refresh, updates, notifications, channels int32) {
+	t.Helper()
+
+	waitFor(t, func() bool {
+		return f.shutdowns.runs.Load() == refresh &&
+			f.subscriptions.runs.Load() == updates &&
+			f.notifications.runs.Load() == notifications &&
+			f.channels.runs.Load() == channels
+	})
+}
+
+func TestScheduler_Start(t *testing.T) {
+	// refresh task is aligned to 5 minutes, so it fires at 12:05 and 12:10
+	f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 2, 0, 0, clock.MustKyiv()))
+	f.scheduler.Start(context.Background())
+
+	// warm up refresh plus the first run of not aligned tasks
+	waitFor(t, func() bool { return f.clock.Waiters() == f.expectedWaiters() })
+	f.assertRuns(t, 1, 1, 1, 1)
+
+	f.advance(t, sendUpdatesInterval, 12) // 12:03
+	f.assertRuns(t, 1, 13, 1, 2)
+
+	f.advance(t, sendUpdatesInterval, 24) // 12:05
+	f.assertRuns(t, 2, 37, 1, 4)
+
+	f.advance(t, sendUpdatesInterval, 60) // 12:10
+	f.assertRuns(t, 3, 97, 2, 9)
+}
+
+func TestScheduler_StartWarmUp(t *testing.T) {
+	t.Run("tasks wait for warm up", func(t *testing.T) {
+		f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 2, 0, 0, clock.MustKyiv()))
+		f.shutdowns.release = make(chan struct{})
+		started := make(chan struct{})
+		go func() {
+			f.scheduler.Start(context.Background())
+			close(started)
+		}()
+
+		// only warm up timeout is waited for while the table is being refreshed
+		waitFor(t, func() bool { return f.clock.Waiters() == 1 })
+		f.clock.Advance(warmUpTimeout - time.Second)
+		f.assertRuns(t, 0, 0, 0, 0)
+
+		close(f.shutdowns.release)
+		<-started
+		waitFor(t, func() bool { return f.clock.Waiters() == f.expectedWaiters() })
+		f.assertRuns(t, 1, 1, 1, 1)
+	})
+
+	t.Run("tasks start after warm up timeout", func(t *testing.T) {
+		f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 2, 0, 0, clock.MustKyiv()))
+		f.shutdowns.release = make(chan struct{})
+		defer close(f.shutdowns.release)
+		started := make(chan struct{})
+		go func() {
+			f.scheduler.Start(context.Background())
+			close(started)
+		}()
+
+		waitFor(t, func() bool { return f.clock.Waiters() == 1 })
+		f.clock.Advance(warmUpTimeout)
+		<-started
+		f.assertRuns(t, 0, 1, 1, 1)
+	})
+}
+
+func TestScheduler_StartDisabledTasks(t *testing.T) {
+	f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 2, 0, 0, clock.MustKyiv()))
+	f.scheduler = f.newScheduler([]string{"send_notifications", "publish_to_channels"})
+	f.scheduler.Start(context.Background())
+
+	waitFor(t, func() bool { return f.clock.Waiters() == f.expectedWaiters() })
+	f.advance(t, sendUpdatesInterval, 36) // 12:05
+	f.assertRuns(t, 2, 37, 0, 0)
+
+	for _, status := range f.scheduler.TaskStatuses() {
+		disabled := status.Name == "send_notifications" || status.Name == "publish_to_channels"
+		if status.Disabled != disabled {
+			t.Errorf("task %s disabled = %t, want %t", status.Name, status.Disabled, disabled)
+		}
+	}
+}
+
+func TestNewScheduler_DisabledTasks(t *testing.T) {
+	f := newSchedulerFixture(time.Now())
+	tests := []struct {
+		name     string
+		disabled []string
+		wantErr  bool
+	}{
+		{name: "none"},
+		{name: "refresh with all tasks using the table", disabled: []string{"refresh_table", "send_updates", "update_countdowns",
+			"publish_to_channels"}},
+		{name: "unknown task", disabled: []string{"backup"}, wantErr: true},
+		{name: "refresh while tasks using the table are enabled", disabled: []string{"refresh_table"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScheduler(f.shutdowns, f.subscriptions, f.notifications, f.channels, f.channels,
+				f.maintenance, noopSummary{}, f.notifier, tt.disabled, f.clock)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewScheduler() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduler_StartMaintenance(t *testing.T) {
+	f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 0, 0, 0, clock.MustKyiv()))
+	f.maintenance.enabled.Store(true)
+	f.scheduler.Start(context.Background())
+
+	waitFor(t, func() bool { return f.clock.Waiters() == f.expectedWaiters() })
+	f.clock.Advance(refreshTableInterval)
+	waitFor(t, func() bool { return f.shutdowns.runs.Load() == 3 })
+
+	f.assertRuns(t, 3, 0, 0, 0)
+	// admins are still notified, e.g. about the provider publishing unexpected groups
+	if runs := f.notifications.adminRuns.Load(); runs == 0 {
+		t.Errorf("admin notification runs = %d during maintenance, want them sent", runs)
+	}
+	for _, status := range f.scheduler.TaskStatuses() {
+		if status.LastStart.IsZero() {
+			t.Errorf("task %s has never started", status.Name)
+		}
+	}
+}
+
+func TestScheduler_SkipsOverlappingRuns(t *testing.T) {
+	f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 0, 0, 0, clock.MustKyiv()))
+
+	release := make(chan struct{})
+	var running, runs atomic.Int32
+	var overlapped atomic.Bool
+	slow := task{
+		name:     "slow",
+		interval: time.Minute,
+		run: func(context.Context) error {
+			runs.Add(1)
+			if running.Add(1) > 1 {
+				overlapped.Store(true)
+			}
+			<-release
+			running.Add(-1)
+			return nil
+		},
+	}
+	skipped := func() int {
+		f.scheduler.statusesMx.Lock()
+		defer f.scheduler.statusesMx.Unlock()
+		return f.scheduler.statuses["slow"].Skipped
+	}
+
+	f.scheduler.wg.Add(1)
+	go f.scheduler.loop(context.Background(), slow)
+	waitFor(t, func() bool { return f.clock.Waiters() == 1 && running.Load() == 1 })
+	for i := 1; i <= 3; i++ {
+		f.clock.Advance(time.Minute)
+		waitFor(t, func() bool { return skipped() == i })
+	}
+	close(release)
+	waitFor(t, f.idle)
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("runs = %d, want 1", got)
+	}
+	if overlapped.Load() {
+		t.Errorf("task runs overlapped")
+	}
+
+	f.clock.Advance(time.Minute)
+	waitFor(t, func() bool { return runs.Load() == 2 })
+}
+
+func TestScheduler_Wait(t *testing.T) {
+	f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 2, 0, 0, clock.MustKyiv()))
+	ctx, cancel := context.WithCancel(context.Background())
+	f.scheduler.Start(ctx)
+	waitFor(t, func() bool { return f.clock.Waiters() == f.expectedWaiters() })
+
+	cancel()
+	// mock clock is not advanced, so timeout never fires and Wait returns only when all loops and runs are done
+	if !f.scheduler.Wait(time.Minute) {
+		t.Fatalf("Wait() = false, want true")
+	}
+
+	runs := f.subscriptions.runs.Load()
+	f.clock.Advance(time.Minute)
+	if got := f.subscriptions.runs.Load(); got != runs {
+		t.Errorf("runs after stop = %d, want %d", got, runs)
+	}
+}
+
+func TestScheduler_reporting(t *testing.T) {
+	f := newSchedulerFixture(time.Date(2024, 6, 10, 12, 0, 0, 0, clock.MustKyiv()))
+
+	var report models.RunReport
+	run := f.scheduler.reporting("send_updates", func(context.Context) (models.RunReport, error) {
+		return report, nil
+	})
+	runWith := func(sent, failed int) {
+		t.Helper()
+		report = models.RunReport{Attempted: sent + failed, Sent: sent, Failed: failed}
+		if err := run(context.Background()); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	}
+
+	runWith(1, 3) // too few deliveries to tell
+	runWith(5, 5) // half failed is tolerated
+	if len(f.notifier.msgs) != 0 {
+		t.Fatalf("admins notified = %v, want none", f.notifier.msgs)
+	}
+
+	runWith(2, 8)
+	runWith(2, 8)
+	if len(f.notifier.msgs) != 1 {
+		t.Fatalf("admins notified %d times, want once per cooldown", len(f.notifier.msgs))
+	}
+	if got := f.scheduler.statuses["send_updates"].Report; got != report {
+		t.Errorf("status report = %+v, want %+v", got, report)
+	}
+
+	f.clock.Advance(escalationCooldown)
+	runWith(2, 8)
+	if len(f.notifier.msgs) != 2 {
+		t.Errorf("admins notified %d times after cooldown, want 2", len(f.notifier.msgs))
+	}
+}
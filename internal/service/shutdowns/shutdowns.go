@@ -1,7 +1,13 @@
 package shutdowns
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/Roma7-7-7/sso-notifier/models"
@@ -16,36 +22,156 @@ type Repository interface {
 	Put(models.ShutdownsTable) (models.ShutdownsTable, error)
 }
 
+type RevisionRepository interface {
+	Add(date string, rev models.ScheduleRevision) error
+	GetAll(date string) ([]models.ScheduleRevision, error)
+}
+
+type AdminNotifier interface {
+	NotifyAdmins(msg string)
+}
+
 type Service struct {
-	repo   Repository
-	loader TableLoader
+	repo           Repository
+	revisions      RevisionRepository
+	loader         TableLoader
+	notifier       AdminNotifier
+	expectedGroups int
 
 	refreshMx sync.Mutex
+	// lastMismatch is the last groups mismatch admins were notified about, so they are not notified on every refresh
+	lastMismatch string
 }
 
 func (s *Service) GetShutdownsTable() (models.ShutdownsTable, bool, error) {
 	return s.repo.Get(shutdownsTableKey)
 }
 
-func (s *Service) RefreshShutdownsTable() {
+// RefreshShutdownsTable loads and stores the table. The table is not stored if its groups differ from the stored
+// table groups, or from the expected groups count while there is no stored table yet, as it likely means the page is
+// broken or its layout has changed. Admins are notified about the mismatch and can accept the table with
+// ForceRefreshShutdownsTable. The accepted table becomes the reference for the following refreshes
+func (s *Service) RefreshShutdownsTable() error {
+	return s.refresh(false)
+}
+
+// ForceRefreshShutdownsTable loads and stores the table even if its groups mismatch
+func (s *Service) ForceRefreshShutdownsTable() error {
+	return s.refresh(true)
+}
+
+func (s *Service) refresh(force bool) error {
 	s.refreshMx.Lock()
 	defer s.refreshMx.Unlock()
 
 	table, err := s.loader()
 	if err != nil {
-		slog.Error("failed to load shutdowns table", "error", err)
-		return
+		return fmt.Errorf("failed to load shutdowns table: %w", err)
+	}
+
+	if !force {
+		if err = s.checkGroups(table); err != nil {
+			return err
+		}
 	}
+	s.lastMismatch = ""
+
 	table.ID = shutdownsTableKey
 	if _, err = s.repo.Put(table); err != nil {
-		slog.Error("failed to update shutdowns table", "error", err)
-		return
+		return fmt.Errorf("failed to update shutdowns table: %w", err)
+	}
+
+	// revisions are for debugging only, so failing to record one doesn't fail the refresh
+	rev := models.ScheduleRevision{Hash: tableHash(table), FirstSeen: table.UpdatedAt}
+	if err = s.revisions.Add(table.Date, rev); err != nil {
+		slog.Warn("failed to record schedule revision", "error", err, "date", table.Date)
+	}
+	return nil
+}
+
+// Revisions returns the current table date and distinct versions of its table, the oldest first
+func (s *Service) Revisions() (string, []models.ScheduleRevision, error) {
+	table, ok, err := s.repo.Get(shutdownsTableKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get shutdowns table: %w", err)
+	}
+	if !ok {
+		return "", nil, nil
+	}
+	revs, err := s.revisions.GetAll(table.Date)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get schedule revisions: %w", err)
+	}
+	return table.Date, revs, nil
+}
+
+// tableHash is a short hash of the table periods and groups. Fetch time and source are not a part of it
+func tableHash(table models.ShutdownsTable) string {
+	h := sha256.New()
+	for _, p := range table.Periods {
+		h.Write([]byte(p.From + "-" + p.To + ";"))
+	}
+	keys := make([]string, 0, len(table.Groups))
+	for k := range table.Groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(table.Groups[k].Hash(k + ":")))
+		h.Write([]byte(";"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (s *Service) checkGroups(table models.ShutdownsTable) error {
+	stored, exists, err := s.repo.Get(shutdownsTableKey)
+	if err != nil {
+		return fmt.Errorf("failed to get stored shutdowns table: %w", err)
+	}
+
+	incoming := groupSet(table)
+	var mismatch string
+	switch {
+	case exists && groupSet(stored) != incoming:
+		mismatch = fmt.Sprintf("stored groups [%s], loaded groups [%s]", groupSet(stored), incoming)
+	case !exists && len(table.Groups) != s.expectedGroups:
+		mismatch = fmt.Sprintf("expected %d groups, loaded groups [%s]", s.expectedGroups, incoming)
+	default:
+		return nil
+	}
+
+	if mismatch != s.lastMismatch {
+		s.lastMismatch = mismatch
+		slog.Warn("shutdowns table groups mismatch", "mismatch", mismatch)
+		s.notifier.NotifyAdmins("⚠️ Графік не оновлено, групи не збігаються: " + mismatch +
+			"\nЯкщо новий графік правильний, застосуйте його командою /force_refresh")
+	}
+	return fmt.Errorf("shutdowns table groups mismatch: %s", mismatch)
+}
+
+// groupSet returns sorted comma separated group numbers of the table
+func groupSet(table models.ShutdownsTable) string {
+	nums := make([]int, 0, len(table.Groups))
+	for _, g := range table.Groups {
+		nums = append(nums, g.Number)
+	}
+	sort.Ints(nums)
+
+	res := make([]string, len(nums))
+	for i, num := range nums {
+		res[i] = strconv.Itoa(num)
 	}
+	return strings.Join(res, ",")
 }
 
-func NewShutdownsService(repo Repository, loader TableLoader) *Service {
+func NewShutdownsService(
+	repo Repository, revisions RevisionRepository, loader TableLoader, notifier AdminNotifier, expectedGroups int,
+) *Service {
 	return &Service{
-		repo:   repo,
-		loader: loader,
+		repo:           repo,
+		revisions:      revisions,
+		loader:         loader,
+		notifier:       notifier,
+		expectedGroups: expectedGroups,
 	}
 }
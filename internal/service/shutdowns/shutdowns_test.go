@@ -0,0 +1,214 @@
+package shutdowns
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type memoryRepo struct {
+	tables map[string]models.ShutdownsTable
+}
+
+func (r *memoryRepo) Get(key string) (models.ShutdownsTable, bool, error) {
+	t, ok := r.tables[key]
+	return t, ok, nil
+}
+
+func (r *memoryRepo) Put(t models.ShutdownsTable) (models.ShutdownsTable, error) {
+	r.tables[t.ID] = t
+	return t, nil
+}
+
+type memoryRevisions struct {
+	revs map[string][]models.ScheduleRevision
+}
+
+func (r *memoryRevisions) Add(date string, rev models.ScheduleRevision) error {
+	if revs := r.revs[date]; len(revs) > 0 && revs[len(revs)-1].Hash == rev.Hash {
+		return nil
+	}
+	r.revs[date] = append(r.revs[date], rev)
+	return nil
+}
+
+func (r *memoryRevisions) GetAll(date string) ([]models.ScheduleRevision, error) {
+	return r.revs[date], nil
+}
+
+type countingNotifier struct {
+	msgs []string
+}
+
+func (n *countingNotifier) NotifyAdmins(msg string) {
+	n.msgs = append(n.msgs, msg)
+}
+
+func table(groups ...int) models.ShutdownsTable {
+	res := models.ShutdownsTable{Date: "15 січня", Groups: make(map[string]models.ShutdownGroup)}
+	for _, num := range groups {
+		res.Groups[strconv.Itoa(num)] = models.ShutdownGroup{Number: num}
+	}
+	return res
+}
+
+func groupsRange(from, to int) []int {
+	res := make([]int, 0)
+	for i := from; i <= to; i++ {
+		res = append(res, i)
+	}
+	return res
+}
+
+func TestService_RefreshShutdownsTable(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     *models.ShutdownsTable
+		loaded     models.ShutdownsTable
+		wantStored bool
+	}{
+		{
+			name:       "same groups",
+			stored:     ptr(table(groupsRange(1, 3)...)),
+			loaded:     table(groupsRange(1, 3)...),
+			wantStored: true,
+		},
+		{
+			name:   "shrink",
+			stored: ptr(table(groupsRange(1, 3)...)),
+			loaded: table(groupsRange(1, 2)...),
+		},
+		{
+			name:   "growth",
+			stored: ptr(table(groupsRange(1, 3)...)),
+			loaded: table(groupsRange(1, 4)...),
+		},
+		{
+			name:   "renumbering",
+			stored: ptr(table(groupsRange(1, 3)...)),
+			loaded: table(groupsRange(2, 4)...),
+		},
+		{
+			name:       "first table with expected groups",
+			loaded:     table(groupsRange(1, 3)...),
+			wantStored: true,
+		},
+		{
+			name:   "first table with unexpected groups",
+			loaded: table(groupsRange(1, 2)...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryRepo{tables: make(map[string]models.ShutdownsTable)}
+			if tt.stored != nil {
+				stored := *tt.stored
+				stored.ID = shutdownsTableKey
+				repo.tables[shutdownsTableKey] = stored
+			}
+			notifier := &countingNotifier{}
+			loaded := tt.loaded
+			revisions := &memoryRevisions{revs: make(map[string][]models.ScheduleRevision)}
+			s := NewShutdownsService(repo, revisions, func() (models.ShutdownsTable, error) { return loaded, nil },
+				notifier, 3)
+
+			for i := 0; i < 2; i++ {
+				err := s.RefreshShutdownsTable()
+				if (err == nil) != tt.wantStored {
+					t.Fatalf("RefreshShutdownsTable() error = %v, want stored = %t", err, tt.wantStored)
+				}
+			}
+			if got := groupSet(repo.tables[shutdownsTableKey]); (got == groupSet(tt.loaded)) != tt.wantStored {
+				t.Errorf("stored groups = [%s], loaded groups = [%s], want stored = %t",
+					got, groupSet(tt.loaded), tt.wantStored)
+			}
+			wantMsgs := 1
+			if tt.wantStored {
+				wantMsgs = 0
+			}
+			if len(notifier.msgs) != wantMsgs {
+				t.Errorf("admin notifications = %d, want %d", len(notifier.msgs), wantMsgs)
+			}
+
+			if err := s.ForceRefreshShutdownsTable(); err != nil {
+				t.Fatalf("ForceRefreshShutdownsTable() error = %v", err)
+			}
+			if got := groupSet(repo.tables[shutdownsTableKey]); got != groupSet(tt.loaded) {
+				t.Errorf("stored groups after force = [%s], want [%s]", got, groupSet(tt.loaded))
+			}
+		})
+	}
+}
+
+func TestService_RefreshShutdownsTable_AfterForce(t *testing.T) {
+	repo := &memoryRepo{tables: make(map[string]models.ShutdownsTable)}
+	stored := table(groupsRange(1, 3)...)
+	stored.ID = shutdownsTableKey
+	repo.tables[shutdownsTableKey] = stored
+	notifier := &countingNotifier{}
+	loaded := table(groupsRange(1, 4)...)
+	s := NewShutdownsService(repo, &memoryRevisions{revs: make(map[string][]models.ScheduleRevision)},
+		func() (models.ShutdownsTable, error) { return loaded, nil }, notifier, 3)
+
+	if err := s.RefreshShutdownsTable(); err == nil {
+		t.Fatal("RefreshShutdownsTable() error = nil, want groups mismatch")
+	}
+	if err := s.ForceRefreshShutdownsTable(); err != nil {
+		t.Fatalf("ForceRefreshShutdownsTable() error = %v", err)
+	}
+
+	// forced table is accepted as the new layout, so regular refreshes work again
+	loaded.Date = "16 січня"
+	if err := s.RefreshShutdownsTable(); err != nil {
+		t.Fatalf("RefreshShutdownsTable() after force error = %v", err)
+	}
+	if got := repo.tables[shutdownsTableKey].Date; got != loaded.Date {
+		t.Errorf("stored date = %s, want %s", got, loaded.Date)
+	}
+	if len(notifier.msgs) != 1 {
+		t.Errorf("admin notifications = %d, want 1", len(notifier.msgs))
+	}
+}
+
+func ptr(t models.ShutdownsTable) *models.ShutdownsTable {
+	return &t
+}
+
+func TestService_Revisions(t *testing.T) {
+	loaded := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups:  map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.ON}}},
+	}
+	repo := &memoryRepo{tables: make(map[string]models.ShutdownsTable)}
+	revisions := &memoryRevisions{revs: make(map[string][]models.ScheduleRevision)}
+	s := NewShutdownsService(repo, revisions, func() (models.ShutdownsTable, error) { return loaded, nil },
+		&countingNotifier{}, 1)
+
+	refresh := func() {
+		t.Helper()
+		if err := s.RefreshShutdownsTable(); err != nil {
+			t.Fatalf("RefreshShutdownsTable() error = %v", err)
+		}
+	}
+	refresh()
+	// fetch time and source don't make a new revision
+	loaded.UpdatedAt = loaded.UpdatedAt.Add(time.Minute)
+	loaded.Source = "https://example.com"
+	refresh()
+	loaded.Groups = map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.OFF}}}
+	refresh()
+
+	date, revs, err := s.Revisions()
+	if err != nil {
+		t.Fatalf("Revisions() error = %v", err)
+	}
+	if date != loaded.Date || len(revs) != 2 {
+		t.Fatalf("Revisions() = %s, %v, want 2 revisions of %s", date, revs, loaded.Date)
+	}
+	if revs[0].Hash == revs[1].Hash {
+		t.Errorf("different tables have the same hash %s", revs[0].Hash)
+	}
+}
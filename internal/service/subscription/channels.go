@@ -0,0 +1,139 @@
+package subscription
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type ChannelSender interface {
+	Send(chatID int64, text string, silent bool) (int, error)
+	Edit(chatID int64, messageID int, text string) error
+	Pin(chatID int64, messageID int) error
+	Delete(chatID int64, messageID int) error
+}
+
+type ChannelPostRepository interface {
+	Get(channelID int64) (models.ChannelPost, bool, error)
+	Put(p models.ChannelPost) (models.ChannelPost, error)
+}
+
+// ChannelPublisher maintains a pinned daily schedule message in a channel per group
+type ChannelPublisher struct {
+	repo             ChannelPostRepository
+	shutdownsService ShutdownsService
+	sender           ChannelSender
+	suspensions      GroupSuspensions
+	channels         map[string]int64
+
+	publishMx sync.Mutex
+}
+
+func (p *ChannelPublisher) Publish() error {
+	p.publishMx.Lock()
+	defer p.publishMx.Unlock()
+
+	if len(p.channels) == 0 {
+		return nil
+	}
+
+	table, ok, err := p.shutdownsService.GetShutdownsTable()
+	if err != nil {
+		return fmt.Errorf("failed to get shutdowns table: %w", err)
+	}
+	if !ok {
+		// table is not ready yet
+		return nil
+	}
+
+	groups := make([]string, 0, len(p.channels))
+	for groupNum := range p.channels {
+		groups = append(groups, groupNum)
+	}
+	sort.Strings(groups)
+
+	for _, groupNum := range groups {
+		if p.suspensions.IsSuspended(groupNum) {
+			continue
+		}
+		// every channel is processed independently, so one broken channel doesn't block the rest
+		if err = p.publishGroup(p.channels[groupNum], groupNum, table); err != nil {
+			slog.Error("failed to publish schedule to channel", "error", err,
+				"channelID", p.channels[groupNum], "group", groupNum)
+		}
+	}
+
+	return nil
+}
+
+func (p *ChannelPublisher) publishGroup(channelID int64, groupNum string, table models.ShutdownsTable) error {
+	group, ok := table.Groups[groupNum]
+	if !ok {
+		return fmt.Errorf("group=%s not found in shutdowns table", groupNum)
+	}
+
+	post, exists, err := p.repo.Get(channelID)
+	if err != nil {
+		return fmt.Errorf("failed to get channel post: %w", err)
+	}
+	hash := group.Hash(fmt.Sprintf("%s:", table.Date))
+	if exists && post.Date == table.Date && post.Hash == hash {
+		return nil
+	}
+
+	// channel posts outlive the day they were published for, so they keep the plain date
+	msg, rendered, err := renderGroupsSchedule(table, []string{groupNum}, models.Settings{ShowFullDay: true}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to render group schedule: %w", err)
+	}
+	if rendered == 0 {
+		return fmt.Errorf("failed to render group=%s schedule: %w", groupNum, errMalformedGroup)
+	}
+
+	if exists && post.Date == table.Date {
+		if err = p.sender.Edit(channelID, post.MessageID, msg); err != nil {
+			return fmt.Errorf("failed to edit channel post: %w", err)
+		}
+	} else {
+		messageID, err := p.sender.Send(channelID, msg, true)
+		if err != nil {
+			return fmt.Errorf("failed to send channel post: %w", err)
+		}
+		if messageID == 0 {
+			return fmt.Errorf("bot can't post to the channel anymore")
+		}
+		if err = p.sender.Pin(channelID, messageID); err != nil {
+			slog.Warn("failed to pin channel post", "error", err, "channelID", channelID, "messageID", messageID)
+		}
+		post = models.ChannelPost{
+			ChannelID: channelID,
+			Group:     groupNum,
+			Date:      table.Date,
+			MessageID: messageID,
+		}
+	}
+
+	post.Hash = hash
+	if _, err = p.repo.Put(post); err != nil {
+		return fmt.Errorf("failed to put channel post: %w", err)
+	}
+
+	return nil
+}
+
+func NewChannelPublisher(
+	repo ChannelPostRepository, shutdownsService ShutdownsService, sender ChannelSender, suspensions GroupSuspensions,
+	channels map[string]int64,
+) *ChannelPublisher {
+	return &ChannelPublisher{
+		repo:             repo,
+		shutdownsService: shutdownsService,
+		sender:           sender,
+		suspensions:      suspensions,
+		channels:         channels,
+	}
+}
@@ -0,0 +1,124 @@
+package subscription
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type memoryChannelPosts struct {
+	posts map[int64]models.ChannelPost
+}
+
+func (r *memoryChannelPosts) Get(channelID int64) (models.ChannelPost, bool, error) {
+	p, ok := r.posts[channelID]
+	return p, ok, nil
+}
+
+func (r *memoryChannelPosts) Put(p models.ChannelPost) (models.ChannelPost, error) {
+	r.posts[p.ChannelID] = p
+	return p, nil
+}
+
+func TestChannelPublisher_Publish_Suspended(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.OFF}},
+			"2": {Number: 2, Items: []models.Status{models.ON}},
+		},
+	}
+	posts := &memoryChannelPosts{posts: make(map[int64]models.ChannelPost)}
+	sender := &fakeChannelSender{}
+	p := NewChannelPublisher(posts, &fakeShutdowns{table: table}, sender, suspendedGroups{"2": true},
+		map[string]int64{"1": -100, "2": -200})
+
+	if err := p.Publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if _, ok := posts.posts[-200]; ok || sender.sent != 1 {
+		t.Errorf("posts = %v, sent = %d, want only not suspended group published", posts.posts, sender.sent)
+	}
+}
+
+// recordingChannelSender records texts of sent and edited posts
+type recordingChannelSender struct {
+	fakeChannelSender
+	texts map[int]string
+}
+
+func (s *recordingChannelSender) Send(chatID int64, text string, silent bool) (int, error) {
+	messageID, err := s.fakeChannelSender.Send(chatID, text, silent)
+	s.texts[messageID] = text
+	return messageID, err
+}
+
+func (s *recordingChannelSender) Edit(chatID int64, messageID int, text string) error {
+	if err := s.fakeChannelSender.Edit(chatID, messageID, text); err != nil {
+		return err
+	}
+	s.texts[messageID] = text
+	return nil
+}
+
+func TestChannelPublisher_Publish(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "12:00"}, {From: "12:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.ON, models.OFF}},
+		},
+	}
+	shutdowns := &fakeShutdowns{table: table}
+	posts := &memoryChannelPosts{posts: make(map[int64]models.ChannelPost)}
+	sender := &recordingChannelSender{texts: make(map[int]string)}
+	p := NewChannelPublisher(posts, shutdowns, sender, suspendedGroups{}, map[string]int64{"1": -100})
+
+	// publish
+	if err := p.Publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	post, ok := posts.posts[-100]
+	if !ok || sender.sent != 1 || post.MessageID != 101 || post.Date != "15 січня" || post.Group != "1" {
+		t.Fatalf("post = %+v, sent = %d, want a new post", post, sender.sent)
+	}
+	if len(sender.pinned) != 1 || sender.pinned[0] != 101 {
+		t.Errorf("pinned = %v, want the new post pinned", sender.pinned)
+	}
+	if !strings.Contains(sender.texts[101], "Група 1") {
+		t.Errorf("post text = %q, want group 1 schedule", sender.texts[101])
+	}
+
+	// unchanged schedule
+	if err := p.Publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if sender.sent != 1 || sender.edits != 0 {
+		t.Errorf("sent = %d, edits = %d, want unchanged schedule skipped", sender.sent, sender.edits)
+	}
+
+	// changed schedule of the same date
+	changed := sender.texts[101]
+	table.Groups["1"] = models.ShutdownGroup{Number: 1, Items: []models.Status{models.OFF, models.OFF}}
+	if err := p.Publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if sender.sent != 1 || sender.edits != 1 || sender.texts[101] == changed {
+		t.Errorf("sent = %d, edits = %d, want the post edited", sender.sent, sender.edits)
+	}
+	if posts.posts[-100].Hash == post.Hash {
+		t.Errorf("post hash is not updated after edit")
+	}
+
+	// next date
+	table.Date = "16 січня"
+	shutdowns.table = table
+	if err := p.Publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if post = posts.posts[-100]; sender.sent != 2 || post.MessageID != 102 || post.Date != "16 січня" {
+		t.Errorf("post = %+v, sent = %d, want a new post for the next date", post, sender.sent)
+	}
+}
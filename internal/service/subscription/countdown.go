@@ -0,0 +1,231 @@
+package subscription
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
+)
+
+type CountdownPostRepository interface {
+	Get(chatID int64) (models.CountdownPost, bool, error)
+	Put(p models.CountdownPost) (models.CountdownPost, error)
+	Delete(chatID int64) error
+}
+
+// CountdownPublisher maintains a pinned message telling when power status of the chat groups changes next, for chats
+// which enabled the countdown setting. The message is edited only when its text changes
+type CountdownPublisher struct {
+	repo             CountdownPostRepository
+	subscriptions    Repository
+	shutdownsService ShutdownsService
+	sender           ChannelSender
+	suspensions      GroupSuspensions
+	clock            clock.Clock
+
+	publishMx sync.Mutex
+}
+
+func (p *CountdownPublisher) Publish() error {
+	p.publishMx.Lock()
+	defer p.publishMx.Unlock()
+
+	table, ok, err := p.shutdownsService.GetShutdownsTable()
+	if err != nil {
+		return fmt.Errorf("failed to get shutdowns table: %w", err)
+	}
+	if !ok {
+		// table is not ready yet
+		return nil
+	}
+
+	subs, err := p.subscriptions.GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	now := p.clock.Now()
+	// periods are applied to the current time of day, so the table published for tomorrow can't be counted down
+	today := relativeDay(table.Date, now) == "сьогодні"
+	for _, sub := range subs {
+		if sub.IsDisabled() {
+			continue
+		}
+
+		var text string
+		if sub.Settings.PinnedCountdown && today {
+			if text, err = p.render(sub, table, now); err != nil {
+				slog.Error("failed to render countdown", "error", err, "chatID", sub.ChatID)
+				continue
+			}
+		}
+		if text == "" {
+			// countdown is turned off or there is nothing to count down today, so the old one would only go stale
+			if err = p.removeChat(sub.ChatID); err != nil {
+				slog.Error("failed to remove countdown", "error", err, "chatID", sub.ChatID)
+			}
+			continue
+		}
+		if err = p.publishChat(sub.ChatID, text); err != nil {
+			slog.Error("failed to publish countdown", "error", err, "chatID", sub.ChatID)
+		}
+	}
+
+	return nil
+}
+
+func (p *CountdownPublisher) render(sub models.Subscription, table models.ShutdownsTable, now time.Time) (string, error) {
+	groupNums := make([]string, 0, len(sub.Groups))
+	for groupNum := range sub.Groups {
+		if !p.suspensions.IsSuspended(groupNum) {
+			groupNums = append(groupNums, groupNum)
+		}
+	}
+	sort.Strings(groupNums)
+
+	lines := make([]string, 0, len(groupNums))
+	for _, groupNum := range groupNums {
+		group, ok := table.Groups[groupNum]
+		if !ok {
+			continue
+		}
+		if len(group.Items) != len(table.Periods) {
+			slog.Warn("skipping malformed group", "error", errMalformedGroup, "chatID", sub.ChatID, "group", groupNum)
+			continue
+		}
+		line, ok, err := renderCountdown(table.Periods, group.Items, now, sub.Settings.TwelveHourTime)
+		if err != nil {
+			return "", fmt.Errorf("failed to render group=%s countdown: %w", groupNum, err)
+		}
+		if ok {
+			lines = append(lines, "Група "+groupNum+": "+line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// publishChat edits the pinned countdown message or sends and pins a new one if there is none or it can't be edited,
+// e.g. because it was deleted
+func (p *CountdownPublisher) publishChat(chatID int64, text string) error {
+	post, exists, err := p.repo.Get(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get countdown post: %w", err)
+	}
+	if exists && post.Text == text {
+		return nil
+	}
+
+	if exists {
+		if err = p.sender.Edit(chatID, post.MessageID, text); err == nil {
+			post.Text = text
+			if _, err = p.repo.Put(post); err != nil {
+				return fmt.Errorf("failed to put countdown post: %w", err)
+			}
+			return nil
+		}
+		slog.Warn("failed to edit countdown, sending a new one", "error", err, "chatID", chatID,
+			"messageID", post.MessageID)
+	}
+
+	messageID, err := p.sender.Send(chatID, text, true)
+	if err != nil {
+		return fmt.Errorf("failed to send countdown: %w", err)
+	}
+	if messageID == 0 {
+		// bot can't send messages to the chat anymore, subscription is disabled by the sender
+		return nil
+	}
+	if err = p.sender.Pin(chatID, messageID); err != nil {
+		slog.Warn("failed to pin countdown", "error", err, "chatID", chatID, "messageID", messageID)
+	}
+
+	if _, err = p.repo.Put(models.CountdownPost{ChatID: chatID, MessageID: messageID, Text: text}); err != nil {
+		return fmt.Errorf("failed to put countdown post: %w", err)
+	}
+	return nil
+}
+
+// removeChat deletes the countdown message of the chat, if there is one, and forgets it
+func (p *CountdownPublisher) removeChat(chatID int64) error {
+	post, exists, err := p.repo.Get(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get countdown post: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	if err = p.sender.Delete(chatID, post.MessageID); err != nil {
+		// e.g. message was already deleted by the chat admin
+		slog.Warn("failed to delete countdown", "error", err, "chatID", chatID, "messageID", post.MessageID)
+	}
+	if err = p.repo.Delete(chatID); err != nil {
+		return fmt.Errorf("failed to delete countdown post: %w", err)
+	}
+	return nil
+}
+
+// renderCountdown describes the current status of the group and when it changes. It returns false if now is not
+// covered by the table periods
+func renderCountdown(
+	periods []models.Period, items []models.Status, now time.Time, twelveHour bool,
+) (string, bool, error) {
+	w, ok, err := windowAt(periods, items, now)
+	if err != nil || !ok {
+		return "", false, err
+	}
+
+	if w.Open {
+		switch w.Status {
+		case models.ON:
+			return "🟢 Світло є до кінця доби", true, nil
+		case models.OFF:
+			return "🔴 Світла немає до кінця доби", true, nil
+		default:
+			return "🟡 Можливо заживлено до кінця доби", true, nil
+		}
+	}
+
+	at := formatTime(w.To.Format("15:04"), twelveHour)
+	left := formatMinutes(int(w.To.Sub(now.Truncate(time.Minute)).Minutes()))
+	switch w.Status {
+	case models.ON:
+		return fmt.Sprintf("🟢 Світло є, наступна зміна о %s (через %s)", at, left), true, nil
+	case models.OFF:
+		return fmt.Sprintf("🔴 Світла немає, очікуване заживлення о %s (через %s)", at, left), true, nil
+	default:
+		return fmt.Sprintf("🟡 Можливо заживлено, наступна зміна о %s (через %s)", at, left), true, nil
+	}
+}
+
+// formatMinutes formats duration as "1 год 12 хв"
+func formatMinutes(minutes int) string {
+	hours, minutes := minutes/60, minutes%60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%d хв", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%d год", hours)
+	default:
+		return fmt.Sprintf("%d год %d хв", hours, minutes)
+	}
+}
+
+func NewCountdownPublisher(
+	repo CountdownPostRepository, subscriptions Repository, shutdownsService ShutdownsService, sender ChannelSender,
+	suspensions GroupSuspensions, clk clock.Clock,
+) *CountdownPublisher {
+	return &CountdownPublisher{
+		repo:             repo,
+		subscriptions:    subscriptions,
+		shutdownsService: shutdownsService,
+		sender:           sender,
+		suspensions:      suspensions,
+		clock:            clk,
+	}
+}
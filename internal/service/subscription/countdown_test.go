@@ -0,0 +1,233 @@
+package subscription
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
+)
+
+func TestRenderCountdown(t *testing.T) {
+	periods := []models.Period{
+		{From: "00:00", To: "04:00"},
+		{From: "04:00", To: "08:00"},
+		{From: "08:00", To: "12:00"},
+		{From: "12:00", To: "16:00"},
+		{From: "16:00", To: "20:00"},
+		{From: "20:00", To: "24:00"},
+	}
+	items := []models.Status{models.ON, models.OFF, models.OFF, models.MAYBE, models.ON, models.ON}
+
+	tests := []struct {
+		name       string
+		now        time.Time
+		twelveHour bool
+		want       string
+	}{
+		{
+			name: "on",
+			now:  time.Date(2024, 1, 15, 2, 30, 0, 0, kyivTime),
+			want: "🟢 Світло є, наступна зміна о 04:00 (через 1 год 30 хв)",
+		},
+		{
+			name: "off joined with the next period",
+			now:  time.Date(2024, 1, 15, 10, 48, 0, 0, kyivTime),
+			want: "🔴 Світла немає, очікуване заживлення о 12:00 (через 1 год 12 хв)",
+		},
+		{
+			name:       "twelve hour time",
+			now:        time.Date(2024, 1, 15, 4, 0, 0, 0, kyivTime),
+			twelveHour: true,
+			want:       "🔴 Світла немає, очікуване заживлення о 12:00 PM (через 8 год)",
+		},
+		{
+			name: "maybe",
+			now:  time.Date(2024, 1, 15, 15, 15, 0, 0, kyivTime),
+			want: "🟡 Можливо заживлено, наступна зміна о 16:00 (через 45 хв)",
+		},
+		{
+			name: "till the end of the day",
+			now:  time.Date(2024, 1, 15, 17, 0, 0, 0, kyivTime),
+			want: "🟢 Світло є до кінця доби",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := renderCountdown(periods, items, tt.now, tt.twelveHour)
+			if err != nil || !ok {
+				t.Fatalf("renderCountdown() = %v, %v, want rendered countdown", ok, err)
+			}
+			if got != tt.want {
+				t.Errorf("renderCountdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok, _ := renderCountdown(periods[:2], items[:2], time.Date(2024, 1, 15, 9, 0, 0, 0, kyivTime), false); ok {
+		t.Errorf("renderCountdown() rendered time not covered by periods")
+	}
+}
+
+type memoryCountdownPosts struct {
+	posts map[int64]models.CountdownPost
+}
+
+func (r *memoryCountdownPosts) Get(chatID int64) (models.CountdownPost, bool, error) {
+	p, ok := r.posts[chatID]
+	return p, ok, nil
+}
+
+func (r *memoryCountdownPosts) Put(p models.CountdownPost) (models.CountdownPost, error) {
+	r.posts[p.ChatID] = p
+	return p, nil
+}
+
+func (r *memoryCountdownPosts) Delete(chatID int64) error {
+	delete(r.posts, chatID)
+	return nil
+}
+
+type fakeChannelSender struct {
+	sent    int
+	edits   int
+	pinned  []int
+	deleted []int
+	editErr error
+}
+
+func (s *fakeChannelSender) Send(int64, string, bool) (int, error) {
+	s.sent++
+	return 100 + s.sent, nil
+}
+
+func (s *fakeChannelSender) Edit(int64, int, string) error {
+	if s.editErr != nil {
+		return s.editErr
+	}
+	s.edits++
+	return nil
+}
+
+func (s *fakeChannelSender) Pin(_ int64, messageID int) error {
+	s.pinned = append(s.pinned, messageID)
+	return nil
+}
+
+func (s *fakeChannelSender) Delete(_ int64, messageID int) error {
+	s.deleted = append(s.deleted, messageID)
+	return nil
+}
+
+func TestCountdownPublisher_publishChat(t *testing.T) {
+	posts := &memoryCountdownPosts{posts: make(map[int64]models.CountdownPost)}
+	sender := &fakeChannelSender{}
+	p := NewCountdownPublisher(posts, newMemoryRepo(), &fakeShutdowns{}, sender, noSuspensions{}, clock.New())
+
+	for _, text := range []string{"a", "a", "b", "b"} {
+		if err := p.publishChat(1, text); err != nil {
+			t.Fatalf("publishChat() error = %v", err)
+		}
+	}
+	if sender.sent != 1 || sender.edits != 1 {
+		t.Errorf("sent = %d, edits = %d, want 1 and 1", sender.sent, sender.edits)
+	}
+	if posts.posts[1].Text != "b" || posts.posts[1].MessageID != 101 {
+		t.Errorf("post = %+v", posts.posts[1])
+	}
+
+	// deleted message can't be edited, so a new one is sent and pinned
+	sender.editErr = errors.New("message to edit not found")
+	if err := p.publishChat(1, "c"); err != nil {
+		t.Fatalf("publishChat() error = %v", err)
+	}
+	if len(sender.pinned) != 2 || posts.posts[1].MessageID != 102 {
+		t.Errorf("pinned = %v, post = %+v, want the new message pinned", sender.pinned, posts.posts[1])
+	}
+}
+
+func TestCountdownPublisher_Publish(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "08:00", To: "12:00"}, {From: "12:00", To: "16:00"}},
+		Groups:  map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.ON, models.OFF}}},
+	}
+	repo := newMemoryRepo(models.Subscription{
+		ChatID: 1, Groups: map[string]string{"1": ""}, Settings: models.Settings{PinnedCountdown: true},
+	})
+	posts := &memoryCountdownPosts{posts: make(map[int64]models.CountdownPost)}
+	sender := &fakeChannelSender{}
+	clk := clock.NewMock(time.Date(2024, 1, 15, 9, 0, 0, 0, kyivTime))
+	p := NewCountdownPublisher(posts, repo, &fakeShutdowns{table: table}, sender, noSuspensions{}, clk)
+
+	publish := func() {
+		t.Helper()
+		if err := p.Publish(); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	setCountdown := func(enabled bool) {
+		sub := repo.subs[1]
+		sub.Settings.PinnedCountdown = enabled
+		repo.subs[1] = sub
+	}
+
+	publish()
+	if want := "Група 1: 🟢 Світло є, наступна зміна о 12:00 (через 3 год)"; posts.posts[1].Text != want {
+		t.Errorf("countdown = %q, want %q", posts.posts[1].Text, want)
+	}
+
+	setCountdown(false)
+	publish()
+	publish()
+	if _, exists := posts.posts[1]; exists || len(sender.deleted) != 1 {
+		t.Errorf("post = %+v, deleted = %v, want countdown removed once turned off", posts.posts[1], sender.deleted)
+	}
+
+	// nothing to count down after the last period, so the stale countdown is removed
+	setCountdown(true)
+	publish()
+	clk.Advance(8 * time.Hour)
+	publish()
+	if _, exists := posts.posts[1]; exists || len(sender.deleted) != 2 {
+		t.Errorf("post = %+v, deleted = %v, want countdown removed", posts.posts[1], sender.deleted)
+	}
+}
+
+func TestCountdownPublisher_Publish_TomorrowTable(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "08:00", To: "12:00"}, {From: "12:00", To: "16:00"}},
+		Groups:  map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.OFF, models.ON}}},
+	}
+	repo := newMemoryRepo(models.Subscription{
+		ChatID: 1, Groups: map[string]string{"1": ""}, Settings: models.Settings{PinnedCountdown: true},
+	})
+	posts := &memoryCountdownPosts{posts: make(map[int64]models.CountdownPost)}
+	sender := &fakeChannelSender{}
+	shutdowns := &fakeShutdowns{table: table}
+	clk := clock.NewMock(time.Date(2024, 1, 15, 9, 0, 0, 0, kyivTime))
+	p := NewCountdownPublisher(posts, repo, shutdowns, sender, noSuspensions{}, clk)
+
+	if err := p.Publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if _, exists := posts.posts[1]; !exists {
+		t.Fatalf("countdown of today's table is not published")
+	}
+
+	// provider published tomorrow's table
+	table.Date = "16 січня"
+	shutdowns.table = table
+	if err := p.Publish(); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if post, exists := posts.posts[1]; exists || len(sender.deleted) != 1 {
+		t.Errorf("post = %+v, deleted = %v, want countdown removed for tomorrow's table", post, sender.deleted)
+	}
+	if sender.sent != 1 {
+		t.Errorf("sent = %d, want no countdown of tomorrow's table", sender.sent)
+	}
+}
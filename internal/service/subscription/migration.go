@@ -0,0 +1,168 @@
+package subscription
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+const exportTokenTTL = 7 * 24 * time.Hour
+
+// export token layout: groups bitmask (3 bytes), settings bitmask (1 byte), expiration unix time (4 bytes),
+// nonce (4 bytes) and truncated HMAC-SHA256 signature of all the previous bytes (8 bytes). It is kept compact to fit
+// into /start payload, which is limited to 64 characters
+const (
+	exportGroupsSize    = 3
+	exportPayloadSize   = exportGroupsSize + 1 + 4 + 4
+	exportSignatureSize = 8
+)
+
+var exportEncoding = base64.RawURLEncoding
+
+type NonceRepository interface {
+	// Use stores the nonce until it expires and returns false if it is stored already
+	Use(nonce string, expiresAt time.Time) (bool, error)
+	// Release deletes the nonce, so it can be used again
+	Release(nonce string) error
+}
+
+// Migrator moves subscription configuration between chats, e.g. when user switches Telegram account. Configuration
+// is exported as a signed token which can be imported once before it expires
+type Migrator struct {
+	service *Service
+	nonces  NonceRepository
+	secret  []byte
+}
+
+// Export returns a token with groups and settings of the chat subscription
+func (m *Migrator) Export(chatID int64) (string, error) {
+	if len(m.secret) == 0 {
+		return "", models.ErrExportDisabled
+	}
+
+	sub, exists, err := m.service.GetSubscription(chatID)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", models.ErrSubscriptionNotFound
+	}
+
+	payload := make([]byte, exportPayloadSize, exportPayloadSize+exportSignatureSize)
+	var groups uint32
+	for groupNum := range sub.Groups {
+		num, err := strconv.Atoi(groupNum)
+		if err != nil || num < 1 || num > exportGroupsSize*8 {
+			return "", fmt.Errorf("%w: %s", models.ErrInvalidGroup, groupNum)
+		}
+		groups |= 1 << (num - 1)
+	}
+	payload[0], payload[1], payload[2] = byte(groups>>16), byte(groups>>8), byte(groups)
+	payload[3] = encodeSettings(sub.Settings)
+	binary.BigEndian.PutUint32(payload[4:8], uint32(time.Now().Add(exportTokenTTL).Unix()))
+	if _, err = rand.Read(payload[8:12]); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return exportEncoding.EncodeToString(append(payload, m.sign(payload)...)), nil
+}
+
+// Import subscribes the chat to groups with settings from the exported token. Token can be imported only once
+func (m *Migrator) Import(chatID int64, chatType models.ChatType, token string) (models.Subscription, error) {
+	if len(m.secret) == 0 {
+		return models.Subscription{}, models.ErrExportDisabled
+	}
+
+	data, err := exportEncoding.DecodeString(token)
+	if err != nil || len(data) != exportPayloadSize+exportSignatureSize {
+		return models.Subscription{}, models.ErrInvalidExportToken
+	}
+	payload, signature := data[:exportPayloadSize], data[exportPayloadSize:]
+	if !hmac.Equal(signature, m.sign(payload)) {
+		return models.Subscription{}, models.ErrInvalidExportToken
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint32(payload[4:8])), 0)
+	if !time.Now().Before(expiresAt) {
+		return models.Subscription{}, models.ErrExportTokenExpired
+	}
+
+	groups := make([]string, 0)
+	bitmask := uint32(payload[0])<<16 | uint32(payload[1])<<8 | uint32(payload[2])
+	for num := 1; num <= exportGroupsSize*8; num++ {
+		if bitmask&(1<<(num-1)) != 0 {
+			groups = append(groups, strconv.Itoa(num))
+		}
+	}
+	sort.Strings(groups)
+	if len(groups) == 0 {
+		return models.Subscription{}, models.ErrInvalidExportToken
+	}
+
+	// nonce is used before the import, so the same token can't be imported concurrently, and it is released if the
+	// import fails, so the token is spent only by a successful one
+	nonce := hex.EncodeToString(payload[8:12])
+	fresh, err := m.nonces.Use(nonce, expiresAt)
+	if err != nil {
+		return models.Subscription{}, fmt.Errorf("failed to use export token nonce: %w", err)
+	}
+	if !fresh {
+		return models.Subscription{}, models.ErrExportTokenUsed
+	}
+
+	sub, err := m.service.apply(chatID, chatType, groups, decodeSettings(payload[3]))
+	if err != nil {
+		if releaseErr := m.nonces.Release(nonce); releaseErr != nil {
+			slog.Error("failed to release export token nonce", "error", releaseErr, "chatID", chatID)
+		}
+		return models.Subscription{}, err
+	}
+	return sub, nil
+}
+
+func (m *Migrator) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)[:exportSignatureSize]
+}
+
+func encodeSettings(s models.Settings) byte {
+	var res byte
+	for i, enabled := range []bool{
+		s.KeepHistory, s.AlwaysSilent, s.TwelveHourTime, s.ShowFullDay, s.PinnedCountdown,
+	} {
+		if enabled {
+			res |= 1 << i
+		}
+	}
+	return res
+}
+
+func decodeSettings(b byte) models.Settings {
+	return models.Settings{
+		KeepHistory:     b&(1<<0) != 0,
+		AlwaysSilent:    b&(1<<1) != 0,
+		TwelveHourTime:  b&(1<<2) != 0,
+		ShowFullDay:     b&(1<<3) != 0,
+		PinnedCountdown: b&(1<<4) != 0,
+	}
+}
+
+// NewMigrator creates migrator signing tokens with the secret. Empty secret disables export and import
+func NewMigrator(service *Service, nonces NonceRepository, secret []byte) *Migrator {
+	return &Migrator{
+		service: service,
+		nonces:  nonces,
+		secret:  secret,
+	}
+}
@@ -0,0 +1,133 @@
+package subscription
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type memoryNonces struct {
+	nonces map[string]time.Time
+}
+
+func (r *memoryNonces) Use(nonce string, expiresAt time.Time) (bool, error) {
+	if _, ok := r.nonces[nonce]; ok {
+		return false, nil
+	}
+	r.nonces[nonce] = expiresAt
+	return true, nil
+}
+
+func (r *memoryNonces) Release(nonce string) error {
+	delete(r.nonces, nonce)
+	return nil
+}
+
+// failingRepo fails the next puts
+type failingRepo struct {
+	*memoryRepo
+	failures int
+}
+
+func (r *failingRepo) Put(sub models.Subscription) (models.Subscription, error) {
+	if r.failures > 0 {
+		r.failures--
+		return sub, errors.New("disk is full")
+	}
+	return r.memoryRepo.Put(sub)
+}
+
+func newTestMigrator(repo *memoryRepo) *Migrator {
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, nil)
+	return NewMigrator(s, &memoryNonces{nonces: make(map[string]time.Time)}, []byte("secret"))
+}
+
+func TestMigrator_ExportImport(t *testing.T) {
+	settings := models.Settings{TwelveHourTime: true, ShowFullDay: true}
+	repo := newMemoryRepo(models.Subscription{
+		ChatID:   1,
+		Groups:   map[string]string{"3": "hash3", "18": "hash18"},
+		Settings: settings,
+	})
+	m := newTestMigrator(repo)
+
+	token, err := m.Export(1)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len("import_"+token) > 64 {
+		t.Errorf("Export() token=%s doesn't fit into /start payload", token)
+	}
+
+	sub, err := m.Import(2, models.ChatTypePrivate, token)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if want := map[string]string{"3": "", "18": ""}; !reflect.DeepEqual(sub.Groups, want) {
+		t.Errorf("Import() groups = %v, want %v", sub.Groups, want)
+	}
+	if sub.Settings != settings {
+		t.Errorf("Import() settings = %+v, want %+v", sub.Settings, settings)
+	}
+
+	if _, err = m.Import(3, models.ChatTypePrivate, token); !errors.Is(err, models.ErrExportTokenUsed) {
+		t.Errorf("second Import() error = %v, want %v", err, models.ErrExportTokenUsed)
+	}
+}
+
+func TestMigrator_ImportInvalid(t *testing.T) {
+	m := newTestMigrator(newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"5": ""}}))
+	token, err := m.Export(1)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	tampered := []byte(token)
+	tampered[0] ^= 1
+	if _, err = m.Import(2, models.ChatTypePrivate, string(tampered)); !errors.Is(err, models.ErrInvalidExportToken) {
+		t.Errorf("Import() tampered error = %v, want %v", err, models.ErrInvalidExportToken)
+	}
+
+	payload := make([]byte, exportPayloadSize)
+	payload[2] = 1
+	binary.BigEndian.PutUint32(payload[4:8], uint32(time.Now().Add(-time.Minute).Unix()))
+	expired := exportEncoding.EncodeToString(append(payload, m.sign(payload)...))
+	if _, err = m.Import(2, models.ChatTypePrivate, expired); !errors.Is(err, models.ErrExportTokenExpired) {
+		t.Errorf("Import() expired error = %v, want %v", err, models.ErrExportTokenExpired)
+	}
+
+	disabled := NewMigrator(m.service, m.nonces, nil)
+	if _, err = disabled.Export(1); !errors.Is(err, models.ErrExportDisabled) {
+		t.Errorf("Export() without secret error = %v, want %v", err, models.ErrExportDisabled)
+	}
+}
+
+func TestMigrator_ImportRetry(t *testing.T) {
+	repo := &failingRepo{memoryRepo: newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"5": ""}})}
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, nil)
+	m := NewMigrator(s, &memoryNonces{nonces: make(map[string]time.Time)}, []byte("secret"))
+	token, err := m.Export(1)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	repo.failures = 1
+	if _, err = m.Import(2, models.ChatTypePrivate, token); err == nil {
+		t.Fatal("Import() error = nil, want store failure")
+	}
+
+	sub, err := m.Import(2, models.ChatTypePrivate, token)
+	if err != nil {
+		t.Fatalf("retried Import() error = %v", err)
+	}
+	if want := map[string]string{"5": ""}; !reflect.DeepEqual(sub.Groups, want) {
+		t.Errorf("retried Import() groups = %v, want %v", sub.Groups, want)
+	}
+	if _, err = m.Import(3, models.ChatTypePrivate, token); !errors.Is(err, models.ErrExportTokenUsed) {
+		t.Errorf("Import() after success error = %v, want %v", err, models.ErrExportTokenUsed)
+	}
+}
@@ -0,0 +1,47 @@
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type PurgeLogRepository interface {
+	Add(e models.PurgeEvent) error
+	GetAll() ([]models.PurgeEvent, error)
+}
+
+type DeliveryLogRepository interface {
+	Add(entries map[int64]models.DeliveryLogEntry) error
+	Get(chatID int64) ([]models.DeliveryLogEntry, error)
+}
+
+// PurgeLog returns recorded purge events starting from the latest one
+func (s *Service) PurgeLog() ([]models.PurgeEvent, error) {
+	res, err := s.purgeLog.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get purge log: %w", err)
+	}
+	return res, nil
+}
+
+// logPurge records the event. Failure doesn't affect purging, so it is only logged
+func (s *Service) logPurge(chatID int64, action models.PurgeAction) {
+	e := models.PurgeEvent{
+		ChatHash: chatHash(chatID),
+		Action:   action,
+		At:       s.clock.Now(),
+	}
+	if err := s.purgeLog.Add(e); err != nil {
+		slog.Error("failed to add purge event", "error", err, "chatID", chatID, "action", action)
+	}
+}
+
+func chatHash(chatID int64) string {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(chatID, 10)))
+	return hex.EncodeToString(sum[:4])
+}
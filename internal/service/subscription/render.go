@@ -2,21 +2,29 @@ package subscription
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/Roma7-7-7/sso-notifier/models"
 )
 
 var messageTemplate = template.Must(template.New("message").Parse(`
-Графік стабілізаційних відключень на {{.Date}}:
+Графік стабілізаційних відключень на {{if .Day}}{{.Day}}, {{end}}{{.Date}}:
 
 {{range .Msgs}} {{.}}
-{{end}}
-`))
+{{end}}{{if .UpdatedAt}}
+Оновлено: {{.UpdatedAt}}{{if .Source}} • {{.Source}}{{end}}
+{{end}}`))
 
 type message struct {
-	Date string
-	Msgs []string
+	Day       string
+	Date      string
+	Msgs      []string
+	UpdatedAt string
+	Source    string
 }
 
 var groupMessageTemplate = template.Must(template.New("groupMessage").Parse(`Група {{.GroupNum}}:
@@ -32,17 +40,79 @@ type groupMessage struct {
 	Maybe    []models.Period
 }
 
-func renderMessage(date string, msgs []string) (string, error) {
+var genitiveMonths = map[string]time.Month{
+	"січня":     time.January,
+	"лютого":    time.February,
+	"березня":   time.March,
+	"квітня":    time.April,
+	"травня":    time.May,
+	"червня":    time.June,
+	"липня":     time.July,
+	"серпня":    time.August,
+	"вересня":   time.September,
+	"жовтня":    time.October,
+	"листопада": time.November,
+	"грудня":    time.December,
+}
+
+// relativeDay returns "сьогодні" or "завтра" when the provider date (e.g. "15 січня") is today or tomorrow in Kyiv.
+// Unparseable dates and zero now yield an empty string
+func relativeDay(date string, now time.Time) string {
+	if now.IsZero() {
+		return ""
+	}
+	fields := strings.Fields(date)
+	if len(fields) < 2 {
+		return ""
+	}
+	day, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return ""
+	}
+	month, ok := genitiveMonths[strings.ToLower(fields[1])]
+	if !ok {
+		return ""
+	}
+
+	now = now.In(kyivTime)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, kyivTime)
+	for offset, label := range []string{"сьогодні", "завтра"} {
+		d := today.AddDate(0, 0, offset)
+		if d.Day() == day && d.Month() == month {
+			return label
+		}
+	}
+	return ""
+}
+
+// renderMessage renders schedule message with a footer telling when and where the table was fetched.
+// The footer is not a part of group hashes, so it never triggers updates by itself.
+// Non-zero now prefixes the date with "сьогодні" or "завтра" when applicable
+func renderMessage(table models.ShutdownsTable, msgs []string, twelveHour bool, now time.Time) (string, error) {
+	msg := message{
+		Day:    relativeDay(table.Date, now),
+		Date:   table.Date,
+		Msgs:   msgs,
+		Source: table.Source,
+	}
+	if !table.UpdatedAt.IsZero() {
+		msg.UpdatedAt = formatTime(table.UpdatedAt.In(kyivTime).Format("15:04"), twelveHour)
+	}
+
 	var buf bytes.Buffer
-	err := messageTemplate.Execute(&buf, message{Date: date, Msgs: msgs})
+	err := messageTemplate.Execute(&buf, msg)
 	return buf.String(), err
 }
 
-func renderGroup(num string, periods []models.Period, statuses []models.Status) (string, error) {
+func renderGroup(num string, periods []models.Period, statuses []models.Status, twelveHour bool) (string, error) {
 	grouped := make(map[models.Status][]models.Period)
 
 	for i := 0; i < len(periods); i++ {
-		grouped[statuses[i]] = append(grouped[statuses[i]], periods[i])
+		period := models.Period{
+			From: formatTime(periods[i].From, twelveHour),
+			To:   formatTime(periods[i].To, twelveHour),
+		}
+		grouped[statuses[i]] = append(grouped[statuses[i]], period)
 	}
 
 	msg := groupMessage{
@@ -56,3 +126,20 @@ func renderGroup(num string, periods []models.Period, statuses []models.Status)
 	err := groupMessageTemplate.Execute(&buf, msg)
 	return buf.String(), err
 }
+
+// formatTime converts "HH:MM" time of the schedule to the format chosen by the subscriber.
+// Unknown values are returned as is
+func formatTime(value string, twelveHour bool) string {
+	if !twelveHour {
+		return value
+	}
+	if value == "24:00" {
+		// end of the day
+		return "12:00 AM"
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return value
+	}
+	return fmt.Sprintf("%d:%02d %s", (t.Hour()+11)%12+1, t.Minute(), t.Format("PM"))
+}
@@ -0,0 +1,109 @@
+package subscription
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		value      string
+		twelveHour bool
+		want       string
+	}{
+		{value: "21:30", twelveHour: false, want: "21:30"},
+		{value: "00:00", twelveHour: true, want: "12:00 AM"},
+		{value: "09:30", twelveHour: true, want: "9:30 AM"},
+		{value: "12:00", twelveHour: true, want: "12:00 PM"},
+		{value: "21:30", twelveHour: true, want: "9:30 PM"},
+		{value: "24:00", twelveHour: true, want: "12:00 AM"},
+		{value: "unknown", twelveHour: true, want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := formatTime(tt.value, tt.twelveHour); got != tt.want {
+				t.Errorf("formatTime(%q, %t) = %q, want %q", tt.value, tt.twelveHour, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMessage_Footer(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:      "15 січня",
+		UpdatedAt: time.Date(2024, 1, 15, 12, 45, 0, 0, kyivTime),
+		Source:    "https://oblenergo.cv.ua/shutdowns/",
+	}
+
+	got, err := renderMessage(table, []string{"Група 1"}, false, time.Time{})
+	if err != nil {
+		t.Fatalf("renderMessage() error = %v", err)
+	}
+	if want := "Оновлено: 12:45 • https://oblenergo.cv.ua/shutdowns/"; !strings.Contains(got, want) {
+		t.Errorf("renderMessage() = %q, want it to contain %q", got, want)
+	}
+
+	got, err = renderMessage(table, []string{"Група 1"}, true, time.Time{})
+	if err != nil {
+		t.Fatalf("renderMessage() error = %v", err)
+	}
+	if want := "Оновлено: 12:45 PM"; !strings.Contains(got, want) {
+		t.Errorf("renderMessage() = %q, want it to contain %q", got, want)
+	}
+
+	table.UpdatedAt = time.Time{}
+	got, err = renderMessage(table, []string{"Група 1"}, false, time.Time{})
+	if err != nil {
+		t.Fatalf("renderMessage() error = %v", err)
+	}
+	if strings.Contains(got, "Оновлено") {
+		t.Errorf("renderMessage() = %q, want no footer for unknown fetch time", got)
+	}
+}
+
+func TestRelativeDay(t *testing.T) {
+	tests := []struct {
+		name string
+		date string
+		now  time.Time
+		want string
+	}{
+		{name: "today", date: "15 січня", now: time.Date(2024, 1, 15, 10, 0, 0, 0, kyivTime), want: "сьогодні"},
+		{name: "tomorrow", date: "16 січня", now: time.Date(2024, 1, 15, 23, 30, 0, 0, kyivTime), want: "завтра"},
+		{name: "tomorrow across year", date: "1 січня", now: time.Date(2023, 12, 31, 20, 0, 0, 0, kyivTime), want: "завтра"},
+		{name: "kyiv midnight in utc", date: "16 січня", now: time.Date(2024, 1, 15, 22, 30, 0, 0, time.UTC), want: "сьогодні"},
+		{name: "yesterday", date: "14 січня", now: time.Date(2024, 1, 15, 10, 0, 0, 0, kyivTime)},
+		{name: "unparseable", date: "завтра", now: time.Date(2024, 1, 15, 10, 0, 0, 0, kyivTime)},
+		{name: "zero now", date: "15 січня"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relativeDay(tt.date, tt.now); got != tt.want {
+				t.Errorf("relativeDay() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMessage_RelativeDay(t *testing.T) {
+	table := models.ShutdownsTable{Date: "15 січня"}
+
+	got, err := renderMessage(table, []string{"Група 1"}, false, time.Date(2024, 1, 15, 10, 0, 0, 0, kyivTime))
+	if err != nil {
+		t.Fatalf("renderMessage() error = %v", err)
+	}
+	if want := "на сьогодні, 15 січня:"; !strings.Contains(got, want) {
+		t.Errorf("renderMessage() = %q, want it to contain %q", got, want)
+	}
+
+	got, err = renderMessage(table, []string{"Група 1"}, false, time.Date(2024, 1, 20, 10, 0, 0, 0, kyivTime))
+	if err != nil {
+		t.Fatalf("renderMessage() error = %v", err)
+	}
+	if want := "на 15 січня:"; !strings.Contains(got, want) {
+		t.Errorf("renderMessage() = %q, want it to contain %q", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+package subscription
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
+)
+
+// sentUpdate is a schedule update as the chat sees it
+type sentUpdate struct {
+	at     string
+	chatID int64
+	silent bool
+}
+
+type recordingSender struct {
+	clock    *clock.Mock
+	sent     []sentUpdate
+	texts    map[int64]string
+	messages int
+	deleted  map[int64]int
+}
+
+func (s *recordingSender) Send(chatID int64, text string, silent bool) (int, error) {
+	s.sent = append(s.sent, sentUpdate{at: s.clock.Now().In(kyivTime).Format("02 15:04"), chatID: chatID, silent: silent})
+	s.texts[chatID] = text
+	s.messages++
+	return s.messages, nil
+}
+
+func (s *recordingSender) SendUpdate(chatID int64, text string, groups []string, silent bool) (int, error) {
+	return s.Send(chatID, text, silent)
+}
+
+func (s *recordingSender) SendChooseGroup(chatID int64, text string, silent bool) (int, error) {
+	return s.Send(chatID, text, silent)
+}
+
+func (s *recordingSender) Delete(chatID int64, _ int) error {
+	s.deleted[chatID]++
+	return nil
+}
+
+// TestSimulation_TwoDays runs schedule updates every 5 minutes for 48 hours while the schedule changes, and checks
+// every update the chats get, so interactions of mute, quiet hours, silent setting and hidden periods are covered
+func TestSimulation_TwoDays(t *testing.T) {
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, kyivTime)
+	clk := clock.NewMock(start)
+	periods := []models.Period{
+		{From: "00:00", To: "06:00"}, {From: "06:00", To: "12:00"},
+		{From: "12:00", To: "18:00"}, {From: "18:00", To: "24:00"},
+	}
+	table := func(date string, group1, group2 []models.Status) models.ShutdownsTable {
+		return models.ShutdownsTable{
+			Date:    date,
+			Periods: periods,
+			Groups: map[string]models.ShutdownGroup{
+				"1": {Number: 1, Items: group1},
+				"2": {Number: 2, Items: group2},
+			},
+		}
+	}
+	const on, off = models.ON, models.OFF
+
+	// schedule changes keyed by Kyiv "day hour:minute" they are published at
+	changes := map[string]models.ShutdownsTable{
+		"15 00:00": table("15 січня", []models.Status{on, off, on, off}, []models.Status{off, on, on, on}),
+		// upcoming period changes
+		"15 13:00": table("15 січня", []models.Status{on, off, on, on}, []models.Status{off, on, on, on}),
+		// already hidden period changes, so the text stays the same
+		"15 19:00": table("15 січня", []models.Status{off, off, on, on}, []models.Status{off, on, on, on}),
+		// the next day schedule is published before the notification window
+		"16 07:00": table("16 січня", []models.Status{off, on, on, on}, []models.Status{off, on, on, on}),
+		"16 12:30": table("16 січня", []models.Status{off, on, off, on}, []models.Status{off, on, on, on}),
+		"16 21:00": table("16 січня", []models.Status{off, on, off, on}, []models.Status{off, on, on, off}),
+	}
+
+	repo := newMemoryRepo(
+		models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}},
+		models.Subscription{
+			ChatID: 2, Groups: map[string]string{"2": ""},
+			Settings: models.Settings{AlwaysSilent: true, KeepHistory: true},
+		},
+		models.Subscription{ChatID: 3, Groups: map[string]string{"1": ""}, MutedUntil: start.Add(10 * time.Hour)},
+	)
+	shutdowns := &fakeShutdowns{}
+	sender := &recordingSender{clock: clk, texts: make(map[int64]string), deleted: make(map[int64]int)}
+	s := NewSubscriptionService(
+		repo, newMemoryTombstones(), &memoryPurgeLog{}, &memoryDeliveryLog{}, shutdowns, noSuspensions{}, sender,
+		DefaultOptions(), clk,
+	)
+
+	const step = 5 * time.Minute
+	for i := 0; i <= int(48*time.Hour/step); i++ {
+		if change, ok := changes[clk.Now().In(kyivTime).Format("02 15:04")]; ok {
+			shutdowns.table = change
+		}
+		if _, err := s.SendUpdates(context.Background()); err != nil {
+			t.Fatalf("SendUpdates() at %s error = %v", clk.Now(), err)
+		}
+		clk.Advance(step)
+	}
+
+	want := []sentUpdate{
+		{at: "15 00:00", chatID: 1, silent: true},
+		{at: "15 00:00", chatID: 2, silent: true},
+		{at: "15 10:00", chatID: 3, silent: false},
+		{at: "15 13:00", chatID: 1, silent: false},
+		{at: "15 13:00", chatID: 3, silent: false},
+		{at: "16 07:00", chatID: 1, silent: true},
+		{at: "16 07:00", chatID: 2, silent: true},
+		{at: "16 07:00", chatID: 3, silent: true},
+		{at: "16 12:30", chatID: 1, silent: false},
+		{at: "16 12:30", chatID: 3, silent: false},
+		{at: "16 21:00", chatID: 2, silent: true},
+	}
+	got := slices.Clone(sender.sent)
+	sort.SliceStable(got, func(i, j int) bool {
+		if got[i].at != got[j].at {
+			return got[i].at < got[j].at
+		}
+		return got[i].chatID < got[j].chatID
+	})
+	if !reflect.DeepEqual(got, want) {
+		var buf strings.Builder
+		for _, u := range got {
+			fmt.Fprintf(&buf, "\n  %+v", u)
+		}
+		t.Errorf("sent updates:%s\nwant %+v", buf.String(), want)
+	}
+
+	// superseded messages are deleted unless history is kept
+	if want := map[int64]int{1: 3, 3: 3}; !reflect.DeepEqual(sender.deleted, want) {
+		t.Errorf("deleted = %v, want %v", sender.deleted, want)
+	}
+	for chatID, text := range sender.texts {
+		if !strings.Contains(text, "16 січня") {
+			t.Errorf("last update of chatID=%d = %q, want the next day schedule", chatID, text)
+		}
+	}
+}
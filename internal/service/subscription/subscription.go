@@ -1,24 +1,87 @@
 package subscription
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
 )
 
 const GroupsCount = 18
 const subscriptionsLimit = 1000
 
+const probeInterval = 48 * time.Hour
+const maxProbeFailures = 3
+
+const defaultNotificationWindowStart = 8
+const defaultNotificationWindowEnd = 21
+
+// NotificationWindow is [StartHour, EndHour) Kyiv time range. Updates sent outside of it are delivered without sound
+type NotificationWindow struct {
+	StartHour int
+	EndHour   int
+}
+
+func (w NotificationWindow) Validate() error {
+	if w.StartHour < 0 || w.StartHour > 23 || w.EndHour < 1 || w.EndHour > 24 || w.StartHour >= w.EndHour {
+		return fmt.Errorf("invalid notification window [%d, %d)", w.StartHour, w.EndHour)
+	}
+	return nil
+}
+
+// Contains returns true if t is within the window in Kyiv time
+func (w NotificationWindow) Contains(t time.Time) bool {
+	hour := t.In(kyivTime).Hour()
+	return hour >= w.StartHour && hour < w.EndHour
+}
+
+func DefaultNotificationWindow() NotificationWindow {
+	return NotificationWindow{
+		StartHour: defaultNotificationWindowStart,
+		EndHour:   defaultNotificationWindowEnd,
+	}
+}
+
+type Options struct {
+	NotificationWindow NotificationWindow
+	// TombstoneRetention is how long configuration of unsubscribed chat is kept. Zero disables tombstones
+	TombstoneRetention time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		NotificationWindow: DefaultNotificationWindow(),
+		TombstoneRetention: defaultTombstoneRetention,
+	}
+}
+
 type MessageSender interface {
-	Send(chatID int64, text string) error
+	Send(chatID int64, text string, silent bool) (int, error)
+	// SendUpdate sends schedule update with buttons to manage the subscription groups the update is about
+	SendUpdate(chatID int64, text string, groups []string, silent bool) (int, error)
+	// SendChooseGroup sends message with a button to choose other group
+	SendChooseGroup(chatID int64, text string, silent bool) (int, error)
+	Delete(chatID int64, messageID int) error
 }
 
 type ShutdownsService interface {
 	GetShutdownsTable() (models.ShutdownsTable, bool, error)
-	RefreshShutdownsTable()
+	RefreshShutdownsTable() error
+}
+
+// GroupSuspensions tells whether updates of the group are suspended by admin
+type GroupSuspensions interface {
+	IsSuspended(groupNum string) bool
 }
 
 type Repository interface {
@@ -28,14 +91,50 @@ type Repository interface {
 	GetAll() ([]models.Subscription, error)
 	Put(sub models.Subscription) (models.Subscription, error)
 	Purge(chatID int64) error
+	Migrate(from, to int64) error
+	UpdateAll(fn func(sub *models.Subscription) bool) (int, error)
 }
 
 type Service struct {
 	repo             Repository
+	tombstones       TombstoneRepository
+	purgeLog         PurgeLogRepository
+	deliveryLog      DeliveryLogRepository
 	shutdownsService ShutdownsService
+	suspensions      GroupSuspensions
 	sender           MessageSender
+	clock            clock.Clock
+
+	optionsMx sync.RWMutex
+	options   Options
 
 	sendUpdatesMx sync.Mutex
+	// lastOutcomes are outcomes of the previous SendUpdates run guarded by sendUpdatesMx
+	lastOutcomes map[int64]models.DeliveryLogEntry
+
+	groupSchedulesMx sync.Mutex
+	groupSchedules   map[string]groupSchedule
+}
+
+// groupSchedule is a rendered full day schedule of a group cached by the group hash and the table fetch time.
+// Cache key is the group number with the time format
+type groupSchedule struct {
+	hash      string
+	updatedAt time.Time
+	msg       string
+}
+
+// SetOptions replaces service options, e.g. on configuration reload
+func (s *Service) SetOptions(options Options) {
+	s.optionsMx.Lock()
+	defer s.optionsMx.Unlock()
+	s.options = options
+}
+
+func (s *Service) currentOptions() Options {
+	s.optionsMx.RLock()
+	defer s.optionsMx.RUnlock()
+	return s.options
 }
 
 func (s *Service) GroupsCount() int {
@@ -58,28 +157,230 @@ func (s *Service) GetSubscriptions() ([]models.Subscription, error) {
 	return subs, nil
 }
 
-func (s *Service) SubscribeToGroup(chatID int64, groupNum string) (models.Subscription, error) {
-	size, err := s.repo.Size()
+func (s *Service) GetSubscription(chatID int64) (models.Subscription, bool, error) {
+	sub, exists, err := s.repo.Get(chatID)
+	if err != nil {
+		return models.Subscription{}, false, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	return sub, exists, nil
+}
+
+func (s *Service) ToggleSetting(chatID int64, setting models.Setting) (models.Subscription, error) {
+	return s.update(chatID, func(sub *models.Subscription) error {
+		if err := sub.Settings.Toggle(setting); err != nil {
+			return fmt.Errorf("failed to toggle setting: %w", err)
+		}
+		return nil
+	})
+}
+
+// Mute stops schedule updates and queued notifications for the chat until the given time
+func (s *Service) Mute(chatID int64, until time.Time) (models.Subscription, error) {
+	return s.update(chatID, func(sub *models.Subscription) error {
+		sub.MutedUntil = until
+		return nil
+	})
+}
+
+// MuteGroup stops updates of the subscribed group until the time. Updates of other groups are still sent
+func (s *Service) MuteGroup(chatID int64, groupNum string, until time.Time) (models.Subscription, error) {
+	return s.update(chatID, func(sub *models.Subscription) error {
+		if _, ok := sub.Groups[groupNum]; !ok {
+			return fmt.Errorf("%w: %s", models.ErrInvalidGroup, groupNum)
+		}
+		now := s.clock.Now()
+		if sub.MutedGroups == nil {
+			sub.MutedGroups = make(map[string]time.Time)
+		}
+		for num := range sub.MutedGroups {
+			if !sub.IsGroupMuted(num, now) {
+				delete(sub.MutedGroups, num)
+			}
+		}
+		sub.MutedGroups[groupNum] = until
+		return nil
+	})
+}
+
+// Unmute resumes updates of the chat and of all its groups
+func (s *Service) Unmute(chatID int64) (models.Subscription, error) {
+	return s.update(chatID, func(sub *models.Subscription) error {
+		sub.MutedUntil = time.Time{}
+		sub.MutedGroups = nil
+		return nil
+	})
+}
+
+// Disable marks subscription of the chat the bot can't send messages to anymore. Disabled subscription keeps its
+// groups and settings and is purged only after repeated failed probes
+func (s *Service) Disable(chatID int64) error {
+	sub, exists, err := s.repo.Get(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	if !sub.IsDisabled() {
+		sub.DisabledAt = s.clock.Now()
+		s.logPurge(chatID, models.PurgeActionDisabled)
+	} else if !sub.ProbedAt.IsZero() {
+		sub.ProbeFailures++
+	}
+	if sub.ProbeFailures >= maxProbeFailures {
+		slog.Debug("purging disabled subscription", "chatID", chatID, "disabledAt", sub.DisabledAt)
+		if err = s.repo.Purge(chatID); err != nil {
+			return fmt.Errorf("failed to purge subscription: %w", err)
+		}
+		s.logPurge(chatID, models.PurgeActionPurged)
+		return nil
+	}
+
+	if _, err = s.repo.Put(sub); err != nil {
+		return fmt.Errorf("failed to put subscription: %w", err)
+	}
+	return nil
+}
+
+// Migrate moves subscription of the group chat upgraded to a supergroup to the new chat ID, so updates are delivered
+// to the new chat with the next run
+func (s *Service) Migrate(from, to int64) error {
+	if err := s.repo.Migrate(from, to); err != nil {
+		return fmt.Errorf("failed to migrate subscription: %w", err)
+	}
+	slog.Info("subscription migrated to supergroup", "from", from, "to", to)
+	return nil
+}
+
+// Enable restores disabled subscription, e.g. when user comes back with /start
+func (s *Service) Enable(chatID int64) (models.Subscription, error) {
+	return s.update(chatID, func(sub *models.Subscription) error {
+		enable(sub)
+		return nil
+	})
+}
+
+func enable(sub *models.Subscription) {
+	sub.DisabledAt = time.Time{}
+	sub.ProbedAt = time.Time{}
+	sub.ProbeFailures = 0
+}
+
+func (s *Service) CompleteOnboarding(chatID int64) (models.Subscription, error) {
+	return s.update(chatID, func(sub *models.Subscription) error {
+		sub.OnboardingCompleted = true
+		return nil
+	})
+}
+
+// update applies fn to existing subscription and stores the result
+func (s *Service) update(chatID int64, fn func(sub *models.Subscription) error) (models.Subscription, error) {
+	return retryOnConflict(func() (models.Subscription, error) {
+		return s.tryUpdate(chatID, fn)
+	})
+}
+
+// retryOnConflict calls fn once more if the subscription was changed concurrently, e.g. by another callback or
+// the scheduler, so fn is applied to the fresh subscription instead of overwriting the change
+func retryOnConflict(fn func() (models.Subscription, error)) (models.Subscription, error) {
+	sub, err := fn()
+	if errors.Is(err, models.ErrConflict) {
+		slog.Debug("retrying conflicting subscription update", "error", err)
+		return fn()
+	}
+	return sub, err
+}
+
+func (s *Service) tryUpdate(chatID int64, fn func(sub *models.Subscription) error) (models.Subscription, error) {
+	sub, exists, err := s.repo.Get(chatID)
+	if err != nil {
+		return models.Subscription{}, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if !exists {
+		return models.Subscription{}, models.ErrSubscriptionNotFound
+	}
+
+	if err = fn(&sub); err != nil {
+		return models.Subscription{}, err
+	}
+	sub, err = s.repo.Put(sub)
 	if err != nil {
-		return models.Subscription{}, fmt.Errorf("failed to get number of subscribers: %w", err)
+		return models.Subscription{}, fmt.Errorf("failed to put subscription: %w", err)
 	}
 
+	return sub, nil
+}
+
+func (s *Service) SubscribeToGroup(
+	chatID int64, chatType models.ChatType, groupNum, source string,
+) (models.Subscription, error) {
+	return s.SetGroups(chatID, chatType, []string{groupNum}, source)
+}
+
+// SetGroups replaces subscription groups with the given ones creating subscription if it doesn't exist.
+// Empty groups list purges the subscription. Groups that stay subscribed keep their hashes,
+// so they are not sent again unless the schedule changes. Source is recorded only when subscription is created
+func (s *Service) SetGroups(
+	chatID int64, chatType models.ChatType, groups []string, source string,
+) (models.Subscription, error) {
+	for _, groupNum := range groups {
+		num, err := strconv.Atoi(groupNum)
+		if err != nil || num < 1 || num > GroupsCount {
+			return models.Subscription{}, fmt.Errorf("%w: %s", models.ErrInvalidGroup, groupNum)
+		}
+	}
+
+	if len(groups) == 0 {
+		return models.Subscription{}, s.Unsubscribe(chatID)
+	}
+
+	return retryOnConflict(func() (models.Subscription, error) {
+		return s.setGroups(chatID, chatType, groups, source)
+	})
+}
+
+func (s *Service) setGroups(
+	chatID int64, chatType models.ChatType, groups []string, source string,
+) (models.Subscription, error) {
 	sub, exists, err := s.repo.Get(chatID)
 	if err != nil {
 		return models.Subscription{}, fmt.Errorf("failed to get subscription: %w", err)
 	}
 
 	if !exists {
+		size, err := s.repo.Size()
+		if err != nil {
+			return models.Subscription{}, fmt.Errorf("failed to get number of subscribers: %w", err)
+		}
 		if size >= subscriptionsLimit {
 			return models.Subscription{}, models.ErrSubscriptionsLimitReached
 		}
+		if source == "" {
+			source = models.SourceOrganic
+		}
 		sub = models.Subscription{
-			ChatID: chatID,
+			ChatID:    chatID,
+			Source:    source,
+			CreatedAt: s.clock.Now(),
+		}
+		// user picked groups from scratch instead of restoring previous configuration
+		if err = s.tombstones.Delete(chatID); err != nil {
+			slog.Error("failed to delete tombstone", "error", err, "chatID", chatID)
 		}
 	}
+	sub.ChatType = chatType
 
-	sub.Groups = map[string]string{
-		groupNum: "",
+	newGroups := make(map[string]string, len(groups))
+	for _, groupNum := range groups {
+		newGroups[groupNum] = sub.Groups[groupNum]
+	}
+	sub.Groups = newGroups
+	if !exists {
+		// new subscriber gets the current schedule with onboarding, so updates start from the next change
+		if err = s.backfillHashes(&sub); err != nil {
+			return models.Subscription{}, err
+		}
 	}
 	sub, err = s.repo.Put(sub)
 	if err != nil {
@@ -93,22 +394,226 @@ func (s *Service) SubscribeToGroup(chatID int64, groupNum string) (models.Subscr
 	return sub, nil
 }
 
+const statsDays = 14
+
+// Stats returns number of subscriptions per acquisition source and new subscriptions per day for the last 14 days
+func (s *Service) Stats() (models.SubscriptionStats, error) {
+	subs, err := s.repo.GetAll()
+	if err != nil {
+		return models.SubscriptionStats{}, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	const dateFormat = "2006-01-02"
+	today := s.clock.Now().In(kyivTime)
+	byDay := make([]models.DayCount, statsDays)
+	dayIndex := make(map[string]int, statsDays)
+	for i := 0; i < statsDays; i++ {
+		date := today.AddDate(0, 0, i-statsDays+1).Format(dateFormat)
+		byDay[i] = models.DayCount{Date: date}
+		dayIndex[date] = i
+	}
+
+	res := models.SubscriptionStats{
+		Total:    len(subs),
+		BySource: make(map[string]int),
+		ByDay:    byDay,
+	}
+	for _, sub := range subs {
+		source := sub.Source
+		if source == "" {
+			source = models.SourceOrganic
+		}
+		res.BySource[source]++
+
+		if sub.CreatedAt.IsZero() {
+			continue
+		}
+		if i, ok := dayIndex[sub.CreatedAt.In(kyivTime).Format(dateFormat)]; ok {
+			res.ByDay[i].Count++
+		}
+	}
+
+	return res, nil
+}
+
+// GroupSchedule returns rendered full day schedule of the group. The second return value is false if there is no
+// schedule for the group available
+func (s *Service) GroupSchedule(groupNum string, twelveHour bool) (string, bool, error) {
+	table, ok, err := s.shutdownsService.GetShutdownsTable()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get shutdowns table: %w", err)
+	}
+	if !ok {
+		return "", false, nil
+	}
+	group, ok := table.Groups[groupNum]
+	if !ok {
+		return "", false, nil
+	}
+
+	hash := group.Hash(fmt.Sprintf("%s:", table.Date))
+
+	s.groupSchedulesMx.Lock()
+	defer s.groupSchedulesMx.Unlock()
+	now := s.clock.Now()
+	key := fmt.Sprintf("%s:%t:%s", groupNum, twelveHour, relativeDay(table.Date, now))
+	if cached, ok := s.groupSchedules[key]; ok && cached.hash == hash && cached.updatedAt.Equal(table.UpdatedAt) {
+		return cached.msg, true, nil
+	}
+
+	settings := models.Settings{ShowFullDay: true, TwelveHourTime: twelveHour}
+	msg, rendered, err := renderGroupsSchedule(table, []string{groupNum}, settings, now)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to render group schedule: %w", err)
+	}
+	if rendered == 0 {
+		return "", false, fmt.Errorf("failed to render group=%s schedule: %w", groupNum, errMalformedGroup)
+	}
+	s.groupSchedules[key] = groupSchedule{hash: hash, updatedAt: table.UpdatedAt, msg: msg}
+
+	return msg, true, nil
+}
+
+// backfillHashes sets current hashes of the subscription groups which are available in the shutdowns table
+func (s *Service) backfillHashes(sub *models.Subscription) error {
+	table, ok, err := s.shutdownsService.GetShutdownsTable()
+	if err != nil {
+		return fmt.Errorf("failed to get shutdowns table: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	for groupNum := range sub.Groups {
+		if group, ok := table.Groups[groupNum]; ok {
+			sub.Groups[groupNum] = group.Hash(fmt.Sprintf("%s:", table.Date))
+		}
+	}
+	return nil
+}
+
+// UnsubscribeFromGroup removes the group from the subscription. Subscription without groups left is unsubscribed, so
+// zero subscription is returned
+func (s *Service) UnsubscribeFromGroup(chatID int64, groupNum string) (models.Subscription, error) {
+	sub, exists, err := s.repo.Get(chatID)
+	if err != nil {
+		return models.Subscription{}, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if !exists {
+		return models.Subscription{}, models.ErrSubscriptionNotFound
+	}
+	if _, ok := sub.Groups[groupNum]; !ok {
+		return models.Subscription{}, fmt.Errorf("%w: %s", models.ErrInvalidGroup, groupNum)
+	}
+	if len(sub.Groups) == 1 {
+		return models.Subscription{}, s.Unsubscribe(chatID)
+	}
+
+	return s.update(chatID, func(sub *models.Subscription) error {
+		delete(sub.Groups, groupNum)
+		delete(sub.MutedGroups, groupNum)
+		return nil
+	})
+}
+
+// Unsubscribe purges subscription keeping its groups and settings in a tombstone for TombstoneRetention
 func (s *Service) Unsubscribe(chatID int64) error {
-	return s.repo.Purge(chatID)
+	sub, exists, err := s.repo.Get(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if exists {
+		s.bury(sub)
+	}
+
+	if err = s.repo.Purge(chatID); err != nil {
+		return fmt.Errorf("failed to purge subscription: %w", err)
+	}
+	return nil
+}
+
+// RenderSchedule renders the current schedule of all groups of the subscription the way updates are rendered for it.
+// Subscription state is not changed
+func (s *Service) RenderSchedule(chatID int64) (string, error) {
+	sub, exists, err := s.repo.Get(chatID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if !exists {
+		return "", models.ErrSubscriptionNotFound
+	}
+
+	groupNums := make([]string, 0, len(sub.Groups))
+	for groupNum := range sub.Groups {
+		groupNums = append(groupNums, groupNum)
+	}
+	msg, err := s.RenderGroupsSchedule(groupNums, sub.Settings)
+	if err != nil {
+		return "", fmt.Errorf("failed to render schedule of chatID=%d: %w", chatID, err)
+	}
+	return msg, nil
+}
+
+// RenderGroupsSchedule renders the current schedule of the given groups with the given settings regardless of
+// any subscription. Groups missing in the table and malformed groups are skipped
+func (s *Service) RenderGroupsSchedule(groupNums []string, settings models.Settings) (string, error) {
+	table, ok, err := s.shutdownsService.GetShutdownsTable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get shutdowns table: %w", err)
+	}
+	if !ok {
+		return "", fmt.Errorf("shutdowns table is not available")
+	}
+
+	msg, _, err := renderGroupsSchedule(table, groupNums, settings, s.clock.Now())
+	return msg, err
+}
+
+// renderGroupsSchedule is the shared renderer of a schedule of the given groups, used by all on-demand and published
+// schedules. The second return value is the number of rendered groups, missing and malformed groups are skipped
+func renderGroupsSchedule(
+	table models.ShutdownsTable, groupNums []string, settings models.Settings, now time.Time,
+) (string, int, error) {
+	groupNums = slices.Clone(groupNums)
+	sort.Strings(groupNums)
+
+	msgs := make([]string, 0, len(groupNums))
+	for _, groupNum := range groupNums {
+		group, ok := table.Groups[groupNum]
+		if !ok {
+			continue
+		}
+		msg, err := renderSettingsGroup(settings, table.Periods, groupNum, group, now)
+		if errors.Is(err, errMalformedGroup) {
+			slog.Warn("skipping malformed group", "error", err, "group", groupNum)
+			continue
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to render group=%s message: %w", groupNum, err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	msg, err := renderMessage(table, msgs, settings.TwelveHourTime, now)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to render message: %w", err)
+	}
+	return msg, len(msgs), nil
 }
 
-func (s *Service) SendUpdates() {
+// SendUpdates sends changed schedule to subscribers and reports deliveries. It stops between chats once ctx is done,
+// chats left are processed with the next run as their hashes are not updated
+func (s *Service) SendUpdates(ctx context.Context) (models.RunReport, error) {
 	s.sendUpdatesMx.Lock()
 	defer s.sendUpdatesMx.Unlock()
 
 	table, ok, err := s.shutdownsService.GetShutdownsTable()
 	if err != nil {
-		slog.Error("failed to get shutdowns table", "error", err)
-		return
+		return models.RunReport{}, fmt.Errorf("failed to get shutdowns table: %w", err)
 	}
 	if !ok {
 		// table is not ready yet
-		return
+		return models.RunReport{}, nil
 	}
 	grouped := make(map[string]models.ShutdownGroup)
 	for k, v := range table.Groups {
@@ -117,63 +622,355 @@ func (s *Service) SendUpdates() {
 
 	subs, err := s.repo.GetAll()
 	if err != nil {
-		slog.Error("failed to get subscriptions", "error", err)
-		return
+		return models.RunReport{}, fmt.Errorf("failed to get subscriptions: %w", err)
 	}
 
-	for _, sub := range subs {
-		s.processSubscription(sub, table, grouped)
+	var report models.RunReport
+	now := s.clock.Now()
+	outcomes := make(map[int64]models.DeliveryLogEntry, len(subs))
+	defer s.logDeliveries(outcomes)
+	for i, sub := range subs {
+		if ctx.Err() != nil {
+			slog.Warn("sending updates interrupted", "left", len(subs)-i)
+			return report, ctx.Err()
+		}
+
+		var delivery models.Delivery
+		var reason models.DeliveryReason
+		switch {
+		case sub.IsMuted(now):
+			// hashes are not updated, so the latest schedule is sent once mute is over
+			delivery, reason = models.DeliverySkipped, models.ReasonMuted
+		case sub.IsDisabled():
+			delivery, reason = s.probe(sub, table, grouped, now)
+		default:
+			delivery, reason = s.processSubscription(sub, table, grouped, now)
+		}
+		report.Record(delivery)
+		outcomes[sub.ChatID] = models.DeliveryLogEntry{At: now, Delivery: delivery, Reason: reason}
+	}
+
+	return report, nil
+}
+
+// ResyncState marks the stored schedule as already sent to every subscription without sending anything, e.g. after
+// restoring a backup, so the next updates are sent only for further changes. It returns the number of rewritten
+// subscriptions
+func (s *Service) ResyncState() (int, error) {
+	// running updates would otherwise send the schedule which is being marked as sent
+	s.sendUpdatesMx.Lock()
+	defer s.sendUpdatesMx.Unlock()
+
+	table, ok, err := s.shutdownsService.GetShutdownsTable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get shutdowns table: %w", err)
+	}
+	if !ok {
+		return 0, fmt.Errorf("shutdowns table is not available")
+	}
+
+	n, err := s.repo.UpdateAll(func(sub *models.Subscription) bool {
+		changed := false
+		for groupNum, hash := range sub.Groups {
+			group, ok := table.Groups[groupNum]
+			if !ok {
+				continue
+			}
+			if newHash := group.Hash(fmt.Sprintf("%s:", table.Date)); newHash != hash {
+				sub.Groups[groupNum] = newHash
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update subscriptions: %w", err)
+	}
+	slog.Info("subscriptions state resynced", "count", n)
+	return n, nil
+}
+
+// logDeliveries stores outcomes of the run which changed since the previous run, so runs without changes don't
+// write anything. Failure doesn't affect sending, so it is only logged
+func (s *Service) logDeliveries(outcomes map[int64]models.DeliveryLogEntry) {
+	changed := make(map[int64]models.DeliveryLogEntry)
+	for chatID, outcome := range outcomes {
+		if last, ok := s.lastOutcomes[chatID]; !ok || !last.SameOutcome(outcome) {
+			changed[chatID] = outcome
+		}
+	}
+	if len(changed) > 0 {
+		if err := s.deliveryLog.Add(changed); err != nil {
+			slog.Error("failed to add delivery log entries", "error", err, "count", len(changed))
+			return
+		}
+	}
+	s.lastOutcomes = outcomes
+}
+
+// DeliveryLog returns latest schedule updates outcomes of the chat starting from the current one
+func (s *Service) DeliveryLog(chatID int64) ([]models.DeliveryLogEntry, error) {
+	res, err := s.deliveryLog.Get(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery log: %w", err)
 	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// probe resends the whole schedule to the disabled subscription once per probeInterval. Successful send enables the
+// subscription back, while failed one is reported by the sender and counted by Disable
+func (s *Service) probe(
+	sub models.Subscription, table models.ShutdownsTable, grouped map[string]models.ShutdownGroup, now time.Time,
+) (models.Delivery, models.DeliveryReason) {
+
+	last := sub.DisabledAt
+	if sub.ProbedAt.After(last) {
+		last = sub.ProbedAt
+	}
+	if now.Sub(last) < probeInterval {
+		return models.DeliverySkipped, models.ReasonAwaitingProbe
+	}
+
+	sub.ProbedAt = now
+	for groupNum := range sub.Groups {
+		sub.Groups[groupNum] = ""
+	}
+	sub.LastMessageHash = ""
+	sub, err := s.repo.Put(sub)
+	if err != nil {
+		slog.Error("failed to update subscription", "error", err, "chatID", sub.ChatID)
+		return models.DeliveryFailed, models.ReasonStoreFailed
+	}
+	return s.processSubscription(sub, table, grouped, now)
 }
 
 func (s *Service) processSubscription(
-	sub models.Subscription, table models.ShutdownsTable, grouped map[string]models.ShutdownGroup) {
+	sub models.Subscription, table models.ShutdownsTable, grouped map[string]models.ShutdownGroup, now time.Time,
+) (models.Delivery, models.DeliveryReason) {
+
+	if len(grouped) > 0 && len(sub.Groups) > 0 && !hasAnyGroup(sub.Groups, grouped) {
+		return s.warnGroupsLost(sub, now)
+	}
+	if !sub.GroupsLostAt.IsZero() {
+		// groups are back, so the warning is sent again if they disappear once more. It is stored right away, as
+		// the groups may come back unchanged and nothing else would be stored
+		sub.GroupsLostAt = time.Time{}
+		stored, err := s.storeDelivery(sub)
+		if err != nil {
+			slog.Error("failed to update subscription", "error", err, "chatID", sub.ChatID)
+			return models.DeliveryFailed, models.ReasonStoreFailed
+		}
+		sub = stored
+	}
 
 	msgs := make([]string, 0)
+	sentGroups := make([]string, 0)
+	var suspended, malformed, muted bool
 
 	chatID := sub.ChatID
 	slogChatID := slog.Int64("chatID", chatID)
-	for groupNum, hash := range sub.Groups {
+	groupNums := make([]string, 0, len(sub.Groups))
+	for groupNum := range sub.Groups {
+		groupNums = append(groupNums, groupNum)
+	}
+	sort.Strings(groupNums)
+	for _, groupNum := range groupNums {
+		if s.suspensions.IsSuspended(groupNum) {
+			// hash is not updated, so the latest schedule is sent once suspension is lifted
+			suspended = true
+			continue
+		}
+		if sub.IsGroupMuted(groupNum, now) {
+			// hash is not updated, so the latest schedule is sent once mute is over
+			muted = true
+			continue
+		}
+		hash := sub.Groups[groupNum]
 		// Hack to make sure updates for new day will be sent even if there is no changes in schedule
 		newHash := grouped[groupNum].Hash(fmt.Sprintf("%s:", table.Date))
 		if hash == newHash {
 			continue
 		}
 
-		gropuedPeriod, groupedStatuses := join(table.Periods, grouped[groupNum].Items)
-		cutPeriod, cutStatuses := cutByKyivTime(gropuedPeriod, groupedStatuses)
-		msg, err := renderGroup(groupNum, cutPeriod, cutStatuses)
+		msg, err := renderSettingsGroup(sub.Settings, table.Periods, groupNum, grouped[groupNum], now)
+		if errors.Is(err, errMalformedGroup) {
+			// hash is not updated, so the group is sent once the provider fixes it
+			slog.Warn("skipping malformed group", "error", err, slogChatID, "group", groupNum)
+			malformed = true
+			continue
+		}
 		if err != nil {
 			slog.Error("failed to render group message", "error", err, slogChatID, "group", groupNum)
-			return
+			return models.DeliveryFailed, models.ReasonRenderFailed
 		}
 		msgs = append(msgs, msg)
+		sentGroups = append(sentGroups, groupNum)
 		sub.Groups[groupNum] = newHash
 	}
 
 	if len(msgs) == 0 {
-		return
+		switch {
+		case malformed:
+			return models.DeliveryNone, models.ReasonMalformedGroup
+		case suspended:
+			return models.DeliveryNone, models.ReasonSuspendedGroup
+		case muted:
+			return models.DeliverySkipped, models.ReasonMuted
+		default:
+			return models.DeliveryNone, models.ReasonNoChange
+		}
+	}
+
+	// group hash may change in the already hidden part of the day, while the rendered text stays the same
+	textHash := messageHash(table.Date, msgs)
+	if textHash == sub.LastMessageHash {
+		if _, err := s.storeDelivery(sub); err != nil {
+			slog.Error("failed to update subscription", "error", err, slogChatID)
+		}
+		return models.DeliveryNone, models.ReasonSameText
 	}
 
-	msg, err := renderMessage(table.Date, msgs)
+	msg, err := renderMessage(table, msgs, sub.Settings.TwelveHourTime, s.clock.Now())
 	if err != nil {
 		slog.Error("failed to render message", "error", err, slogChatID)
-		return
+		return models.DeliveryFailed, models.ReasonRenderFailed
 	}
-	if err := s.sender.Send(chatID, msg); err != nil {
+	quietHours := !s.currentOptions().NotificationWindow.Contains(now)
+	messageID, err := s.sender.SendUpdate(chatID, msg, sentGroups, sub.Settings.AlwaysSilent || quietHours)
+	if err != nil {
 		slog.Error("failed to send message", "error", err, slogChatID)
-		return
+		return models.DeliveryFailed, models.ReasonSendFailed
+	}
+
+	if messageID == 0 {
+		// bot can't send messages to the chat anymore, subscription is disabled by the sender
+		return models.DeliveryDisabled, models.ReasonBlocked
+	}
+
+	if !sub.Settings.KeepHistory && sub.LastMessageID != 0 {
+		// previous schedule is superseded by the new one
+		if err = s.sender.Delete(chatID, sub.LastMessageID); err != nil {
+			slog.Debug("failed to delete previous message", "error", err, slogChatID, "messageID", sub.LastMessageID)
+		}
+	}
+	sub.LastMessageID = messageID
+	sub.LastMessageHash = textHash
+	sub.UpdatesSent++
+	sub.LastDeliveredAt = now
+	enable(&sub)
+
+	if _, err := s.storeDelivery(sub); err != nil {
+		slog.Error("failed to update subscription", "error", err, slogChatID)
+	}
+	if quietHours && !sub.Settings.AlwaysSilent {
+		return models.DeliverySent, models.ReasonQuietHours
+	}
+	return models.DeliverySent, ""
+}
+
+// storeDelivery stores the subscription after schedule update. If the subscription was changed concurrently, e.g. by
+// a settings toggle, delivery fields are applied to the fresh one, so the update is not sent again
+func (s *Service) storeDelivery(sub models.Subscription) (models.Subscription, error) {
+	stored, err := s.repo.Put(sub)
+	if !errors.Is(err, models.ErrConflict) {
+		return stored, err
 	}
+	return s.update(sub.ChatID, func(fresh *models.Subscription) error {
+		applyDelivery(fresh, sub)
+		return nil
+	})
+}
+
+// applyDelivery copies fields written by schedule updates. Hashes are copied only for groups dst is still
+// subscribed to
+func applyDelivery(dst *models.Subscription, src models.Subscription) {
+	for groupNum, hash := range src.Groups {
+		if _, ok := dst.Groups[groupNum]; ok {
+			dst.Groups[groupNum] = hash
+		}
+	}
+	dst.LastMessageID = src.LastMessageID
+	dst.LastMessageHash = src.LastMessageHash
+	dst.LastDeliveredAt = src.LastDeliveredAt
+	dst.UpdatesSent = src.UpdatesSent
+	dst.GroupsLostAt = src.GroupsLostAt
+	dst.DisabledAt = src.DisabledAt
+	dst.ProbedAt = src.ProbedAt
+	dst.ProbeFailures = src.ProbeFailures
+}
 
-	if _, err := s.repo.Put(sub); err != nil {
+const groupsLostMessage = "⚠️ Вашої групи більше немає у графіку — оновіть підписку"
+
+func hasAnyGroup(groups map[string]string, grouped map[string]models.ShutdownGroup) bool {
+	for groupNum := range groups {
+		if _, ok := grouped[groupNum]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// warnGroupsLost tells the chat once that none of its groups remain in the schedule, e.g. after provider renumbered
+// them, as otherwise the chat silently stops getting updates
+func (s *Service) warnGroupsLost(sub models.Subscription, now time.Time) (models.Delivery, models.DeliveryReason) {
+	if !sub.GroupsLostAt.IsZero() {
+		return models.DeliveryNone, models.ReasonGroupsLost
+	}
+
+	slogChatID := slog.Int64("chatID", sub.ChatID)
+	quietHours := !s.currentOptions().NotificationWindow.Contains(now)
+	messageID, err := s.sender.SendChooseGroup(sub.ChatID, groupsLostMessage, sub.Settings.AlwaysSilent || quietHours)
+	if err != nil {
+		slog.Error("failed to send groups lost warning", "error", err, slogChatID)
+		return models.DeliveryFailed, models.ReasonSendFailed
+	}
+	if messageID == 0 {
+		return models.DeliveryDisabled, models.ReasonBlocked
+	}
+
+	sub.GroupsLostAt = now
+	enable(&sub)
+	if _, err := s.storeDelivery(sub); err != nil {
 		slog.Error("failed to update subscription", "error", err, slogChatID)
-		return
+		return models.DeliverySent, models.ReasonStoreFailed
 	}
+	return models.DeliverySent, models.ReasonGroupsLost
 }
 
 var kyivTime *time.Location
 
-func join(periods []models.Period, statuses []models.Status) ([]models.Period, []models.Status) {
+// renderSettingsGroup renders group schedule according to the subscription settings
+func renderSettingsGroup(
+	settings models.Settings, periods []models.Period, groupNum string, group models.ShutdownGroup, now time.Time,
+) (string, error) {
+	groupedPeriods, groupedStatuses, err := join(periods, group.Items)
+	if err != nil {
+		return "", err
+	}
+	cutPeriods, cutStatuses := visiblePeriods(groupedPeriods, groupedStatuses, now, settings.ShowFullDay)
+	return renderGroup(groupNum, cutPeriods, cutStatuses, settings.TwelveHourTime)
+}
+
+// messageHash is calculated before the footer is added, so fetch time doesn't make messages different
+func messageHash(date string, msgs []string) string {
+	h := sha256.New()
+	h.Write([]byte(date))
+	for _, msg := range msgs {
+		h.Write([]byte(msg))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// errMalformedGroup is returned for a group which items don't match the table periods, e.g. because of provider
+// markup bugs. Such group is skipped, so other groups of the subscription are still rendered
+var errMalformedGroup = errors.New("group items don't match periods")
+
+func join(periods []models.Period, statuses []models.Status) ([]models.Period, []models.Status, error) {
+	if len(periods) == 0 || len(periods) != len(statuses) {
+		return nil, nil, fmt.Errorf("%w: periods=%d, items=%d", errMalformedGroup, len(periods), len(statuses))
+	}
+
 	groupedPeriod := make([]models.Period, 0)
 	groupedStatus := make([]models.Status, 0)
 
@@ -194,11 +991,22 @@ func join(periods []models.Period, statuses []models.Status) ([]models.Period, [
 	groupedPeriod = append(groupedPeriod, models.Period{From: currentFrom, To: currentTo})
 	groupedStatus = append(groupedStatus, currentStatus)
 
-	return groupedPeriod, groupedStatus
+	return groupedPeriod, groupedStatus, nil
 }
 
-func cutByKyivTime(periods []models.Period, items []models.Status) ([]models.Period, []models.Status) {
-	currentKyivDateTime := time.Now().In(kyivTime).Format("15:04")
+// visiblePeriods returns periods to render for the subscriber. Periods which are already over are hidden unless
+// full day is requested
+func visiblePeriods(
+	periods []models.Period, items []models.Status, now time.Time, fullDay bool,
+) ([]models.Period, []models.Status) {
+	if fullDay {
+		return periods, items
+	}
+	return cutByKyivTime(periods, items, now)
+}
+
+func cutByKyivTime(periods []models.Period, items []models.Status, now time.Time) ([]models.Period, []models.Status) {
+	currentKyivDateTime := now.In(kyivTime).Format("15:04")
 
 	cutPeriods := make([]models.Period, 0)
 	cutItems := make([]models.Status, 0)
@@ -212,19 +1020,27 @@ func cutByKyivTime(periods []models.Period, items []models.Status) ([]models.Per
 	return cutPeriods, cutItems
 }
 
-func NewSubscriptionService(repo Repository, shutdownsService ShutdownsService, sender MessageSender) *Service {
+func NewSubscriptionService(
+	repo Repository, tombstones TombstoneRepository, purgeLog PurgeLogRepository, deliveryLog DeliveryLogRepository,
+	shutdownsService ShutdownsService, suspensions GroupSuspensions, sender MessageSender, options Options,
+	clk clock.Clock,
+) *Service {
 	return &Service{
 		repo:             repo,
+		tombstones:       tombstones,
+		purgeLog:         purgeLog,
+		deliveryLog:      deliveryLog,
 		shutdownsService: shutdownsService,
+		suspensions:      suspensions,
 		sender:           sender,
+		clock:            clk,
+		options:          options,
+
+		groupSchedules: make(map[string]groupSchedule),
 	}
 }
 
 func init() {
-	loc, err := time.LoadLocation("Europe/Kyiv")
-	if err != nil {
-		panic(err)
-	}
-	kyivTime = loc
+	kyivTime = clock.MustKyiv()
 	slog.Info("initialized kyiv time location", "current_time", time.Now().In(kyivTime).Format(time.RFC3339))
 }
@@ -0,0 +1,998 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
+)
+
+func TestNotificationWindow_Contains(t *testing.T) {
+	tests := []struct {
+		name   string
+		window NotificationWindow
+		time   time.Time
+		want   bool
+	}{
+		{
+			name:   "default before start",
+			window: DefaultNotificationWindow(),
+			time:   time.Date(2024, 6, 10, 7, 59, 0, 0, kyivTime),
+			want:   false,
+		},
+		{
+			name:   "default start",
+			window: DefaultNotificationWindow(),
+			time:   time.Date(2024, 6, 10, 8, 0, 0, 0, kyivTime),
+			want:   true,
+		},
+		{
+			name:   "default end",
+			window: DefaultNotificationWindow(),
+			time:   time.Date(2024, 6, 10, 21, 0, 0, 0, kyivTime),
+			want:   false,
+		},
+		{
+			name:   "custom before start",
+			window: NotificationWindow{StartHour: 6, EndHour: 23},
+			time:   time.Date(2024, 6, 10, 5, 59, 0, 0, kyivTime),
+			want:   false,
+		},
+		{
+			name:   "custom start",
+			window: NotificationWindow{StartHour: 6, EndHour: 23},
+			time:   time.Date(2024, 6, 10, 6, 0, 0, 0, kyivTime),
+			want:   true,
+		},
+		{
+			name:   "custom before end",
+			window: NotificationWindow{StartHour: 6, EndHour: 23},
+			time:   time.Date(2024, 6, 10, 22, 59, 0, 0, kyivTime),
+			want:   true,
+		},
+		{
+			name:   "custom end",
+			window: NotificationWindow{StartHour: 6, EndHour: 23},
+			time:   time.Date(2024, 6, 10, 23, 0, 0, 0, kyivTime),
+			want:   false,
+		},
+		{
+			name:   "whole day",
+			window: NotificationWindow{StartHour: 0, EndHour: 24},
+			time:   time.Date(2024, 6, 10, 23, 59, 0, 0, kyivTime),
+			want:   true,
+		},
+		{
+			name:   "utc time converted to kyiv",
+			window: NotificationWindow{StartHour: 6, EndHour: 23},
+			time:   time.Date(2024, 6, 10, 4, 0, 0, 0, time.UTC),
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.time); got != tt.want {
+				t.Errorf("Contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotificationWindow_Validate(t *testing.T) {
+	valid := []NotificationWindow{{0, 24}, {8, 21}, {23, 24}}
+	for _, w := range valid {
+		if err := w.Validate(); err != nil {
+			t.Errorf("Validate(%v) unexpected error: %v", w, err)
+		}
+	}
+
+	invalid := []NotificationWindow{{-1, 10}, {10, 10}, {21, 8}, {0, 25}, {24, 24}}
+	for _, w := range invalid {
+		if err := w.Validate(); err == nil {
+			t.Errorf("Validate(%v) expected error", w)
+		}
+	}
+}
+
+type memoryRepo struct {
+	mx   sync.Mutex
+	subs map[int64]models.Subscription
+}
+
+func newMemoryRepo(subs ...models.Subscription) *memoryRepo {
+	r := &memoryRepo{subs: make(map[int64]models.Subscription)}
+	for _, sub := range subs {
+		r.subs[sub.ChatID] = sub
+	}
+	return r
+}
+
+func (r *memoryRepo) Size() (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return len(r.subs), nil
+}
+
+func (r *memoryRepo) Exists(chatID int64) (bool, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	_, ok := r.subs[chatID]
+	return ok, nil
+}
+
+func (r *memoryRepo) Get(chatID int64) (models.Subscription, bool, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	sub, ok := r.subs[chatID]
+	return sub, ok, nil
+}
+
+func (r *memoryRepo) GetAll() ([]models.Subscription, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	res := make([]models.Subscription, 0, len(r.subs))
+	for _, sub := range r.subs {
+		res = append(res, sub)
+	}
+	return res, nil
+}
+
+func (r *memoryRepo) Put(sub models.Subscription) (models.Subscription, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if sub.Version != r.subs[sub.ChatID].Version {
+		return sub, models.ErrConflict
+	}
+	sub.Version++
+	r.subs[sub.ChatID] = sub
+	return sub, nil
+}
+
+func (r *memoryRepo) Purge(chatID int64) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	delete(r.subs, chatID)
+	return nil
+}
+
+func (r *memoryRepo) Migrate(from, to int64) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if sub, ok := r.subs[from]; ok {
+		sub.ChatID = to
+		r.subs[to] = sub
+		delete(r.subs, from)
+	}
+	return nil
+}
+
+func (r *memoryRepo) UpdateAll(fn func(sub *models.Subscription) bool) (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	n := 0
+	for chatID, sub := range r.subs {
+		if fn(&sub) {
+			sub.Version++
+			r.subs[chatID] = sub
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestService_SetGroups(t *testing.T) {
+	existing := models.Subscription{
+		ChatID:    1,
+		ChatType:  models.ChatTypePrivate,
+		Groups:    map[string]string{"1": "hash1", "2": "hash2"},
+		Settings:  models.Settings{KeepHistory: true},
+		CreatedAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name      string
+		chatID    int64
+		groups    []string
+		wantErr   error
+		wantSub   *models.Subscription
+		wantTotal int
+	}{
+		{
+			name:   "create",
+			chatID: 2,
+			groups: []string{"3", "18"},
+			wantSub: &models.Subscription{
+				ChatID: 2, Groups: map[string]string{"3": "", "18": ""}, Source: "organic", Version: 1,
+			},
+			wantTotal: 2,
+		},
+		{
+			name:   "replace keeping hashes of remaining groups",
+			chatID: 1,
+			groups: []string{"2", "5"},
+			wantSub: &models.Subscription{
+				ChatID:   1,
+				Groups:   map[string]string{"2": "hash2", "5": ""},
+				Settings: models.Settings{KeepHistory: true},
+				Version:  1,
+			},
+			wantTotal: 1,
+		},
+		{
+			name:      "empty purges",
+			chatID:    1,
+			groups:    nil,
+			wantTotal: 0,
+		},
+		{
+			name:      "invalid group",
+			chatID:    1,
+			groups:    []string{"2", "19"},
+			wantErr:   models.ErrInvalidGroup,
+			wantSub:   &existing,
+			wantTotal: 1,
+		},
+		{
+			name:      "not a number",
+			chatID:    2,
+			groups:    []string{"abc"},
+			wantErr:   models.ErrInvalidGroup,
+			wantTotal: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepo(existing)
+			s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, nil)
+
+			_, err := s.SetGroups(tt.chatID, models.ChatTypePrivate, tt.groups, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetGroups() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(repo.subs) != tt.wantTotal {
+				t.Errorf("subscriptions count = %d, want %d", len(repo.subs), tt.wantTotal)
+			}
+			got, ok := repo.subs[tt.chatID]
+			if tt.wantSub == nil {
+				if ok {
+					t.Errorf("subscription = %v, want none", got)
+				}
+				return
+			}
+			want := *tt.wantSub
+			want.ChatType = models.ChatTypePrivate
+			if got.CreatedAt.IsZero() {
+				t.Errorf("subscription created at is not set")
+			}
+			want.CreatedAt = got.CreatedAt
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("subscription = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestVisiblePeriods(t *testing.T) {
+	periods := []models.Period{
+		{From: "00:00", To: "08:00"},
+		{From: "08:00", To: "16:00"},
+		{From: "16:00", To: "24:00"},
+	}
+	statuses := []models.Status{models.ON, models.OFF, models.MAYBE}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		fullDay bool
+		want    []models.Period
+	}{
+		{name: "morning", now: kyivDate(7), fullDay: false, want: periods},
+		{name: "morning full day", now: kyivDate(7), fullDay: true, want: periods},
+		{name: "afternoon", now: kyivDate(13), fullDay: false, want: periods[1:]},
+		{name: "afternoon full day", now: kyivDate(13), fullDay: true, want: periods},
+		{name: "evening", now: kyivDate(20), fullDay: false, want: periods[2:]},
+		{name: "evening full day", now: kyivDate(20), fullDay: true, want: periods},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPeriods, gotStatuses := visiblePeriods(periods, statuses, tt.now, tt.fullDay)
+			if !reflect.DeepEqual(gotPeriods, tt.want) {
+				t.Errorf("visiblePeriods() periods = %v, want %v", gotPeriods, tt.want)
+			}
+			if want := statuses[len(statuses)-len(tt.want):]; !reflect.DeepEqual(gotStatuses, want) {
+				t.Errorf("visiblePeriods() statuses = %v, want %v", gotStatuses, want)
+			}
+		})
+	}
+}
+
+func kyivDate(hour int) time.Time {
+	return time.Date(2024, 1, 15, hour, 0, 0, 0, kyivTime)
+}
+
+type memoryPurgeLog struct {
+	events []models.PurgeEvent
+}
+
+func (r *memoryPurgeLog) Add(e models.PurgeEvent) error {
+	r.events = append([]models.PurgeEvent{e}, r.events...)
+	return nil
+}
+
+func (r *memoryPurgeLog) GetAll() ([]models.PurgeEvent, error) {
+	return r.events, nil
+}
+
+type memoryDeliveryLog struct {
+	entries map[int64][]models.DeliveryLogEntry
+	writes  int
+}
+
+func (r *memoryDeliveryLog) Add(entries map[int64]models.DeliveryLogEntry) error {
+	if r.entries == nil {
+		r.entries = make(map[int64][]models.DeliveryLogEntry)
+	}
+	r.writes++
+	for chatID, e := range entries {
+		r.entries[chatID] = append(r.entries[chatID], e)
+	}
+	return nil
+}
+
+func (r *memoryDeliveryLog) Get(chatID int64) ([]models.DeliveryLogEntry, error) {
+	return slices.Clone(r.entries[chatID]), nil
+}
+
+func newTestService(
+	repo Repository, tombstones *memoryTombstones, shutdowns *fakeShutdowns, sender MessageSender,
+) *Service {
+	return NewSubscriptionService(
+		repo, tombstones, &memoryPurgeLog{}, &memoryDeliveryLog{}, shutdowns, noSuspensions{}, sender, DefaultOptions(),
+		clock.New(),
+	)
+}
+
+type noSuspensions struct{}
+
+func (noSuspensions) IsSuspended(string) bool {
+	return false
+}
+
+type suspendedGroups map[string]bool
+
+func (s suspendedGroups) IsSuspended(groupNum string) bool {
+	return s[groupNum]
+}
+
+type fakeShutdowns struct {
+	table models.ShutdownsTable
+}
+
+func (s *fakeShutdowns) GetShutdownsTable() (models.ShutdownsTable, bool, error) {
+	return s.table, s.table.Date != "", nil
+}
+
+func (s *fakeShutdowns) RefreshShutdownsTable() error {
+	return nil
+}
+
+type fakeSender struct {
+	mx    sync.Mutex
+	sent  []string
+	chats []int64
+}
+
+func (s *fakeSender) Send(chatID int64, text string, _ bool) (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.sent = append(s.sent, text)
+	s.chats = append(s.chats, chatID)
+	return len(s.sent), nil
+}
+
+func (s *fakeSender) SendUpdate(chatID int64, text string, groups []string, silent bool) (int, error) {
+	return s.Send(chatID, text, silent)
+}
+
+func (s *fakeSender) SendChooseGroup(chatID int64, text string, silent bool) (int, error) {
+	return s.Send(chatID, text, silent)
+}
+
+func (s *fakeSender) Delete(int64, int) error {
+	return nil
+}
+
+func TestService_processSubscription_SkipsIdenticalText(t *testing.T) {
+	table := func(statuses ...models.Status) models.ShutdownsTable {
+		return models.ShutdownsTable{
+			Date: "15 січня",
+			Periods: []models.Period{
+				{From: "00:00", To: "08:00"},
+				{From: "08:00", To: "16:00"},
+				{From: "16:00", To: "24:00"},
+			},
+			Groups: map[string]models.ShutdownGroup{
+				"1": {Number: 1, Items: statuses},
+			},
+		}
+	}
+	process := func(s *Service, repo *memoryRepo, table models.ShutdownsTable) {
+		grouped := map[string]models.ShutdownGroup{"1": table.Groups["1"]}
+		s.processSubscription(repo.subs[1], table, grouped, kyivDate(13))
+	}
+
+	repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}})
+	sender := &fakeSender{}
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, sender)
+
+	process(s, repo, table(models.ON, models.OFF, models.ON))
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent messages = %d, want 1", len(sender.sent))
+	}
+
+	// already hidden period flips
+	flipped := table(models.MAYBE, models.OFF, models.ON)
+	process(s, repo, flipped)
+	if len(sender.sent) != 1 {
+		t.Errorf("sent messages after past period flip = %d, want 1", len(sender.sent))
+	}
+	wantHash := flipped.Groups["1"].Hash(fmt.Sprintf("%s:", flipped.Date))
+	if got := repo.subs[1].Groups["1"]; got != wantHash {
+		t.Errorf("group hash = %q, want %q", got, wantHash)
+	}
+
+	process(s, repo, table(models.MAYBE, models.OFF, models.OFF))
+	if len(sender.sent) != 2 {
+		t.Errorf("sent messages after upcoming period change = %d, want 2", len(sender.sent))
+	}
+}
+
+func TestService_Disable(t *testing.T) {
+	repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": "hash1"}})
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, nil)
+
+	if err := s.Disable(1); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if !repo.subs[1].IsDisabled() {
+		t.Fatalf("subscription is not disabled")
+	}
+
+	purgeLog := s.purgeLog.(*memoryPurgeLog)
+	if len(purgeLog.events) != 1 || purgeLog.events[0].Action != models.PurgeActionDisabled {
+		t.Errorf("purge log = %v, want single disabled event", purgeLog.events)
+	}
+
+	for i := 1; i <= maxProbeFailures; i++ {
+		sub := repo.subs[1]
+		sub.ProbedAt = time.Now()
+		repo.subs[1] = sub
+		if err := s.Disable(1); err != nil {
+			t.Fatalf("Disable() error = %v", err)
+		}
+		if _, exists := repo.subs[1]; exists != (i < maxProbeFailures) {
+			t.Fatalf("subscription exists = %t after %d failed probes", exists, i)
+		}
+	}
+	if len(purgeLog.events) != 2 || purgeLog.events[0].Action != models.PurgeActionPurged {
+		t.Errorf("purge log = %v, want purged event to be the latest", purgeLog.events)
+	}
+
+	repo.subs[2] = models.Subscription{ChatID: 2, Groups: map[string]string{"2": "hash2"}, DisabledAt: time.Now()}
+	sub, err := s.Enable(2)
+	if err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if sub.IsDisabled() || sub.Groups["2"] != "hash2" {
+		t.Errorf("Enable() = %v, want enabled subscription with groups kept", sub)
+	}
+}
+
+func TestService_SubscribeDuringSendUpdates(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.OFF}},
+		},
+	}
+	wantHash := table.Groups["1"].Hash(fmt.Sprintf("%s:", table.Date))
+
+	for i := 0; i < 20; i++ {
+		repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}})
+		sender := &fakeSender{}
+		s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{table: table}, sender)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := s.SendUpdates(context.Background()); err != nil {
+				t.Errorf("SendUpdates() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := s.SubscribeToGroup(2, models.ChatTypePrivate, "1", ""); err != nil {
+				t.Errorf("SubscribeToGroup() error = %v", err)
+			}
+		}()
+		wg.Wait()
+
+		// new subscriber gets the schedule with onboarding, so updates must not send it again
+		if _, err := s.SendUpdates(context.Background()); err != nil {
+			t.Fatalf("SendUpdates() error = %v", err)
+		}
+		for _, chatID := range sender.chats {
+			if chatID == 2 {
+				t.Fatalf("new subscriber received schedule update")
+			}
+		}
+		if got := repo.subs[2].Groups["1"]; got != wantHash {
+			t.Fatalf("new subscriber group hash = %q, want %q", got, wantHash)
+		}
+	}
+}
+
+func TestService_SendUpdates_Report(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups:  map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.OFF}}},
+	}
+	repo := newMemoryRepo(
+		models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}},
+		models.Subscription{ChatID: 2, Groups: map[string]string{"1": ""}, MutedUntil: time.Now().Add(time.Hour)},
+	)
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{table: table}, &fakeSender{})
+
+	report, err := s.SendUpdates(context.Background())
+	if err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if want := (models.RunReport{Attempted: 1, Sent: 1, Skipped: 1}); report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	// nothing changed, so nothing is attempted
+	if report, _ = s.SendUpdates(context.Background()); report.Attempted != 0 {
+		t.Errorf("repeated report = %+v, want no attempts", report)
+	}
+	// outcomes are the same as in the previous run, so the log is not written
+	if report, _ = s.SendUpdates(context.Background()); report.Attempted != 0 {
+		t.Errorf("repeated report = %+v, want no attempts", report)
+	}
+
+	if writes := s.deliveryLog.(*memoryDeliveryLog).writes; writes != 2 {
+		t.Errorf("delivery log writes = %d, want 2", writes)
+	}
+	log, err := s.DeliveryLog(1)
+	if err != nil {
+		t.Fatalf("DeliveryLog() error = %v", err)
+	}
+	if len(log) != 2 || log[0].Reason != models.ReasonNoChange || log[1].Delivery != models.DeliverySent {
+		t.Errorf("DeliveryLog(1) = %+v, want no change after sent", log)
+	}
+	if log, _ = s.DeliveryLog(2); len(log) != 1 || log[0].Reason != models.ReasonMuted {
+		t.Errorf("DeliveryLog(2) = %+v, want muted", log)
+	}
+}
+
+func TestService_processSubscription_SkipsMalformedGroup(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "12:00"}, {From: "12:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.ON, models.OFF}},
+			"2": {Number: 2, Items: []models.Status{models.ON}},
+		},
+	}
+	repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": "", "2": ""}})
+	sender := &fakeSender{}
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{table: table}, sender)
+
+	got, _ := s.processSubscription(repo.subs[1], table, table.Groups, kyivDate(1))
+	if got != models.DeliverySent {
+		t.Fatalf("processSubscription() = %v, want %v", got, models.DeliverySent)
+	}
+	if len(sender.sent) != 1 || !strings.Contains(sender.sent[0], "Група 1") || strings.Contains(sender.sent[0], "Група 2") {
+		t.Errorf("sent = %q, want only the healthy group", sender.sent)
+	}
+	if hash := repo.subs[1].Groups["2"]; hash != "" {
+		t.Errorf("malformed group hash = %q, want it not updated", hash)
+	}
+
+	msg, err := s.RenderSchedule(1)
+	if err != nil {
+		t.Fatalf("RenderSchedule() error = %v", err)
+	}
+	if !strings.Contains(msg, "Група 1") {
+		t.Errorf("RenderSchedule() = %q, want the healthy group", msg)
+	}
+}
+
+// interleavingRepo runs concurrent change once, right after the subscription is read
+type interleavingRepo struct {
+	*memoryRepo
+	concurrent func()
+}
+
+func (r *interleavingRepo) Get(chatID int64) (models.Subscription, bool, error) {
+	sub, ok, err := r.memoryRepo.Get(chatID)
+	if concurrent := r.concurrent; concurrent != nil {
+		r.concurrent = nil
+		concurrent()
+	}
+	return sub, ok, err
+}
+
+func TestService_ToggleSetting_Concurrent(t *testing.T) {
+	t.Run("concurrent change is kept", func(t *testing.T) {
+		repo := &interleavingRepo{memoryRepo: newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}})}
+		s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, nil)
+		repo.concurrent = func() {
+			if _, err := s.ToggleSetting(1, models.SettingKeepHistory); err != nil {
+				t.Fatalf("concurrent ToggleSetting() error = %v", err)
+			}
+		}
+
+		sub, err := s.ToggleSetting(1, models.SettingAlwaysSilent)
+		if err != nil {
+			t.Fatalf("ToggleSetting() error = %v", err)
+		}
+		if !sub.Settings.KeepHistory || !sub.Settings.AlwaysSilent || sub.Version != 2 {
+			t.Errorf("ToggleSetting() = %+v, want both settings enabled at version 2", sub)
+		}
+	})
+
+	t.Run("concurrent purge", func(t *testing.T) {
+		repo := &interleavingRepo{memoryRepo: newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}, Version: 3})}
+		s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, nil)
+		repo.concurrent = func() {
+			_ = repo.Purge(1)
+		}
+
+		if _, err := s.ToggleSetting(1, models.SettingKeepHistory); !errors.Is(err, models.ErrSubscriptionNotFound) {
+			t.Errorf("ToggleSetting() error = %v, want %v", err, models.ErrSubscriptionNotFound)
+		}
+		if _, exists := repo.subs[1]; exists {
+			t.Errorf("purged subscription is stored again")
+		}
+	})
+}
+
+// interleavingSender runs concurrent change once, while the update is being sent
+type interleavingSender struct {
+	fakeSender
+	concurrent func()
+}
+
+func (s *interleavingSender) SendUpdate(chatID int64, text string, groups []string, silent bool) (int, error) {
+	if concurrent := s.concurrent; concurrent != nil {
+		s.concurrent = nil
+		concurrent()
+	}
+	return s.fakeSender.SendUpdate(chatID, text, groups, silent)
+}
+
+func TestService_SendUpdates_Concurrent(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups:  map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.OFF}}},
+	}
+	repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": ""}, LastMessageID: 7})
+	sender := &interleavingSender{}
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{table: table}, sender)
+	sender.concurrent = func() {
+		if _, err := s.ToggleSetting(1, models.SettingKeepHistory); err != nil {
+			t.Fatalf("concurrent ToggleSetting() error = %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.SendUpdates(context.Background()); err != nil {
+			t.Fatalf("SendUpdates() error = %v", err)
+		}
+	}
+	if len(sender.sent) != 1 {
+		t.Errorf("sent = %q, want the update once", sender.sent)
+	}
+	sub := repo.subs[1]
+	if !sub.Settings.KeepHistory {
+		t.Error("concurrent setting change is lost")
+	}
+	if sub.LastMessageID != 1 || sub.UpdatesSent != 1 {
+		t.Errorf("LastMessageID = %d, UpdatesSent = %d, want delivery stored", sub.LastMessageID, sub.UpdatesSent)
+	}
+}
+
+func TestService_RenderGroupsSchedule(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "12:00"}, {From: "12:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.ON, models.OFF}},
+			"2": {Number: 2, Items: []models.Status{models.OFF, models.ON}},
+			"3": {Number: 3, Items: []models.Status{models.ON}},
+		},
+	}
+	s := newTestService(newMemoryRepo(), newMemoryTombstones(), &fakeShutdowns{table: table}, nil)
+
+	msg, err := s.RenderGroupsSchedule([]string{"3", "2", "1", "7"}, models.Settings{ShowFullDay: true})
+	if err != nil {
+		t.Fatalf("RenderGroupsSchedule() error = %v", err)
+	}
+	first, second := strings.Index(msg, "Група 1"), strings.Index(msg, "Група 2")
+	if first < 0 || second < first || strings.Contains(msg, "Група 3") {
+		t.Errorf("RenderGroupsSchedule() = %q, want groups 1 and 2 in order and malformed group skipped", msg)
+	}
+
+	twelveHour, err := s.RenderGroupsSchedule([]string{"1"}, models.Settings{ShowFullDay: true, TwelveHourTime: true})
+	if err != nil {
+		t.Fatalf("RenderGroupsSchedule() error = %v", err)
+	}
+	if twelveHour == msg || !strings.Contains(twelveHour, "PM") {
+		t.Errorf("RenderGroupsSchedule() = %q, want 12-hour format", twelveHour)
+	}
+}
+
+func TestService_ResyncState(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.OFF}},
+			"2": {Number: 2, Items: []models.Status{models.ON}},
+		},
+	}
+	repo := newMemoryRepo(
+		models.Subscription{ChatID: 1, Groups: map[string]string{"1": "stale", "2": ""}},
+		models.Subscription{ChatID: 2, Groups: map[string]string{"2": table.Groups["2"].Hash("15 січня:")}},
+		// already warned that its group is gone
+		models.Subscription{ChatID: 3, Groups: map[string]string{"7": "unknown"}, GroupsLostAt: kyivDate(1)},
+	)
+	sender := &fakeSender{}
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{table: table}, sender)
+
+	n, err := s.ResyncState()
+	if err != nil {
+		t.Fatalf("ResyncState() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("ResyncState() = %d, want only the stale subscription rewritten", n)
+	}
+	if hash := repo.subs[3].Groups["7"]; hash != "unknown" {
+		t.Errorf("hash of group missing in the table = %q, want it kept", hash)
+	}
+
+	if _, err = s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Errorf("sent = %q after resync, want nothing", sender.sent)
+	}
+}
+
+func TestService_SendUpdates_GroupsLost(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups:  map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.OFF}}},
+	}
+	repo := newMemoryRepo(
+		// partial loss keeps updates of the remaining group
+		models.Subscription{ChatID: 1, Groups: map[string]string{"1": "", "7": ""}},
+		models.Subscription{ChatID: 2, Groups: map[string]string{"7": "", "8": ""}},
+	)
+	sender := &fakeSender{}
+	shutdowns := &fakeShutdowns{table: table}
+	s := newTestService(repo, newMemoryTombstones(), shutdowns, sender)
+
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 2 {
+		t.Fatalf("sent = %q, want schedule and warning", sender.sent)
+	}
+	for i, chatID := range sender.chats {
+		isWarning := sender.sent[i] == groupsLostMessage
+		if isWarning != (chatID == 2) {
+			t.Errorf("chat %d got %q", chatID, sender.sent[i])
+		}
+	}
+	if !repo.subs[1].GroupsLostAt.IsZero() {
+		t.Errorf("GroupsLostAt = %v for partial loss, want zero", repo.subs[1].GroupsLostAt)
+	}
+	if repo.subs[2].GroupsLostAt.IsZero() {
+		t.Error("GroupsLostAt is zero, want the warning recorded")
+	}
+
+	// warning is not repeated
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 2 {
+		t.Fatalf("sent = %q, want the warning once", sender.sent[2:])
+	}
+
+	// group is back, so its schedule is sent and the warning is armed again
+	shutdowns.table.Groups["7"] = models.ShutdownGroup{Number: 7, Items: []models.Status{models.ON}}
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 4 {
+		t.Fatalf("sent = %q, want schedules of the returned group", sender.sent[2:])
+	}
+	if !repo.subs[2].GroupsLostAt.IsZero() {
+		t.Errorf("GroupsLostAt = %v after group is back, want zero", repo.subs[2].GroupsLostAt)
+	}
+}
+
+func TestService_SendUpdates_GroupsLost_UnchangedComeback(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups:  map[string]models.ShutdownGroup{"1": {Number: 1, Items: []models.Status{models.OFF}}},
+	}
+	group := models.ShutdownGroup{Number: 7, Items: []models.Status{models.ON}}
+	// the group was lost after its schedule had been sent
+	repo := newMemoryRepo(models.Subscription{
+		ChatID: 1, Groups: map[string]string{"7": group.Hash("15 січня:")}, GroupsLostAt: kyivDate(1),
+	})
+	sender := &fakeSender{}
+	shutdowns := &fakeShutdowns{table: table}
+	s := newTestService(repo, newMemoryTombstones(), shutdowns, sender)
+
+	shutdowns.table.Groups["7"] = group
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("sent = %q, want nothing for the unchanged group", sender.sent)
+	}
+	if !repo.subs[1].GroupsLostAt.IsZero() {
+		t.Errorf("GroupsLostAt = %v after group is back, want zero", repo.subs[1].GroupsLostAt)
+	}
+
+	delete(shutdowns.table.Groups, "7")
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 1 || sender.sent[0] != groupsLostMessage {
+		t.Errorf("sent = %q, want the warning again", sender.sent)
+	}
+}
+
+func TestService_MuteGroup(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.OFF}},
+			"2": {Number: 2, Items: []models.Status{models.ON}},
+		},
+	}
+	repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": "", "2": ""}})
+	sender := &fakeSender{}
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{table: table}, sender)
+
+	if _, err := s.MuteGroup(1, "3", time.Now().Add(time.Hour)); !errors.Is(err, models.ErrInvalidGroup) {
+		t.Errorf("MuteGroup() of not subscribed group error = %v, want %v", err, models.ErrInvalidGroup)
+	}
+	if _, err := s.MuteGroup(1, "2", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("MuteGroup() error = %v", err)
+	}
+
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 1 || !strings.Contains(sender.sent[0], "Група 1") || strings.Contains(sender.sent[0], "Група 2") {
+		t.Fatalf("sent = %q, want only the not muted group", sender.sent)
+	}
+
+	// muted group is sent once unmuted
+	if _, err := s.Unmute(1); err != nil {
+		t.Fatalf("Unmute() error = %v", err)
+	}
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 2 || !strings.Contains(sender.sent[1], "Група 2") {
+		t.Errorf("sent = %q, want the unmuted group", sender.sent)
+	}
+}
+
+func TestService_UnsubscribeFromGroup(t *testing.T) {
+	repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"1": "", "2": ""}})
+	s := newTestService(repo, newMemoryTombstones(), &fakeShutdowns{}, nil)
+
+	sub, err := s.UnsubscribeFromGroup(1, "2")
+	if err != nil {
+		t.Fatalf("UnsubscribeFromGroup() error = %v", err)
+	}
+	if want := map[string]string{"1": ""}; !reflect.DeepEqual(sub.Groups, want) {
+		t.Errorf("UnsubscribeFromGroup() groups = %v, want %v", sub.Groups, want)
+	}
+
+	if sub, err = s.UnsubscribeFromGroup(1, "1"); err != nil || len(sub.Groups) != 0 {
+		t.Fatalf("UnsubscribeFromGroup() of the last group = %+v, %v", sub, err)
+	}
+	if _, exists := repo.subs[1]; exists {
+		t.Error("subscription without groups is kept")
+	}
+}
+
+func TestService_SendUpdates_SuspendedGroup(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.OFF}},
+			"2": {Number: 2, Items: []models.Status{models.ON}},
+		},
+	}
+	repo := newMemoryRepo(
+		models.Subscription{ChatID: 1, Groups: map[string]string{"1": "", "2": ""}},
+		models.Subscription{ChatID: 2, Groups: map[string]string{"2": ""}},
+	)
+	sender := &fakeSender{}
+	suspensions := suspendedGroups{"2": true}
+	s := NewSubscriptionService(
+		repo, newMemoryTombstones(), &memoryPurgeLog{}, &memoryDeliveryLog{}, &fakeShutdowns{table: table},
+		suspensions, sender, DefaultOptions(), clock.New(),
+	)
+
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 1 || sender.chats[0] != 1 || strings.Contains(sender.sent[0], "Група 2") {
+		t.Fatalf("sent = %q to %v, want only group 1 to chat 1", sender.sent, sender.chats)
+	}
+
+	// hash of the suspended group is kept, so its schedule is sent once suspension is lifted
+	delete(suspensions, "2")
+	if _, err := s.SendUpdates(context.Background()); err != nil {
+		t.Fatalf("SendUpdates() error = %v", err)
+	}
+	if len(sender.sent) != 3 {
+		t.Errorf("sent = %q, want group 2 sent to both chats after resume", sender.sent)
+	}
+}
+
+func TestService_GroupSchedule_SharedRenderer(t *testing.T) {
+	table := models.ShutdownsTable{
+		Date:    "15 січня",
+		Periods: []models.Period{{From: "00:00", To: "12:00"}, {From: "12:00", To: "24:00"}},
+		Groups: map[string]models.ShutdownGroup{
+			"1": {Number: 1, Items: []models.Status{models.ON, models.OFF}},
+			"3": {Number: 3, Items: []models.Status{models.ON}},
+		},
+	}
+	s := newTestService(newMemoryRepo(), newMemoryTombstones(), &fakeShutdowns{table: table}, nil)
+
+	got, ok, err := s.GroupSchedule("1", true)
+	if err != nil || !ok {
+		t.Fatalf("GroupSchedule() = %t, %v", ok, err)
+	}
+	want, err := s.RenderGroupsSchedule([]string{"1"}, models.Settings{ShowFullDay: true, TwelveHourTime: true})
+	if err != nil {
+		t.Fatalf("RenderGroupsSchedule() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("GroupSchedule() = %q, want %q", got, want)
+	}
+
+	if _, _, err = s.GroupSchedule("3", false); !errors.Is(err, errMalformedGroup) {
+		t.Errorf("GroupSchedule() of malformed group error = %v, want %v", err, errMalformedGroup)
+	}
+}
@@ -0,0 +1,120 @@
+package subscription
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+const defaultTombstoneRetention = 30 * 24 * time.Hour
+
+type TombstoneRepository interface {
+	Get(chatID int64) (models.Tombstone, bool, error)
+	GetAll() ([]models.Tombstone, error)
+	Put(t models.Tombstone) error
+	Delete(chatID int64) error
+}
+
+// Tombstone returns configuration of the chat which unsubscribed within TombstoneRetention
+func (s *Service) Tombstone(chatID int64) (models.Tombstone, bool, error) {
+	t, exists, err := s.tombstones.Get(chatID)
+	if err != nil {
+		return models.Tombstone{}, false, fmt.Errorf("failed to get tombstone: %w", err)
+	}
+	if !exists || s.expired(t, s.clock.Now()) {
+		return models.Tombstone{}, false, nil
+	}
+	return t, true, nil
+}
+
+// Restore subscribes the chat to groups with settings it had before unsubscribing
+func (s *Service) Restore(chatID int64, chatType models.ChatType) (models.Subscription, error) {
+	t, exists, err := s.Tombstone(chatID)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if !exists {
+		return models.Subscription{}, models.ErrSubscriptionNotFound
+	}
+
+	return s.apply(chatID, chatType, t.Groups, t.Settings)
+}
+
+// apply subscribes the chat to groups with settings configured elsewhere, e.g. before unsubscribing
+func (s *Service) apply(
+	chatID int64, chatType models.ChatType, groups []string, settings models.Settings,
+) (models.Subscription, error) {
+	if _, err := s.SetGroups(chatID, chatType, groups, ""); err != nil {
+		return models.Subscription{}, err
+	}
+	return s.update(chatID, func(sub *models.Subscription) error {
+		sub.Settings = settings
+		sub.OnboardingCompleted = true
+		// there is no onboarding on restore, so the current schedule is sent with the next updates
+		for groupNum := range sub.Groups {
+			sub.Groups[groupNum] = ""
+		}
+		return nil
+	})
+}
+
+// DeleteData removes the subscription of the chat with its queued notifications and the configuration kept after
+// unsubscribing, so nothing about the chat is left to restore
+func (s *Service) DeleteData(chatID int64) error {
+	if err := s.repo.Purge(chatID); err != nil {
+		return fmt.Errorf("failed to purge subscription: %w", err)
+	}
+	if err := s.tombstones.Delete(chatID); err != nil {
+		return fmt.Errorf("failed to delete tombstone: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpiredTombstones deletes configurations of chats which unsubscribed more than TombstoneRetention ago
+func (s *Service) PurgeExpiredTombstones() error {
+	tombstones, err := s.tombstones.GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to get tombstones: %w", err)
+	}
+
+	now := s.clock.Now()
+	for _, t := range tombstones {
+		if !s.expired(t, now) {
+			continue
+		}
+		if err = s.tombstones.Delete(t.ChatID); err != nil {
+			return fmt.Errorf("failed to delete tombstone for chatID=%d: %w", t.ChatID, err)
+		}
+	}
+	return nil
+}
+
+// bury keeps configuration of the subscription. Failure is not critical for unsubscribing, so it is only logged
+func (s *Service) bury(sub models.Subscription) {
+	if s.currentOptions().TombstoneRetention == 0 {
+		return
+	}
+
+	groups := make([]string, 0, len(sub.Groups))
+	for groupNum := range sub.Groups {
+		groups = append(groups, groupNum)
+	}
+	sort.Strings(groups)
+
+	t := models.Tombstone{
+		ChatID:         sub.ChatID,
+		Groups:         groups,
+		Settings:       sub.Settings,
+		UnsubscribedAt: s.clock.Now(),
+	}
+	if err := s.tombstones.Put(t); err != nil {
+		slog.Error("failed to put tombstone", "error", err, "chatID", sub.ChatID)
+	}
+}
+
+func (s *Service) expired(t models.Tombstone, now time.Time) bool {
+	return now.Sub(t.UnsubscribedAt) >= s.currentOptions().TombstoneRetention
+}
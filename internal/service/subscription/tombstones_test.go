@@ -0,0 +1,137 @@
+package subscription
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type memoryTombstones struct {
+	tombstones map[int64]models.Tombstone
+}
+
+func newMemoryTombstones() *memoryTombstones {
+	return &memoryTombstones{tombstones: make(map[int64]models.Tombstone)}
+}
+
+func (r *memoryTombstones) Get(chatID int64) (models.Tombstone, bool, error) {
+	t, ok := r.tombstones[chatID]
+	return t, ok, nil
+}
+
+func (r *memoryTombstones) GetAll() ([]models.Tombstone, error) {
+	res := make([]models.Tombstone, 0, len(r.tombstones))
+	for _, t := range r.tombstones {
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+func (r *memoryTombstones) Put(t models.Tombstone) error {
+	r.tombstones[t.ChatID] = t
+	return nil
+}
+
+func (r *memoryTombstones) Delete(chatID int64) error {
+	delete(r.tombstones, chatID)
+	return nil
+}
+
+func TestService_Restore(t *testing.T) {
+	settings := models.Settings{AlwaysSilent: true, ShowFullDay: true}
+	repo := newMemoryRepo(models.Subscription{
+		ChatID:   1,
+		Groups:   map[string]string{"7": "hash7", "2": "hash2"},
+		Settings: settings,
+	})
+	tombstones := newMemoryTombstones()
+	s := newTestService(repo, tombstones, &fakeShutdowns{}, nil)
+
+	if err := s.Unsubscribe(1); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if _, exists := repo.subs[1]; exists {
+		t.Fatalf("subscription is not purged")
+	}
+
+	sub, err := s.Restore(1, models.ChatTypePrivate)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if want := map[string]string{"2": "", "7": ""}; !reflect.DeepEqual(sub.Groups, want) {
+		t.Errorf("restored groups = %v, want %v", sub.Groups, want)
+	}
+	if sub.Settings != settings {
+		t.Errorf("restored settings = %v, want %v", sub.Settings, settings)
+	}
+	if _, exists := tombstones.tombstones[1]; exists {
+		t.Errorf("tombstone is not deleted after restore")
+	}
+}
+
+func TestService_DeleteData(t *testing.T) {
+	repo := newMemoryRepo(models.Subscription{ChatID: 1, Groups: map[string]string{"7": ""}})
+	tombstones := newMemoryTombstones()
+	s := newTestService(repo, tombstones, &fakeShutdowns{}, nil)
+
+	if err := s.Unsubscribe(1); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if _, exists := tombstones.tombstones[1]; !exists {
+		t.Fatalf("tombstone is not kept after unsubscribe")
+	}
+
+	if err := s.DeleteData(1); err != nil {
+		t.Fatalf("DeleteData() error = %v", err)
+	}
+	if _, exists := tombstones.tombstones[1]; exists {
+		t.Errorf("tombstone is kept after data deletion")
+	}
+	if _, err := s.Restore(1, models.ChatTypePrivate); !errors.Is(err, models.ErrSubscriptionNotFound) {
+		t.Errorf("Restore() after data deletion error = %v, want %v", err, models.ErrSubscriptionNotFound)
+	}
+
+	// subscribed chat is deleted right away, without keeping a tombstone
+	repo.subs[2] = models.Subscription{ChatID: 2, Groups: map[string]string{"1": ""}}
+	if err := s.DeleteData(2); err != nil {
+		t.Fatalf("DeleteData() error = %v", err)
+	}
+	if _, exists := repo.subs[2]; exists {
+		t.Errorf("subscription is kept after data deletion")
+	}
+	if _, exists := tombstones.tombstones[2]; exists {
+		t.Errorf("tombstone is created by data deletion")
+	}
+}
+
+func TestService_TombstoneExpired(t *testing.T) {
+	tombstones := newMemoryTombstones()
+	tombstones.tombstones[1] = models.Tombstone{
+		ChatID:         1,
+		Groups:         []string{"1"},
+		UnsubscribedAt: time.Now().Add(-defaultTombstoneRetention),
+	}
+	tombstones.tombstones[2] = models.Tombstone{
+		ChatID:         2,
+		Groups:         []string{"2"},
+		UnsubscribedAt: time.Now(),
+	}
+	s := newTestService(newMemoryRepo(), tombstones, &fakeShutdowns{}, nil)
+
+	if _, err := s.Restore(1, models.ChatTypePrivate); !errors.Is(err, models.ErrSubscriptionNotFound) {
+		t.Errorf("Restore() of expired tombstone error = %v, want %v", err, models.ErrSubscriptionNotFound)
+	}
+
+	if err := s.PurgeExpiredTombstones(); err != nil {
+		t.Fatalf("PurgeExpiredTombstones() error = %v", err)
+	}
+	if _, exists := tombstones.tombstones[1]; exists {
+		t.Errorf("expired tombstone is not purged")
+	}
+	if _, exists := tombstones.tombstones[2]; !exists {
+		t.Errorf("active tombstone is purged")
+	}
+}
@@ -0,0 +1,68 @@
+package subscription
+
+import (
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+// statusWindow is the run of consecutive table periods with the same group status around a moment
+type statusWindow struct {
+	Status models.Status
+	From   time.Time
+	// To is the next status change. The table covers one day only, so a status which lasts until the end of the day
+	// is open and To is the next midnight
+	To   time.Time
+	Open bool
+}
+
+// windowAt finds the status window of the group items at t. Consecutive periods with the same status, including
+// MAYBE, are joined. It returns false if t is not covered by the periods
+func windowAt(periods []models.Period, items []models.Status, t time.Time) (statusWindow, bool, error) {
+	kyivT := t.In(kyivTime)
+	minutes := kyivT.Hour()*60 + kyivT.Minute()
+
+	current := -1
+	for i, period := range periods {
+		from, err := models.ParseMinutes(period.From)
+		if err != nil {
+			return statusWindow{}, false, err
+		}
+		to, err := models.ParseMinutes(period.To)
+		if err != nil {
+			return statusWindow{}, false, err
+		}
+		if from <= minutes && minutes < to {
+			current = i
+			break
+		}
+	}
+	if current == -1 || current >= len(items) {
+		return statusWindow{}, false, nil
+	}
+
+	first, last := current, current
+	for first > 0 && items[first-1] == items[current] {
+		first--
+	}
+	for last+1 < len(items) && items[last+1] == items[current] {
+		last++
+	}
+
+	from, err := models.ParseMinutes(periods[first].From)
+	if err != nil {
+		return statusWindow{}, false, err
+	}
+	to, err := models.ParseMinutes(periods[last].To)
+	if err != nil {
+		return statusWindow{}, false, err
+	}
+	// wall clock minutes, so table times stay correct on days when clocks are changed
+	year, month, day := kyivT.Date()
+	return statusWindow{
+		Status: items[current],
+		From:   time.Date(year, month, day, 0, from, 0, 0, kyivTime),
+		To:     time.Date(year, month, day, 0, to, 0, 0, kyivTime),
+		Open:   last == len(items)-1,
+	}, true, nil
+}
@@ -0,0 +1,74 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+func TestWindowAt(t *testing.T) {
+	periods := []models.Period{
+		{From: "00:00", To: "04:00"},
+		{From: "04:00", To: "08:00"},
+		{From: "08:00", To: "12:00"},
+		{From: "12:00", To: "16:00"},
+		{From: "16:00", To: "20:00"},
+		{From: "20:00", To: "24:00"},
+	}
+	items := []models.Status{models.OFF, models.MAYBE, models.MAYBE, models.ON, models.OFF, models.OFF}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, kyivTime)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want statusWindow
+	}{
+		{
+			name: "midnight",
+			t:    at(15, 0, 0),
+			want: statusWindow{Status: models.OFF, From: at(15, 0, 0), To: at(15, 4, 0)},
+		},
+		{
+			name: "maybe run",
+			t:    at(15, 11, 59),
+			want: statusWindow{Status: models.MAYBE, From: at(15, 4, 0), To: at(15, 12, 0)},
+		},
+		{
+			name: "period boundary",
+			t:    at(15, 12, 0),
+			want: statusWindow{Status: models.ON, From: at(15, 12, 0), To: at(15, 16, 0)},
+		},
+		{
+			name: "till 24:00",
+			t:    at(15, 23, 59),
+			want: statusWindow{Status: models.OFF, From: at(15, 16, 0), To: at(16, 0, 0), Open: true},
+		},
+		{
+			name: "utc time",
+			t:    at(15, 13, 30).UTC(),
+			want: statusWindow{Status: models.ON, From: at(15, 12, 0), To: at(15, 16, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := windowAt(periods, items, tt.t)
+			if err != nil || !ok {
+				t.Fatalf("windowAt() = %v, %v, want window", ok, err)
+			}
+			if got.Status != tt.want.Status || !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) ||
+				got.Open != tt.want.Open {
+				t.Errorf("windowAt() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok, _ := windowAt(periods[:2], items[:2], at(15, 9, 0)); ok {
+		t.Errorf("windowAt() found window for time not covered by periods")
+	}
+	if _, _, err := windowAt([]models.Period{{From: "0:00", To: "04:00"}}, items[:1], at(15, 1, 0)); err == nil {
+		t.Errorf("windowAt() accepted malformed period")
+	}
+}
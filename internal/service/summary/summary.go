@@ -0,0 +1,259 @@
+package summary
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
+)
+
+const dateFormat = "2006-01-02"
+
+// sendHour is Kyiv hour the summary is sent at
+const sendHour = 22
+
+type Repository interface {
+	Get() (models.DailySummary, error)
+	Put(s models.DailySummary) error
+}
+
+type SubscriptionStats interface {
+	Stats() (models.SubscriptionStats, error)
+	PurgeLog() ([]models.PurgeEvent, error)
+}
+
+type AdminNotifier interface {
+	NotifyAdmins(msg string)
+}
+
+// Counters counts sent messages in memory until they are collected by Service.Run
+type Counters struct {
+	mx     sync.Mutex
+	sent   int
+	failed int
+}
+
+func (c *Counters) CountSend(delivered bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if delivered {
+		c.sent++
+	} else {
+		c.failed++
+	}
+}
+
+// take returns counts since the previous call
+func (c *Counters) take() (sent, failed int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	sent, failed = c.sent, c.failed
+	c.sent, c.failed = 0, 0
+	return sent, failed
+}
+
+func NewCounters() *Counters {
+	return &Counters{}
+}
+
+// Service sends a summary of the day to admins in the evening. Counters are persisted on every run, so restart loses
+// at most one run interval of them
+type Service struct {
+	repo     Repository
+	counters *Counters
+	stats    SubscriptionStats
+	notifier AdminNotifier
+	clock    clock.Clock
+	location *time.Location
+
+	mx    sync.Mutex
+	state models.DailySummary
+}
+
+// Run resets counters on a new Kyiv day, sends the summary with the given scheduler task statuses once after sendHour
+// and persists counters. The previous day is flushed before the reset and its summary is sent late if it was missed
+func (s *Service) Run(tasks []models.TaskStatus) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	sent, failed := s.counters.take()
+	s.state.Sent += sent
+	s.state.Failed += failed
+
+	now := s.clock.Now().In(s.location)
+	today := now.Format(dateFormat)
+	if s.state.Date != today {
+		// counters of the interval before midnight belong to the previous day, so it is flushed before the reset
+		if err := s.flush(tasks); err != nil {
+			return err
+		}
+
+		stats, err := s.stats.Stats()
+		if err != nil {
+			return fmt.Errorf("failed to get subscription stats: %w", err)
+		}
+		s.state = models.DailySummary{Date: today, TotalAtStart: stats.Total}
+	}
+
+	if now.Hour() >= sendHour && !s.state.SummarySent {
+		if err := s.notify(tasks); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.Put(s.state); err != nil {
+		return fmt.Errorf("failed to persist daily summary: %w", err)
+	}
+	return nil
+}
+
+// flush sends the summary of the previous day if it was not sent yet and persists its final counters
+func (s *Service) flush(tasks []models.TaskStatus) error {
+	if s.state.Date == "" {
+		return nil
+	}
+
+	if !s.state.SummarySent {
+		if err := s.notify(tasks); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.Put(s.state); err != nil {
+		return fmt.Errorf("failed to persist daily summary: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) notify(tasks []models.TaskStatus) error {
+	msg, err := s.render(tasks)
+	if err != nil {
+		return fmt.Errorf("failed to render daily summary: %w", err)
+	}
+	s.notifier.NotifyAdmins(msg)
+	s.state.SummarySent = true
+	return nil
+}
+
+func (s *Service) render(tasks []models.TaskStatus) (string, error) {
+	date, err := time.ParseInLocation(dateFormat, s.state.Date, s.location)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse summary date %q: %w", s.state.Date, err)
+	}
+
+	stats, err := s.stats.Stats()
+	if err != nil {
+		return "", fmt.Errorf("failed to get subscription stats: %w", err)
+	}
+	gained := 0
+	if len(stats.ByDay) > 0 && stats.ByDay[len(stats.ByDay)-1].Date == s.state.Date {
+		gained = stats.ByDay[len(stats.ByDay)-1].Count
+	}
+	lost := s.state.TotalAtStart + gained - stats.Total
+
+	events, err := s.stats.PurgeLog()
+	if err != nil {
+		return "", fmt.Errorf("failed to get purge log: %w", err)
+	}
+	purges := make(map[models.PurgeAction]int)
+	for _, e := range events {
+		if e.At.In(s.location).Format(dateFormat) == s.state.Date {
+			purges[e.Action]++
+		}
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "📊 Підсумок дня %s\n\n", date.Format("02.01"))
+	fmt.Fprintf(&buf, "Підписки: +%d / -%d (всього %d)\n", gained, lost, stats.Total)
+	fmt.Fprintf(&buf, "Надіслано повідомлень: %d, помилок: %d\n", s.state.Sent, s.state.Failed)
+	fmt.Fprintf(&buf, "Заблокували бота: вимкнено %d, видалено %d\n",
+		purges[models.PurgeActionDisabled], purges[models.PurgeActionPurged])
+
+	failing := make([]string, 0)
+	for _, status := range tasks {
+		if status.LastError != "" {
+			failing = append(failing, fmt.Sprintf("🔴 %s: %s", status.Name, status.LastError))
+		}
+		if status.Skipped > 0 {
+			failing = append(failing, fmt.Sprintf("🟡 %s: пропущено запусків %d", status.Name, status.Skipped))
+		}
+	}
+	if len(failing) == 0 {
+		buf.WriteString("Задачі: 🟢 без помилок")
+	} else {
+		buf.WriteString("Задачі:\n" + strings.Join(failing, "\n"))
+	}
+
+	return buf.String(), nil
+}
+
+type MessageSender interface {
+	Send(chatID int64, msg string, silent bool) (int, error)
+	SendUpdate(chatID int64, msg string, groups []string, silent bool) (int, error)
+	SendChooseGroup(chatID int64, msg string, silent bool) (int, error)
+	Delete(chatID int64, messageID int) error
+	Edit(chatID int64, messageID int, msg string) error
+	Pin(chatID int64, messageID int) error
+}
+
+// CountingSender counts messages sent through the wrapped sender. Message to the chat which blocked the bot is not
+// delivered, while the sender reports it with zero message ID and no error
+type CountingSender struct {
+	MessageSender
+	counters *Counters
+}
+
+func (s *CountingSender) Send(chatID int64, msg string, silent bool) (int, error) {
+	messageID, err := s.MessageSender.Send(chatID, msg, silent)
+	s.counters.CountSend(err == nil && messageID != 0)
+	return messageID, err
+}
+
+func (s *CountingSender) SendUpdate(chatID int64, msg string, groups []string, silent bool) (int, error) {
+	messageID, err := s.MessageSender.SendUpdate(chatID, msg, groups, silent)
+	s.counters.CountSend(err == nil && messageID != 0)
+	return messageID, err
+}
+
+func (s *CountingSender) SendChooseGroup(chatID int64, msg string, silent bool) (int, error) {
+	messageID, err := s.MessageSender.SendChooseGroup(chatID, msg, silent)
+	s.counters.CountSend(err == nil && messageID != 0)
+	return messageID, err
+}
+
+func NewCountingSender(sender MessageSender, counters *Counters) *CountingSender {
+	return &CountingSender{
+		MessageSender: sender,
+		counters:      counters,
+	}
+}
+
+func NewSummaryService(
+	repo Repository, counters *Counters, stats SubscriptionStats, notifier AdminNotifier, clk clock.Clock,
+) (*Service, error) {
+	state, err := repo.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daily summary: %w", err)
+	}
+
+	location, err := clock.Kyiv()
+	if err != nil {
+		slog.Error("failed to load kyiv location", "error", err)
+		return nil, fmt.Errorf("failed to load kyiv location: %w", err)
+	}
+
+	return &Service{
+		repo:     repo,
+		counters: counters,
+		stats:    stats,
+		notifier: notifier,
+		clock:    clk,
+		location: location,
+		state:    state,
+	}, nil
+}
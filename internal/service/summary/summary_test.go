@@ -0,0 +1,146 @@
+package summary
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
+)
+
+type memoryRepo struct {
+	state models.DailySummary
+}
+
+func (r *memoryRepo) Get() (models.DailySummary, error) { return r.state, nil }
+
+func (r *memoryRepo) Put(s models.DailySummary) error {
+	r.state = s
+	return nil
+}
+
+type fakeStats struct {
+	stats  models.SubscriptionStats
+	events []models.PurgeEvent
+}
+
+func (s *fakeStats) Stats() (models.SubscriptionStats, error) { return s.stats, nil }
+
+func (s *fakeStats) PurgeLog() ([]models.PurgeEvent, error) { return s.events, nil }
+
+type fakeNotifier struct {
+	msgs []string
+}
+
+func (n *fakeNotifier) NotifyAdmins(msg string) { n.msgs = append(n.msgs, msg) }
+
+type fakeSender struct {
+	MessageSender
+	messageID int
+	err       error
+}
+
+func (s *fakeSender) Send(int64, string, bool) (int, error) { return s.messageID, s.err }
+
+func TestService_Run(t *testing.T) {
+	kyiv := clock.MustKyiv()
+	clk := clock.NewMock(time.Date(2024, 6, 10, 0, 1, 0, 0, kyiv))
+	repo := &memoryRepo{state: models.DailySummary{Date: "2024-06-09", Sent: 100, SummarySent: true}}
+	stats := &fakeStats{stats: models.SubscriptionStats{Total: 10}}
+	notifier := &fakeNotifier{}
+	counters := NewCounters()
+	s, err := NewSummaryService(repo, counters, stats, notifier, clk)
+	if err != nil {
+		t.Fatalf("NewSummaryService() error = %v", err)
+	}
+
+	if err = s.Run(nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if repo.state.Date != "2024-06-10" || repo.state.Sent != 0 || repo.state.TotalAtStart != 10 {
+		t.Errorf("state after midnight = %+v, want reset counters", repo.state)
+	}
+
+	delivered := NewCountingSender(&fakeSender{messageID: 1}, counters)
+	blocked := NewCountingSender(&fakeSender{}, counters)
+	failing := NewCountingSender(&fakeSender{err: errors.New("boom")}, counters)
+	for _, sender := range []*CountingSender{delivered, delivered, delivered, blocked, failing} {
+		_, _ = sender.Send(1, "msg", false)
+	}
+	stats.stats = models.SubscriptionStats{
+		Total: 11,
+		ByDay: []models.DayCount{{Date: "2024-06-09", Count: 5}, {Date: "2024-06-10", Count: 2}},
+	}
+	stats.events = []models.PurgeEvent{
+		{Action: models.PurgeActionDisabled, At: time.Date(2024, 6, 10, 12, 0, 0, 0, kyiv)},
+		{Action: models.PurgeActionPurged, At: time.Date(2024, 6, 9, 12, 0, 0, 0, kyiv)},
+	}
+
+	clk.Advance(21 * time.Hour)
+	if err = s.Run(nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(notifier.msgs) != 0 {
+		t.Fatalf("summary is sent before %d:00", sendHour)
+	}
+
+	clk.Advance(time.Hour)
+	tasks := []models.TaskStatus{{Name: "refresh_table", LastError: "parse failed"}, {Name: "send_updates"}}
+	for i := 0; i < 2; i++ {
+		if err = s.Run(tasks); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+	if len(notifier.msgs) != 1 {
+		t.Fatalf("summaries sent = %d, want 1", len(notifier.msgs))
+	}
+	for _, want := range []string{
+		"Підписки: +2 / -1 (всього 11)",
+		"Надіслано повідомлень: 3, помилок: 2",
+		"вимкнено 1, видалено 0",
+		"🔴 refresh_table: parse failed",
+	} {
+		if !strings.Contains(notifier.msgs[0], want) {
+			t.Errorf("summary %q doesn't contain %q", notifier.msgs[0], want)
+		}
+	}
+	if repo.state.Sent != 3 || !repo.state.SummarySent {
+		t.Errorf("persisted state = %+v", repo.state)
+	}
+}
+
+func TestService_RunFlushesPreviousDay(t *testing.T) {
+	kyiv := clock.MustKyiv()
+	clk := clock.NewMock(time.Date(2024, 6, 10, 0, 1, 0, 0, kyiv))
+	repo := &memoryRepo{state: models.DailySummary{Date: "2024-06-09", Sent: 100}}
+	stats := &fakeStats{stats: models.SubscriptionStats{Total: 10}}
+	notifier := &fakeNotifier{}
+	counters := NewCounters()
+	s, err := NewSummaryService(repo, counters, stats, notifier, clk)
+	if err != nil {
+		t.Fatalf("NewSummaryService() error = %v", err)
+	}
+
+	// sent before midnight, but collected by the first run of the new day
+	sender := NewCountingSender(&fakeSender{messageID: 1}, counters)
+	for i := 0; i < 5; i++ {
+		_, _ = sender.Send(1, "msg", false)
+	}
+
+	if err = s.Run(nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(notifier.msgs) != 1 {
+		t.Fatalf("summaries sent = %d, want 1 for the missed day", len(notifier.msgs))
+	}
+	for _, want := range []string{"Підсумок дня 09.06", "Надіслано повідомлень: 105, помилок: 0"} {
+		if !strings.Contains(notifier.msgs[0], want) {
+			t.Errorf("summary %q doesn't contain %q", notifier.msgs[0], want)
+		}
+	}
+	if repo.state.Date != "2024-06-10" || repo.state.Sent != 0 || repo.state.SummarySent {
+		t.Errorf("state after midnight = %+v, want reset counters", repo.state)
+	}
+}
@@ -0,0 +1,141 @@
+package suspension
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type Repository interface {
+	Get() (map[string]models.GroupSuspension, error)
+	Put(groups map[string]models.GroupSuspension) error
+}
+
+type SubscriptionRepository interface {
+	GetAll() ([]models.Subscription, error)
+}
+
+type NotificationRepository interface {
+	Put(n models.Notification) (models.Notification, error)
+}
+
+// Service keeps suspended groups in memory as they are checked for every subscription on every updates tick
+type Service struct {
+	repo             Repository
+	subRepo          SubscriptionRepository
+	notificationRepo NotificationRepository
+
+	mx     sync.RWMutex
+	groups map[string]models.GroupSuspension
+}
+
+func (s *Service) IsSuspended(groupNum string) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	_, ok := s.groups[groupNum]
+	return ok
+}
+
+// Suspended returns suspended groups ordered by suspension time
+func (s *Service) Suspended() []models.GroupSuspension {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	res := make([]models.GroupSuspension, 0, len(s.groups))
+	for _, g := range s.groups {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Since.Before(res[j].Since)
+	})
+	return res
+}
+
+// Suspend stops updates of the group. If reason is provided, it is queued as a one-time explanation to the group
+// subscribers
+func (s *Service) Suspend(groupNum, reason string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.groups[groupNum]; ok {
+		return nil
+	}
+
+	groups := s.copyGroups()
+	groups[groupNum] = models.GroupSuspension{Group: groupNum, Reason: reason, Since: time.Now()}
+	if err := s.repo.Put(groups); err != nil {
+		return fmt.Errorf("failed to put suspended groups: %w", err)
+	}
+	s.groups = groups
+
+	if reason != "" {
+		s.notifySubscribers(groupNum, fmt.Sprintf("⚠️ Оновлення графіку для групи %s тимчасово призупинено: %s",
+			groupNum, reason))
+	}
+	return nil
+}
+
+// Resume lifts suspension of the group. Subscribers get the latest schedule with the next updates
+func (s *Service) Resume(groupNum string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.groups[groupNum]; !ok {
+		return nil
+	}
+
+	groups := s.copyGroups()
+	delete(groups, groupNum)
+	if err := s.repo.Put(groups); err != nil {
+		return fmt.Errorf("failed to put suspended groups: %w", err)
+	}
+	s.groups = groups
+	return nil
+}
+
+func (s *Service) copyGroups() map[string]models.GroupSuspension {
+	res := make(map[string]models.GroupSuspension, len(s.groups)+1)
+	for k, v := range s.groups {
+		res[k] = v
+	}
+	return res
+}
+
+// notifySubscribers queues the message to the group subscribers. Failures don't affect suspension, so they are
+// only logged
+func (s *Service) notifySubscribers(groupNum, msg string) {
+	subs, err := s.subRepo.GetAll()
+	if err != nil {
+		slog.Error("failed to get subscriptions", "error", err)
+		return
+	}
+
+	for _, sub := range subs {
+		if _, ok := sub.Groups[groupNum]; !ok {
+			continue
+		}
+		if _, err = s.notificationRepo.Put(models.Notification{Target: sub.ChatID, Msg: msg}); err != nil {
+			slog.Error("failed to queue suspension notification", "error", err, "chatID", sub.ChatID)
+		}
+	}
+}
+
+func NewSuspensionService(
+	repo Repository, subRepo SubscriptionRepository, notificationRepo NotificationRepository,
+) (*Service, error) {
+	groups, err := repo.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get suspended groups: %w", err)
+	}
+
+	return &Service{
+		repo:             repo,
+		subRepo:          subRepo,
+		notificationRepo: notificationRepo,
+		groups:           groups,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package suspension
+
+import (
+	"testing"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+type memoryRepo struct {
+	groups map[string]models.GroupSuspension
+}
+
+func (r *memoryRepo) Get() (map[string]models.GroupSuspension, error) {
+	res := make(map[string]models.GroupSuspension, len(r.groups))
+	for k, v := range r.groups {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (r *memoryRepo) Put(groups map[string]models.GroupSuspension) error {
+	r.groups = groups
+	return nil
+}
+
+type memorySubscriptions []models.Subscription
+
+func (r memorySubscriptions) GetAll() ([]models.Subscription, error) {
+	return r, nil
+}
+
+type memoryNotifications struct {
+	queued []models.Notification
+}
+
+func (r *memoryNotifications) Put(n models.Notification) (models.Notification, error) {
+	r.queued = append(r.queued, n)
+	return n, nil
+}
+
+func TestService_SuspendResume(t *testing.T) {
+	repo := &memoryRepo{groups: make(map[string]models.GroupSuspension)}
+	subs := memorySubscriptions{
+		{ChatID: 1, Groups: map[string]string{"5": ""}},
+		{ChatID: 2, Groups: map[string]string{"5": "", "6": ""}},
+		{ChatID: 3, Groups: map[string]string{"6": ""}},
+	}
+	notifications := &memoryNotifications{}
+	s, err := NewSuspensionService(repo, subs, notifications)
+	if err != nil {
+		t.Fatalf("NewSuspensionService() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = s.Suspend("5", "некоректні дані"); err != nil {
+			t.Fatalf("Suspend() error = %v", err)
+		}
+	}
+	if len(notifications.queued) != 2 {
+		t.Errorf("queued notifications = %v, want one per group subscriber", notifications.queued)
+	}
+	for _, n := range notifications.queued {
+		if n.Target == 3 {
+			t.Errorf("notification queued for chat not subscribed to the group")
+		}
+	}
+
+	// suspension survives restart
+	restarted, err := NewSuspensionService(repo, subs, notifications)
+	if err != nil {
+		t.Fatalf("NewSuspensionService() error = %v", err)
+	}
+	if !restarted.IsSuspended("5") || restarted.IsSuspended("6") {
+		t.Errorf("Suspended() after restart = %v, want only group 5", restarted.Suspended())
+	}
+
+	if err = restarted.Resume("5"); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if restarted.IsSuspended("5") || len(repo.groups) != 0 {
+		t.Errorf("group 5 is still suspended after Resume(), stored = %v", repo.groups)
+	}
+
+	// suspension without reason is silent
+	if err = restarted.Suspend("6", ""); err != nil {
+		t.Fatalf("Suspend() error = %v", err)
+	}
+	if len(notifications.queued) != 2 {
+		t.Errorf("queued notifications = %d, want none for suspension without reason", len(notifications.queued))
+	}
+}
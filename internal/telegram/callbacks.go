@@ -0,0 +1,34 @@
+package telegram
+
+import "github.com/Roma7-7-7/sso-notifier/models"
+
+// Callback uniques of inline buttons. Telebot routes callbacks by them, so markups and handlers must use the same
+// constants
+const (
+	scheduleCallback          = "schedule"
+	chooseOtherGroupCallback  = "choose_other_group"
+	settingsCallback          = "settings"
+	unsubscribeCallback       = "unsubscribe"
+	subscribeCallback         = "subscribe"
+	restoreCallback           = "restore"
+	backCallback              = "back"
+	footerGroupCallback       = "footer_group"
+	footerMuteCallback        = "footer_mute"
+	footerUnsubscribeCallback = "footer_unsubscribe"
+	resyncStateCallback       = "resync_state"
+
+	subscribeGroupCallbackPrefix = "subscribe_group_"
+	toggleSettingCallbackPrefix  = "toggle_"
+)
+
+func subscribeGroupCallback(groupNum string) string {
+	return subscribeGroupCallbackPrefix + groupNum
+}
+
+func toggleSettingCallback(setting models.Setting) string {
+	return toggleSettingCallbackPrefix + string(setting)
+}
+
+func helpCallback(sectionKey string) string {
+	return helpCallbackPrefix + sectionKey
+}
@@ -0,0 +1,71 @@
+package telegram
+
+import (
+	"testing"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+// TestRoutes_AllButtonsRouted makes sure every button the markups can emit has a handler, as telebot silently
+// ignores callbacks with unknown uniques
+func TestRoutes_AllButtonsRouted(t *testing.T) {
+	bot, err := tb.NewBot(tb.Settings{Offline: true, Synchronous: true})
+	if err != nil {
+		t.Fatalf("NewBot() error = %v", err)
+	}
+	b := &SSOBot{
+		bot:     bot,
+		markups: newMarkups(12, newUpdateFooter()),
+		admins:  newAdminSet(nil),
+	}
+
+	var routed string
+	// recorder is registered before routes, so it runs first and handlers are never called
+	bot.Use(func(tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			routed = c.Callback().Unique
+			return nil
+		}
+	})
+	b.routes()
+
+	m := b.markups
+	markups := []*tb.ReplyMarkup{
+		m.main.subscribed.ReplyMarkup,
+		m.main.unsubscribed.ReplyMarkup,
+		m.main.restorable.ReplyMarkup,
+		m.groups.ReplyMarkup,
+		m.settingsMarkup(models.Settings{}),
+		m.help.menu,
+		m.footer.markup([]string{"1", "7"}),
+		m.footer.groupMarkup("7"),
+		m.resyncState.ReplyMarkup,
+	}
+	for _, markup := range m.help.bySection {
+		markups = append(markups, markup)
+	}
+
+	buttons := 0
+	for _, markup := range markups {
+		for _, row := range markup.InlineKeyboard {
+			for _, btn := range row {
+				buttons++
+				// callback data is built the way telebot builds it when the markup is sent
+				data := "\f" + btn.Unique
+				if btn.Data != "" {
+					data += "|" + btn.Data
+				}
+				routed = ""
+				bot.ProcessUpdate(tb.Update{Callback: &tb.Callback{Sender: &tb.User{ID: 1}, Data: data}})
+				if routed != btn.Unique {
+					t.Errorf("button %q with unique %q is not routed", btn.Text, btn.Unique)
+				}
+			}
+		}
+	}
+	if buttons == 0 {
+		t.Fatalf("no buttons found in markups")
+	}
+}
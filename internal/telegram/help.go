@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	tb "gopkg.in/telebot.v3"
+)
+
+const helpCallbackPrefix = "help_"
+
+const helpIntro = "Довідка. Оберіть розділ:"
+
+type helpSection struct {
+	key   string
+	title string
+	text  string
+}
+
+// helpCatalog is the content of /help sections in the order they are shown in the menu
+var helpCatalog = []helpSection{
+	{
+		key:   "subscriptions",
+		title: "Підписки",
+		text: "📋 Підписки\n\n" +
+			"Оберіть одну або кілька груп через /subscribe, щоб отримувати графік відключень для них. " +
+			"Номер групи можна дізнатись у постачальника електроенергії.\n\n" +
+			"Відписатись можна через /unsubscribe. Налаштування зберігаються ще деякий час, " +
+			"тож після повторного /start їх можна відновити. Видалити всі дані одразу можна через /deletemydata.\n\n" +
+			"Щоб перенести підписку на інший акаунт Telegram, скористайтесь /export.",
+	},
+	{
+		key:   "notifications",
+		title: "Сповіщення",
+		text: "🔔 Сповіщення\n\n" +
+			"Бот надсилає графік щоразу, коли він змінюється для ваших груп. " +
+			"Якщо текст графіку не змінився, повідомлення не надсилається. " +
+			"Поточний графік можна отримати будь-коли через /schedule.\n\n" +
+			"/mute 18:00 або /mute 2h тимчасово вимикає сповіщення, /unmute вмикає їх знову. " +
+			"У /settings можна вимкнути звук для всіх повідомлень та зберігати попередні графіки.",
+	},
+	{
+		key:   "formats",
+		title: "Формати",
+		text: "🕒 Формати\n\n" +
+			"🟢 — заживлено, 🟡 — можливо заживлено, 🔴 — відключено.\n\n" +
+			"У /settings можна обрати 24- або 12-годинний формат часу, а також показувати весь день " +
+			"замість лише періодів, що ще не минули. Внизу повідомлення вказано, коли графік було оновлено.",
+	},
+	{
+		key:   "privacy",
+		title: "Конфіденційність",
+		text: "🔒 Конфіденційність\n\n" +
+			"Бот зберігає лише ідентифікатор чату, обрані групи та налаштування. " +
+			"Після відписки вони видаляються, коли мине строк зберігання для відновлення. " +
+			"Якщо бота заблоковано, підписка вимикається та згодом видаляється.",
+	},
+}
+
+type helpMarkups struct {
+	btns map[string]tb.Btn
+	// bySection holds markup per opened section, the current section button is marked
+	bySection map[string]*tb.ReplyMarkup
+	menu      *tb.ReplyMarkup
+}
+
+func newHelpMarkups() helpMarkups {
+	res := helpMarkups{
+		btns:      make(map[string]tb.Btn, len(helpCatalog)),
+		bySection: make(map[string]*tb.ReplyMarkup, len(helpCatalog)),
+	}
+	for _, section := range helpCatalog {
+		res.btns[section.key] = tb.Btn{Unique: helpCallback(section.key), Text: section.title}
+	}
+
+	res.menu = res.build("")
+	for _, section := range helpCatalog {
+		res.bySection[section.key] = res.build(section.key)
+	}
+	return res
+}
+
+func (m helpMarkups) build(current string) *tb.ReplyMarkup {
+	markup := &tb.ReplyMarkup{}
+	rows := make([]tb.Row, 0, len(helpCatalog))
+	for _, section := range helpCatalog {
+		btn := m.btns[section.key]
+		if section.key == current {
+			btn.Text = "• " + btn.Text + " •"
+		}
+		rows = append(rows, markup.Row(btn))
+	}
+	markup.Inline(rows...)
+	return markup
+}
+
+// HelpHandler shows the help menu, every section is opened in the same message
+func (b *SSOBot) HelpHandler(c tb.Context) error {
+	return c.Send(helpIntro, b.markups.help.menu)
+}
+
+func (b *SSOBot) HelpSectionHandler(section helpSection) func(c tb.Context) error {
+	return func(c tb.Context) error {
+		return c.Edit(section.text, b.markups.help.bySection[section.key])
+	}
+}
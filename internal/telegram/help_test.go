@@ -0,0 +1,41 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelpMarkups(t *testing.T) {
+	m := newHelpMarkups()
+
+	uniques := make(map[string]bool)
+	for _, section := range helpCatalog {
+		btn, ok := m.btns[section.key]
+		if !ok {
+			t.Fatalf("no button for help section=%s", section.key)
+		}
+		if !strings.HasPrefix(btn.Unique, helpCallbackPrefix) {
+			t.Errorf("help section=%s button unique=%s has no %s prefix", section.key, btn.Unique, helpCallbackPrefix)
+		}
+		if uniques[btn.Unique] {
+			t.Errorf("duplicate help button unique=%s", btn.Unique)
+		}
+		uniques[btn.Unique] = true
+		if section.text == "" {
+			t.Errorf("help section=%s has no text", section.key)
+		}
+
+		markup := m.bySection[section.key]
+		if len(markup.InlineKeyboard) != len(helpCatalog) {
+			t.Fatalf("help section=%s markup has %d rows, want %d",
+				section.key, len(markup.InlineKeyboard), len(helpCatalog))
+		}
+		for i, row := range markup.InlineKeyboard {
+			current := strings.HasPrefix(row[0].Text, "•")
+			if want := helpCatalog[i].key == section.key; current != want {
+				t.Errorf("help section=%s button=%s marked as current = %t, want %t",
+					section.key, row[0].Text, current, want)
+			}
+		}
+	}
+}
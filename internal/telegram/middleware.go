@@ -0,0 +1,248 @@
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const defaultStaleUpdatesCutoff = 10 * time.Minute
+
+// backlogWindow is the period after startup during which callbacks are considered to be a part of the backlog.
+// Callback queries don't carry a timestamp, so there is no other way to tell whether they are stale.
+const backlogWindow = 10 * time.Second
+
+type nowFunc func() time.Time
+
+// dropStaleUpdates drops messages older than cutoff at processing time. Callbacks received right after startup
+// are answered with a toast asking to repeat the action instead of being processed.
+func dropStaleUpdates(cutoff time.Duration, startedAt time.Time, now nowFunc) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if cutoff <= 0 {
+				return next(c)
+			}
+
+			if cb := c.Callback(); cb != nil {
+				if now().Sub(startedAt) > backlogWindow {
+					return next(c)
+				}
+				slog.Info("dropping callback received right after startup", "userID", c.Sender().ID, "data", cb.Data)
+				return c.Respond(&tb.CallbackResponse{Text: "Повторіть, будь ласка"})
+			}
+
+			msg := c.Message()
+			if msg == nil {
+				return next(c)
+			}
+			if age := now().Sub(msg.Time()); age > cutoff {
+				slog.Info("dropping stale update", "chatID", msg.Chat.ID, "age", age, "text", msg.Text)
+				return nil
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// chatAdminsOnly restricts handler to chat administrators when used in group chats. Private chats are not restricted.
+func chatAdminsOnly(bot *tb.Bot) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if c.Chat() == nil || c.Chat().Type == tb.ChatPrivate {
+				return next(c)
+			}
+
+			member, err := bot.ChatMemberOf(c.Chat(), c.Sender())
+			if err != nil {
+				slog.Error("failed to get chat member", "error", err, "chatID", c.Chat().ID, "userID", c.Sender().ID)
+				return c.Send("Щось пішло не так. Будь ласка, спробуйте пізніше.")
+			}
+			if member.Role == tb.Administrator || member.Role == tb.Creator {
+				return next(c)
+			}
+
+			const msg = "Змінювати підписку можуть лише адміністратори чату"
+			if c.Callback() != nil {
+				return c.Respond(&tb.CallbackResponse{Text: msg})
+			}
+			return c.Send(msg)
+		}
+	}
+}
+
+// adminSet is a list of bot admins which can be replaced at runtime
+type adminSet struct {
+	mx  sync.RWMutex
+	ids map[int64]struct{}
+}
+
+func (s *adminSet) contains(id int64) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	_, ok := s.ids[id]
+	return ok
+}
+
+func (s *adminSet) set(adminIDs []int64) {
+	ids := make(map[int64]struct{}, len(adminIDs))
+	for _, id := range adminIDs {
+		ids[id] = struct{}{}
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.ids = ids
+}
+
+func newAdminSet(adminIDs []int64) *adminSet {
+	res := &adminSet{}
+	res.set(adminIDs)
+	return res
+}
+
+// adminsOnly silently ignores updates from anyone except bot admins
+func adminsOnly(admins *adminSet) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if !admins.contains(c.Sender().ID) {
+				slog.Warn("admin command from non admin user", "userID", c.Sender().ID, "text", c.Text())
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
+
+// maintenanceNotice sends maintenance banner before handler response while maintenance mode is on
+func maintenanceNotice(maintenance MaintenanceService) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if maintenance.IsEnabled() {
+				if err := c.Send(maintenance.Banner()); err != nil {
+					return err
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// maintenanceBlock responds with maintenance banner only while maintenance mode is on
+func maintenanceBlock(maintenance MaintenanceService) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if !maintenance.IsEnabled() {
+				return next(c)
+			}
+			if c.Callback() != nil {
+				return c.Respond(&tb.CallbackResponse{Text: maintenance.Banner(), ShowAlert: true})
+			}
+			return c.Send(maintenance.Banner())
+		}
+	}
+}
+
+const readOnlyMsg = "Тимчасово недоступно"
+
+// readOnlyBlock rejects handlers which change data when the bot serves a read only copy of the database
+func readOnlyBlock(readOnly bool) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if !readOnly {
+				return next(c)
+			}
+			if c.Callback() != nil {
+				return c.Respond(&tb.CallbackResponse{Text: readOnlyMsg, ShowAlert: true})
+			}
+			return c.Send(readOnlyMsg)
+		}
+	}
+}
+
+const callbackDebounceWindow = 2 * time.Second
+
+// maxCallbackGuardKeys bounds memory used by callbackGuard. Keys over the limit are not guarded
+const maxCallbackGuardKeys = 10000
+
+// callbackGuard remembers recently received callbacks, so rapid double taps on the same button are processed once
+type callbackGuard struct {
+	window time.Duration
+	now    nowFunc
+
+	mx   sync.Mutex
+	seen map[string]time.Time
+}
+
+// acquire returns false if the same key was acquired less than window ago
+func (g *callbackGuard) acquire(key string) bool {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	now := g.now()
+	if at, ok := g.seen[key]; ok && now.Sub(at) < g.window {
+		return false
+	}
+
+	if len(g.seen) >= maxCallbackGuardKeys {
+		for k, at := range g.seen {
+			if now.Sub(at) >= g.window {
+				delete(g.seen, k)
+			}
+		}
+		if len(g.seen) >= maxCallbackGuardKeys {
+			return true
+		}
+	}
+	g.seen[key] = now
+	return true
+}
+
+func newCallbackGuard(window time.Duration, now nowFunc) *callbackGuard {
+	return &callbackGuard{
+		window: window,
+		now:    now,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// debounceCallbacks answers repeated identical callbacks from the same chat with a toast instead of processing them
+func debounceCallbacks(guard *callbackGuard) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			cb := c.Callback()
+			if cb == nil || c.Chat() == nil {
+				return next(c)
+			}
+			// router moves the button name to Unique and leaves only the payload in Data, which is empty for most buttons
+			if guard.acquire(fmt.Sprintf("%d:%s|%s", c.Chat().ID, cb.Unique, cb.Data)) {
+				return next(c)
+			}
+			slog.Debug("dropping repeated callback", "chatID", c.Chat().ID, "unique", cb.Unique, "data", cb.Data)
+			return c.Respond(&tb.CallbackResponse{Text: "Вже обробляється"})
+		}
+	}
+}
+
+const scheduleCooldown = 20 * time.Second
+
+// throttleChat lets the chat run the command once per guard window, e.g. to protect the store from users holding
+// down a button that renders the schedule. Repeated callbacks are answered with a toast and commands with a message
+func throttleChat(guard *callbackGuard, command string) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if c.Chat() == nil || guard.acquire(fmt.Sprintf("%d:%s", c.Chat().ID, command)) {
+				return next(c)
+			}
+			slog.Debug("throttling command", "chatID", c.Chat().ID, "command", command)
+			const msg = "Забагато запитів. Спробуйте трохи пізніше."
+			if c.Callback() != nil {
+				return c.Respond(&tb.CallbackResponse{Text: msg})
+			}
+			return c.Send(msg)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package telegram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+// debounceSubscriptionService records calls of the handlers behind debounced buttons
+type debounceSubscriptionService struct {
+	SubscriptionService
+	mx    sync.Mutex
+	calls []string
+}
+
+func (s *debounceSubscriptionService) record(call string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *debounceSubscriptionService) ToggleSetting(_ int64, setting models.Setting) (models.Subscription, error) {
+	s.record(string(setting))
+	return models.Subscription{}, nil
+}
+
+func (s *debounceSubscriptionService) SubscribeToGroup(
+	_ int64, _ models.ChatType, number, _ string,
+) (models.Subscription, error) {
+	s.record("group_" + number)
+	return models.Subscription{OnboardingCompleted: true}, nil
+}
+
+func (s *debounceSubscriptionService) Restore(int64, models.ChatType) (models.Subscription, error) {
+	s.record("restore")
+	return models.Subscription{}, models.ErrSubscriptionNotFound
+}
+
+type disabledMaintenance struct {
+	MaintenanceService
+}
+
+func (disabledMaintenance) IsEnabled() bool { return false }
+
+func TestRoutes_DebounceCallbacks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	defer server.Close()
+
+	bot, err := tb.NewBot(tb.Settings{URL: server.URL, Token: "token", Offline: true, Synchronous: true,
+		OnError: func(error, tb.Context) {}})
+	if err != nil {
+		t.Fatalf("NewBot() error = %v", err)
+	}
+	service := &debounceSubscriptionService{}
+	b := &SSOBot{
+		bot:                 bot,
+		markups:             newMarkups(12, newUpdateFooter()),
+		admins:              newAdminSet(nil),
+		location:            time.UTC,
+		subscriptionService: service,
+		maintenanceService:  disabledMaintenance{},
+		sources:             newPendingSources(),
+	}
+	b.routes()
+
+	tap := func(btn tb.Btn) {
+		data := "\f" + btn.Unique
+		if btn.Data != "" {
+			data += "|" + btn.Data
+		}
+		bot.ProcessUpdate(tb.Update{Callback: &tb.Callback{
+			Sender:  &tb.User{ID: 1},
+			Message: &tb.Message{Chat: &tb.Chat{ID: 1, Type: tb.ChatPrivate}},
+			Data:    data,
+		}})
+	}
+
+	m := b.markups
+	tap(m.settings.toggleBtns[models.SettingAlwaysSilent])
+	tap(m.settings.toggleBtns[models.SettingKeepHistory])
+	tap(m.groups.subscribeGroupBtns["5"])
+	tap(m.main.restorable.restore)
+	// repeated tap within the window is dropped
+	tap(m.settings.toggleBtns[models.SettingKeepHistory])
+
+	want := []string{string(models.SettingAlwaysSilent), string(models.SettingKeepHistory), "group_5", "restore"}
+	if !reflect.DeepEqual(service.calls, want) {
+		t.Errorf("handled callbacks = %v, want %v", service.calls, want)
+	}
+}
+
+func TestCallbackGuard_Bounded(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	guard := newCallbackGuard(callbackDebounceWindow, func() time.Time { return now })
+
+	for i := 0; i < maxCallbackGuardKeys+100; i++ {
+		guard.acquire(strconv.Itoa(i))
+	}
+	if got := len(guard.seen); got != maxCallbackGuardKeys {
+		t.Errorf("guarded keys = %d, want %d", got, maxCallbackGuardKeys)
+	}
+
+	now = now.Add(callbackDebounceWindow)
+	guard.acquire("new")
+	if got := len(guard.seen); got != 1 {
+		t.Errorf("guarded keys after expiration = %d, want 1", got)
+	}
+}
+
+type fakeContext struct {
+	tb.Context
+	chat      *tb.Chat
+	callback  *tb.Callback
+	message   *tb.Message
+	args      []string
+	query     *tb.Query
+	sent      []string
+	answers   []*tb.QueryResponse
+	responses int
+}
+
+func (c *fakeContext) Chat() *tb.Chat         { return c.chat }
+func (c *fakeContext) Callback() *tb.Callback { return c.callback }
+func (c *fakeContext) Message() *tb.Message   { return c.message }
+func (c *fakeContext) Sender() *tb.User       { return &tb.User{ID: c.chat.ID} }
+func (c *fakeContext) Args() []string         { return c.args }
+func (c *fakeContext) Query() *tb.Query       { return c.query }
+
+func (c *fakeContext) Answer(resp *tb.QueryResponse) error {
+	c.answers = append(c.answers, resp)
+	return nil
+}
+
+func (c *fakeContext) Send(what interface{}, _ ...interface{}) error {
+	c.sent = append(c.sent, what.(string))
+	return nil
+}
+
+func (c *fakeContext) Respond(_ ...*tb.CallbackResponse) error {
+	c.responses++
+	return nil
+}
+
+func TestThrottleChat(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	guard := newCallbackGuard(scheduleCooldown, func() time.Time { return now })
+
+	var calls int
+	handler := throttleChat(guard, "schedule")(func(tb.Context) error {
+		calls++
+		return nil
+	})
+
+	command := &fakeContext{chat: &tb.Chat{ID: 1}}
+	button := &fakeContext{chat: &tb.Chat{ID: 1}, callback: &tb.Callback{}}
+	for _, c := range []tb.Context{command, button} {
+		if err := handler(c); err != nil {
+			t.Fatalf("handler() error = %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if button.responses != 1 {
+		t.Errorf("throttled callback responses = %d, want 1", button.responses)
+	}
+
+	if err := handler(&fakeContext{chat: &tb.Chat{ID: 2}}); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	now = now.Add(scheduleCooldown)
+	if err := handler(command); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls after another chat and cooldown = %d, want 3", calls)
+	}
+}
+
+func TestReadOnlyBlock(t *testing.T) {
+	var calls int
+	next := func(tb.Context) error {
+		calls++
+		return nil
+	}
+
+	if err := readOnlyBlock(false)(next)(&fakeContext{chat: &tb.Chat{ID: 1}}); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+
+	command := &fakeContext{chat: &tb.Chat{ID: 1}}
+	button := &fakeContext{chat: &tb.Chat{ID: 1}, callback: &tb.Callback{}}
+	for _, c := range []tb.Context{command, button} {
+		if err := readOnlyBlock(true)(next)(c); err != nil {
+			t.Fatalf("handler() error = %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("calls in read only mode = %d, want 1", calls)
+	}
+	if len(command.sent) != 1 || command.sent[0] != readOnlyMsg {
+		t.Errorf("command replies = %v, want %q", command.sent, readOnlyMsg)
+	}
+	if button.responses != 1 {
+		t.Errorf("callback responses = %d, want 1", button.responses)
+	}
+}
+
+func TestDropStaleUpdates(t *testing.T) {
+	startedAt := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	cutoff := 10 * time.Minute
+
+	message := func(sentAt time.Time) *fakeContext {
+		return &fakeContext{chat: &tb.Chat{ID: 1}, message: &tb.Message{Unixtime: sentAt.Unix(), Chat: &tb.Chat{ID: 1}}}
+	}
+	button := func() *fakeContext {
+		return &fakeContext{chat: &tb.Chat{ID: 1}, callback: &tb.Callback{}}
+	}
+
+	tests := []struct {
+		name      string
+		cutoff    time.Duration
+		now       time.Time
+		c         *fakeContext
+		wantCalls int
+	}{
+		{name: "fresh message", cutoff: cutoff, now: startedAt, c: message(startedAt.Add(-time.Minute)), wantCalls: 1},
+		{name: "message at cutoff", cutoff: cutoff, now: startedAt, c: message(startedAt.Add(-cutoff)), wantCalls: 1},
+		{name: "stale message", cutoff: cutoff, now: startedAt, c: message(startedAt.Add(-cutoff - time.Second))},
+		{name: "stale message without cutoff", now: startedAt, c: message(startedAt.Add(-time.Hour)), wantCalls: 1},
+		{name: "callback right after startup", cutoff: cutoff, now: startedAt.Add(time.Second), c: button()},
+		{
+			name: "callback after backlog window", cutoff: cutoff, now: startedAt.Add(backlogWindow + time.Second),
+			c: button(), wantCalls: 1,
+		},
+		{
+			name: "update without message", cutoff: cutoff, now: startedAt, c: &fakeContext{chat: &tb.Chat{ID: 1}},
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			handler := dropStaleUpdates(tt.cutoff, startedAt, func() time.Time { return tt.now })(func(tb.Context) error {
+				calls++
+				return nil
+			})
+			if err := handler(tt.c); err != nil {
+				t.Fatalf("handler() error = %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.c.callback != nil && tt.wantCalls == 0 && tt.c.responses != 1 {
+				t.Errorf("dropped callback responses = %d, want 1", tt.c.responses)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+const importPayloadPrefix = "import_"
+
+type MigrationService interface {
+	Export(chatID int64) (string, error)
+	Import(chatID int64, chatType models.ChatType, token string) (models.Subscription, error)
+}
+
+// ExportHandler sends a link which subscribes another chat with the same groups and settings, e.g. after switching
+// Telegram account
+func (b *SSOBot) ExportHandler(c tb.Context) error {
+	token, err := b.migrationService.Export(c.Chat().ID)
+	switch {
+	case errors.Is(err, models.ErrSubscriptionNotFound):
+		return c.Send("Ви не підписані на оновлення", b.markups.main.unsubscribed.ReplyMarkup)
+	case errors.Is(err, models.ErrExportDisabled):
+		return c.Send("Перенесення налаштувань недоступне.")
+	case err != nil:
+		slog.Error("failed to export subscription", "error", err)
+		return c.Send("Щось пішло не так. Будь ласка, спробуйте пізніше.")
+	}
+
+	link := "https://t.me/" + b.bot.Me.Username + "?start=" + importPayloadPrefix + token
+	return c.Send("Відкрийте це посилання з нового акаунта протягом 7 днів, щоб перенести групи та налаштування. "+
+		"Посилання можна використати лише один раз.\n\n"+link, tb.NoPreview)
+}
+
+// importSubscription applies configuration from "/start import_<token>" payload
+func (b *SSOBot) importSubscription(c tb.Context, token string) error {
+	sub, err := b.migrationService.Import(c.Chat().ID, models.ChatType(c.Chat().Type), token)
+	switch {
+	case errors.Is(err, models.ErrInvalidExportToken), errors.Is(err, models.ErrExportDisabled):
+		return c.Send("Посилання недійсне.")
+	case errors.Is(err, models.ErrExportTokenExpired):
+		return c.Send("Термін дії посилання минув. Створіть нове через /export.")
+	case errors.Is(err, models.ErrExportTokenUsed):
+		return c.Send("Посилання вже використано. Створіть нове через /export.")
+	case errors.Is(err, models.ErrSubscriptionsLimitReached):
+		return c.Send("Кількість підписок досягла межі. Будь ласка, спробуйте пізніше.")
+	case err != nil:
+		slog.Error("failed to import subscription", "error", err)
+		return c.Send("Не вдалось перенести налаштування. Будь ласка, спробуйте пізніше.")
+	}
+
+	groups := make([]string, 0, len(sub.Groups))
+	for groupNum := range sub.Groups {
+		groups = append(groups, groupNum)
+	}
+	sort.Strings(groups)
+	return c.Send("Налаштування перенесено. Ви підписані на групи: "+strings.Join(groups, ", "),
+		b.markups.main.subscribed.ReplyMarkup)
+}
+
+// guardImport runs "/start import_<token>" through the middlewares of commands which change the subscription, while
+// plain /start passes as is
+func guardImport(middlewares ...tb.MiddlewareFunc) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		guarded := next
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			guarded = middlewares[i](guarded)
+		}
+		return func(c tb.Context) error {
+			if _, ok := parseImportToken(c.Message()); ok {
+				return guarded(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// parseImportToken extracts export token from "/start import_<token>" payload
+func parseImportToken(msg *tb.Message) (string, bool) {
+	if msg == nil || !strings.HasPrefix(msg.Payload, importPayloadPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(msg.Payload, importPayloadPrefix), true
+}
@@ -0,0 +1,41 @@
+package telegram
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestMessageSender_SendToMigratedChat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":false,"error_code":400,` +
+			`"description":"Bad Request: group chat was upgraded to a supergroup chat",` +
+			`"parameters":{"migrate_to_chat_id":-100123456789}}`))
+	}))
+	defer server.Close()
+
+	bot, err := tb.NewBot(tb.Settings{URL: server.URL, Token: "token", Offline: true})
+	if err != nil {
+		t.Fatalf("NewBot() error = %v", err)
+	}
+	var from, to int64
+	sender := &messageSender{
+		bot:            bot,
+		blockedHandler: func(int64) { t.Errorf("migrated chat is reported as blocked") },
+		migratedHandler: func(f, t int64) {
+			from, to = f, t
+		},
+	}
+
+	messageID, err := sender.Send(-123, "msg", false)
+	var migrated tb.GroupError
+	if !errors.As(err, &migrated) || messageID != 0 {
+		t.Errorf("Send() = %d, %v, want group migration error", messageID, err)
+	}
+	if from != -123 || to != -100123456789 {
+		t.Errorf("migrated from %d to %d, want from -123 to -100123456789", from, to)
+	}
+}
@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const sourcePayloadPrefix = "src_"
+const maxPendingSources = 10000
+
+var sourceRegexp = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// pendingSources keeps acquisition source from /start payload until the user picks a group and subscription is
+// created. It is bounded, so the oldest entries are not kept forever by users who never subscribe
+type pendingSources struct {
+	mx      sync.Mutex
+	sources map[int64]string
+	order   []int64
+}
+
+func (s *pendingSources) put(chatID int64, source string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.sources[chatID]; !ok {
+		s.order = append(s.order, chatID)
+	}
+	s.sources[chatID] = source
+
+	for len(s.order) > maxPendingSources {
+		delete(s.sources, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// pop returns and forgets source of the chat. Empty string is returned if there is no source
+func (s *pendingSources) pop(chatID int64) string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	source, ok := s.sources[chatID]
+	if !ok {
+		return ""
+	}
+	delete(s.sources, chatID)
+	for i, id := range s.order {
+		if id == chatID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return source
+}
+
+// parseSource extracts source tag from "/start src_<tag>" payload
+func parseSource(msg *tb.Message) (string, bool) {
+	if msg == nil || !strings.HasPrefix(msg.Payload, sourcePayloadPrefix) {
+		return "", false
+	}
+	source := strings.TrimPrefix(msg.Payload, sourcePayloadPrefix)
+	if !sourceRegexp.MatchString(source) {
+		return "", false
+	}
+	return source, true
+}
+
+func newPendingSources() *pendingSources {
+	return &pendingSources{
+		sources: make(map[int64]string),
+	}
+}
@@ -5,16 +5,25 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	tb "gopkg.in/telebot.v3"
 
 	"github.com/Roma7-7-7/sso-notifier/models"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
 )
 
 type MessageSender interface {
-	Send(chatID int64, msg string) error
+	Send(chatID int64, msg string, silent bool) (int, error)
+	SendUpdate(chatID int64, msg string, groups []string, silent bool) (int, error)
+	SendChooseGroup(chatID int64, msg string, silent bool) (int, error)
+	Delete(chatID int64, messageID int) error
+	Edit(chatID int64, messageID int, msg string) error
+	Pin(chatID int64, messageID int) error
 }
 
 type MessageSenderSetter interface {
@@ -25,55 +34,253 @@ type SubscriptionService interface {
 	GroupsCount() int
 	IsSubscribed(chatID int64) (bool, error)
 	GetSubscriptions() ([]models.Subscription, error)
-	SubscribeToGroup(chatID int64, number string) (models.Subscription, error)
+	GetSubscription(chatID int64) (models.Subscription, bool, error)
+	ToggleSetting(chatID int64, setting models.Setting) (models.Subscription, error)
+	Mute(chatID int64, until time.Time) (models.Subscription, error)
+	Unmute(chatID int64) (models.Subscription, error)
+	MuteGroup(chatID int64, groupNum string, until time.Time) (models.Subscription, error)
+	UnsubscribeFromGroup(chatID int64, groupNum string) (models.Subscription, error)
+	CompleteOnboarding(chatID int64) (models.Subscription, error)
+	Enable(chatID int64) (models.Subscription, error)
+	Tombstone(chatID int64) (models.Tombstone, bool, error)
+	Restore(chatID int64, chatType models.ChatType) (models.Subscription, error)
+	SubscribeToGroup(chatID int64, chatType models.ChatType, number, source string) (models.Subscription, error)
+	Stats() (models.SubscriptionStats, error)
+	PurgeLog() ([]models.PurgeEvent, error)
+	DeliveryLog(chatID int64) ([]models.DeliveryLogEntry, error)
+	RenderSchedule(chatID int64) (string, error)
+	RenderGroupsSchedule(groupNums []string, settings models.Settings) (string, error)
 	Unsubscribe(chatID int64) error
+	DeleteData(chatID int64) error
+	GroupSchedule(groupNum string, twelveHour bool) (string, bool, error)
+	ResyncState() (int, error)
+}
+
+type MaintenanceService interface {
+	IsEnabled() bool
+	Banner() string
+	Enable(text string) error
+	Disable() error
+}
+
+type SuspensionService interface {
+	Suspend(groupNum, reason string) error
+	Resume(groupNum string) error
+	Suspended() []models.GroupSuspension
+}
+
+type ShutdownsService interface {
+	ForceRefreshShutdownsTable() error
+	Revisions() (string, []models.ScheduleRevision, error)
+}
+
+type DBStatsProvider interface {
+	DBStats() (models.DBStats, error)
+}
+
+type TaskStatusProvider interface {
+	TaskStatuses() []models.TaskStatus
 }
 
 type SSOBot struct {
-	bot     *tb.Bot
-	markups *markups
+	bot         *tb.Bot
+	markups     *markups
+	staleCutoff time.Duration
+	admins      *adminSet
+	location    *time.Location
+	// readOnly disables handlers which change data, see SSOBotBuilder.ReadOnly
+	readOnly bool
 
 	subscriptionService SubscriptionService
+	maintenanceService  MaintenanceService
+	suspensionService   SuspensionService
+	shutdownsService    ShutdownsService
+	migrationService    MigrationService
+	taskStatusProvider  TaskStatusProvider
+	dbStatsProvider     DBStatsProvider
+
+	sources *pendingSources
+}
+
+// Stop stops polling updates, so Start returns
+func (b *SSOBot) Stop() {
+	b.bot.Stop()
+}
+
+// SetAdminIDs replaces the list of bot admins, e.g. on configuration reload
+func (b *SSOBot) SetAdminIDs(adminIDs []int64) {
+	b.admins.set(adminIDs)
 }
 
 func (b *SSOBot) Start() {
-	b.bot.Handle("/start", b.StartHandler)
+	b.routes()
+	b.bot.Start()
+}
+
+// routes registers middlewares and handlers of all commands and buttons
+func (b *SSOBot) routes() {
+	b.bot.Use(dropStaleUpdates(b.staleCutoff, time.Now(), time.Now))
+
+	adminOnly := chatAdminsOnly(b.bot)
+	notice := maintenanceNotice(b.maintenanceService)
+	block := maintenanceBlock(b.maintenanceService)
+	writes := readOnlyBlock(b.readOnly)
+	debounce := debounceCallbacks(newCallbackGuard(callbackDebounceWindow, time.Now))
+
+	b.bot.Handle("/start", b.StartHandler, guardImport(adminOnly, writes, block), notice)
 	for _, btn := range b.markups.backToMainBtns() {
 		btn := btn
-		b.bot.Handle(&btn, b.StartHandler)
+		b.bot.Handle(&btn, b.StartHandler, notice)
 	}
 
-	b.bot.Handle("/subscribe", b.ChooseGroupHandler)
+	b.bot.Handle("/subscribe", b.ChooseGroupHandler, adminOnly, writes, block)
 	for _, btn := range b.markups.chooseGroupBtns() {
 		btn := btn
-		b.bot.Handle(&btn, b.ChooseGroupHandler)
+		b.bot.Handle(&btn, b.ChooseGroupHandler, adminOnly, writes, block)
 	}
 
 	for k, btn := range b.markups.subscribeToGroupBtns() {
 		btn := btn
-		b.bot.Handle(&btn, b.SetGroupHandler(k))
+		b.bot.Handle(&btn, b.SetGroupHandler(k), adminOnly, writes, block, debounce)
 	}
 
-	b.bot.Handle("/unsubscribe", b.UnsubscribeHandler)
+	restoreBtn := b.markups.main.restorable.restore
+	b.bot.Handle(&restoreBtn, b.RestoreHandler, adminOnly, writes, block, debounce)
+
+	b.bot.Handle("/settings", b.SettingsHandler, adminOnly, notice)
+	for _, btn := range b.markups.settingsBtns() {
+		btn := btn
+		b.bot.Handle(&btn, b.SettingsHandler, adminOnly, notice)
+	}
+
+	for setting, btn := range b.markups.toggleSettingBtns() {
+		btn := btn
+		b.bot.Handle(&btn, b.ToggleSettingHandler(setting), adminOnly, writes, block, debounce)
+	}
+
+	b.bot.Handle("/unsubscribe", b.UnsubscribeHandler, adminOnly, writes, block)
 	for _, btn := range b.markups.unsubscribeBtns() {
 		btn := btn
-		b.bot.Handle(&btn, b.UnsubscribeHandler)
+		b.bot.Handle(&btn, b.UnsubscribeHandler, adminOnly, writes, block)
 	}
 
-	b.bot.Start()
+	b.bot.Handle("/deletemydata", b.DeleteDataHandler, adminOnly, writes, block)
+
+	scheduleThrottle := throttleChat(newCallbackGuard(scheduleCooldown, time.Now), "schedule")
+	b.bot.Handle("/schedule", b.ScheduleHandler, adminOnly, scheduleThrottle)
+	scheduleBtn := b.markups.main.subscribed.schedule
+	b.bot.Handle(&scheduleBtn, b.ScheduleHandler, adminOnly, scheduleThrottle)
+
+	b.bot.Handle("/export", b.ExportHandler, adminOnly, writes, block)
+
+	b.bot.Handle("/mute", b.MuteHandler, adminOnly, writes, block)
+	footerGroupBtn := b.markups.footer.group
+	b.bot.Handle(&footerGroupBtn, b.FooterGroupHandler, adminOnly)
+	footerMuteBtn := b.markups.footer.mute
+	b.bot.Handle(&footerMuteBtn, b.FooterMuteHandler, adminOnly, writes, block, debounce)
+	footerUnsubscribeBtn := b.markups.footer.unsubscribe
+	b.bot.Handle(&footerUnsubscribeBtn, b.FooterUnsubscribeHandler, adminOnly, writes, block)
+	b.bot.Handle("/unmute", b.UnmuteHandler, adminOnly, writes, block)
+
+	b.bot.Handle("/help", b.HelpHandler)
+	for _, section := range helpCatalog {
+		btn := b.markups.help.btns[section.key]
+		b.bot.Handle(&btn, b.HelpSectionHandler(section))
+	}
+
+	b.bot.Handle(tb.OnQuery, b.InlineQueryHandler)
+
+	botAdmin := adminsOnly(b.admins)
+	b.bot.Handle("/maintenance", b.MaintenanceHandler, botAdmin, writes)
+	b.bot.Handle("/tasks", b.TasksHandler, botAdmin)
+	b.bot.Handle("/stats", b.StatsHandler, botAdmin)
+	b.bot.Handle("/dbstats", b.DBStatsHandler, botAdmin)
+	b.bot.Handle("/revisions", b.RevisionsHandler, botAdmin)
+	b.bot.Handle("/purges", b.PurgesHandler, botAdmin)
+	b.bot.Handle("/why", b.WhyHandler, botAdmin)
+	b.bot.Handle("/sendto", b.SendToHandler, botAdmin, writes)
+	b.bot.Handle("/suspend_group", b.SuspendGroupHandler, botAdmin, writes)
+	b.bot.Handle("/resume_group", b.ResumeGroupHandler, botAdmin, writes)
+	b.bot.Handle("/force_refresh", b.ForceRefreshHandler, botAdmin, writes)
+	b.bot.Handle("/resync_state", b.ResyncStateHandler, botAdmin, writes)
+	resyncStateBtn := b.markups.resyncState.confirm
+	b.bot.Handle(&resyncStateBtn, b.ResyncStateConfirmHandler, botAdmin, writes)
 }
 
 func (b *SSOBot) StartHandler(c tb.Context) error {
-	markup := b.markups.main.unsubscribed.ReplyMarkup
-	subscribed, err := b.subscriptionService.IsSubscribed(c.Sender().ID)
+	if token, ok := parseImportToken(c.Message()); ok {
+		return b.importSubscription(c, token)
+	}
+
+	sub, subscribed, err := b.subscriptionService.GetSubscription(c.Chat().ID)
 	if err != nil {
 		slog.Error("failed to check if user is subscribed", "error", err)
 		return c.Send("Щось пішло не так. Будь ласка, спробуйте пізніше.")
 	}
-	if subscribed {
-		markup = b.markups.main.subscribed.ReplyMarkup
+	const msg = "Привіт! Бажаєте підписатись на оновлення графіку відключень?"
+	if !subscribed {
+		if source, ok := parseSource(c.Message()); ok {
+			b.sources.put(c.Chat().ID, source)
+		}
+
+		tombstone, restorable, err := b.subscriptionService.Tombstone(c.Chat().ID)
+		if err != nil {
+			slog.Error("failed to get tombstone", "error", err)
+		}
+		if restorable {
+			return c.Send(msg+"\n\nВідновити попередні налаштування? Групи: "+strings.Join(tombstone.Groups, ", "),
+				b.markups.main.restorable.ReplyMarkup)
+		}
+		return c.Send(msg, b.markups.main.unsubscribed.ReplyMarkup)
+	}
+
+	if sub.IsDisabled() {
+		// user is back after blocking the bot, so the subscription is restored with all groups and settings
+		if sub, err = b.subscriptionService.Enable(c.Chat().ID); err != nil {
+			slog.Error("failed to enable subscription", "error", err)
+		}
+	}
+
+	if sub.IsMuted(time.Now()) {
+		return c.Send(msg+"\n\n🔕 Сповіщення вимкнено до "+b.formatMutedUntil(sub.MutedUntil)+". Увімкнути: /unmute",
+			b.markups.main.subscribed.ReplyMarkup)
+	}
+	return c.Send(msg, b.markups.main.subscribed.ReplyMarkup)
+}
+
+// RestoreHandler subscribes the chat back with groups and settings it had before unsubscribing
+func (b *SSOBot) RestoreHandler(c tb.Context) error {
+	sub, err := b.subscriptionService.Restore(c.Chat().ID, models.ChatType(c.Chat().Type))
+	switch {
+	case errors.Is(err, models.ErrSubscriptionNotFound):
+		return c.Send("Попередні налаштування більше недоступні. Оберіть групу", b.markups.groups.ReplyMarkup)
+	case errors.Is(err, models.ErrSubscriptionsLimitReached):
+		return c.Send("Кількість підписок досягла межі. Будь ласка, спробуйте пізніше.")
+	case err != nil:
+		slog.Error("failed to restore subscription", "error", err)
+		return c.Send("Не вдалось відновити налаштування. Будь ласка, спробуйте пізніше.")
+	}
+
+	groups := make([]string, 0, len(sub.Groups))
+	for groupNum := range sub.Groups {
+		groups = append(groups, groupNum)
+	}
+	sort.Strings(groups)
+	return c.Send("Налаштування відновлено. Ви підписані на групи: "+strings.Join(groups, ", "),
+		b.markups.main.subscribed.ReplyMarkup)
+}
+
+// ScheduleHandler sends the current schedule of the chat groups
+func (b *SSOBot) ScheduleHandler(c tb.Context) error {
+	msg, err := b.subscriptionService.RenderSchedule(c.Chat().ID)
+	switch {
+	case errors.Is(err, models.ErrSubscriptionNotFound):
+		return c.Send("Ви не підписані на оновлення", b.markups.main.unsubscribed.ReplyMarkup)
+	case err != nil:
+		slog.Error("failed to render schedule", "error", err)
+		return c.Send("Щось пішло не так. Будь ласка, спробуйте пізніше.")
 	}
-	return c.Send("Привіт! Бажаєте підписатись на оновлення графіку відключень?", markup)
+	return c.Send(msg, tb.NoPreview)
 }
 
 func (b *SSOBot) ChooseGroupHandler(c tb.Context) error {
@@ -82,7 +289,10 @@ func (b *SSOBot) ChooseGroupHandler(c tb.Context) error {
 
 func (b *SSOBot) SetGroupHandler(groupNumber string) func(c tb.Context) error {
 	return func(c tb.Context) error {
-		_, err := b.subscriptionService.SubscribeToGroup(c.Sender().ID, groupNumber)
+		chatID := c.Chat().ID
+		sub, err := b.subscriptionService.SubscribeToGroup(
+			chatID, models.ChatType(c.Chat().Type), groupNumber, b.sources.pop(chatID),
+		)
 		if errors.Is(err, models.ErrSubscriptionsLimitReached) {
 			slog.Warn("failed to subscribe", "error", err, "groupNum", groupNumber)
 			return c.Send("Кількість підписок досягла межі. Будь ласка, спробуйте пізніше.")
@@ -91,55 +301,705 @@ func (b *SSOBot) SetGroupHandler(groupNumber string) func(c tb.Context) error {
 			return c.Send("Не вдалось підписатись. Будь ласка, спробуйте пізніше.")
 		}
 
+		if !sub.OnboardingCompleted {
+			return b.onboard(c, groupNumber, sub.Settings)
+		}
 		return c.Send("Ви підписались на групу "+groupNumber, b.markups.main.subscribed.ReplyMarkup)
 	}
 }
 
+// onboard shows the schedule of just chosen group to the new subscriber and points to settings
+func (b *SSOBot) onboard(c tb.Context, groupNumber string, settings models.Settings) error {
+	schedule, ok, err := b.subscriptionService.GroupSchedule(groupNumber, settings.TwelveHourTime)
+	if err != nil {
+		slog.Error("failed to get group schedule", "error", err, "groupNum", groupNumber)
+	} else if ok {
+		if err = c.Send(schedule, tb.NoPreview); err != nil {
+			return err
+		}
+	}
+
+	if _, err = b.subscriptionService.CompleteOnboarding(c.Chat().ID); err != nil {
+		slog.Error("failed to complete onboarding", "error", err)
+	}
+
+	return c.Send("Ви підписались на групу "+groupNumber+". Надалі ви отримуватимете оновлення графіку.\n\n"+
+		"Змінити групу або налаштувати сповіщення можна будь-коли через меню нижче або /settings.",
+		b.markups.main.subscribed.ReplyMarkup)
+}
+
+func (b *SSOBot) SettingsHandler(c tb.Context) error {
+	sub, exists, err := b.subscriptionService.GetSubscription(c.Chat().ID)
+	if err != nil {
+		slog.Error("failed to get subscription", "error", err)
+		return c.Send("Щось пішло не так. Будь ласка, спробуйте пізніше.")
+	}
+	if !exists {
+		return c.Send("Ви не підписані на оновлення", b.markups.main.unsubscribed.ReplyMarkup)
+	}
+
+	return c.Send(b.settingsText(sub, time.Now()), b.markups.settingsMarkup(sub.Settings))
+}
+
+func (b *SSOBot) ToggleSettingHandler(setting models.Setting) func(c tb.Context) error {
+	return func(c tb.Context) error {
+		sub, err := b.subscriptionService.ToggleSetting(c.Chat().ID, setting)
+		if err != nil {
+			slog.Error("failed to toggle setting", "error", err, "setting", setting)
+			return c.Send("Не вдалось змінити налаштування. Будь ласка, спробуйте пізніше.")
+		}
+
+		return c.Edit(b.settingsText(sub, time.Now()), b.markups.settingsMarkup(sub.Settings))
+	}
+}
+
+// settingsText is the settings screen header with subscription age and delivery counters
+func (b *SSOBot) settingsText(sub models.Subscription, now time.Time) string {
+	var buf strings.Builder
+	buf.WriteString("Налаштування\n")
+	if !sub.CreatedAt.IsZero() {
+		days := int(now.Sub(sub.CreatedAt).Hours() / 24)
+		buf.WriteString(fmt.Sprintf("\nПідписка з %s (днів: %d)",
+			sub.CreatedAt.In(b.location).Format("02.01.2006"), days))
+	}
+	buf.WriteString(fmt.Sprintf("\nНадіслано оновлень графіку: %d, сповіщень: %d", sub.UpdatesSent, sub.NotificationsSent))
+	if !sub.LastDeliveredAt.IsZero() {
+		buf.WriteString("\nОстаннє повідомлення: " + sub.LastDeliveredAt.In(b.location).Format("02.01 15:04"))
+	}
+	return buf.String()
+}
+
+// MuteHandler mutes updates until the given Kyiv time or for the given duration: "/mute 18:00" or "/mute 2h"
+func (b *SSOBot) MuteHandler(c tb.Context) error {
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send("Вкажіть час або тривалість, наприклад: /mute 18:00 або /mute 2h")
+	}
+
+	until, err := parseMuteUntil(args[0], time.Now().In(b.location))
+	switch {
+	case errors.Is(err, errInvalidMuteTime):
+		return c.Send("Невірний формат часу. Приклад: /mute 18:00")
+	case errors.Is(err, errPastMuteTime):
+		return c.Send("Цей час уже минув. Вкажіть час пізніше за " + time.Now().In(b.location).Format("15:04"))
+	case errors.Is(err, errInvalidMuteDuration):
+		return c.Send("Невірна тривалість. Приклад: /mute 2h або /mute 30m")
+	case errors.Is(err, errMuteDurationTooLong):
+		return c.Send("Сповіщення можна вимкнути щонайбільше на 7 днів")
+	}
+
+	_, err = b.subscriptionService.Mute(c.Chat().ID, until)
+	return b.replyMuted(c, "Сповіщення", until, err)
+}
+
+// FooterGroupHandler shows actions for the group from the button attached to schedule updates
+func (b *SSOBot) FooterGroupHandler(c tb.Context) error {
+	groupNum := c.Callback().Data
+	return c.Send("Група "+groupNum, b.markups.footer.groupMarkup(groupNum))
+}
+
+// FooterMuteHandler mutes the group for footerMuteDuration from the button attached to schedule updates
+func (b *SSOBot) FooterMuteHandler(c tb.Context) error {
+	groupNum := c.Callback().Data
+	until := time.Now().Add(footerMuteDuration)
+	_, err := b.subscriptionService.MuteGroup(c.Chat().ID, groupNum, until)
+	return b.replyMuted(c, "Сповіщення групи "+groupNum, until, err)
+}
+
+// replyMuted answers mute command or button with the time the subject is muted until
+func (b *SSOBot) replyMuted(c tb.Context, subject string, until time.Time, err error) error {
+	switch {
+	case errors.Is(err, models.ErrSubscriptionNotFound):
+		return c.Send("Ви не підписані на оновлення", b.markups.main.unsubscribed.ReplyMarkup)
+	case errors.Is(err, models.ErrInvalidGroup):
+		return c.Send("Ви не підписані на цю групу")
+	case err != nil:
+		slog.Error("failed to mute", "error", err)
+		return c.Send("Не вдалось вимкнути сповіщення. Будь ласка, спробуйте пізніше.")
+	}
+	return c.Send("🔕 " + subject + " вимкнено до " + b.formatMutedUntil(until) + ". Увімкнути: /unmute")
+}
+
+// FooterUnsubscribeHandler unsubscribes from the group from the group actions opened by the update footer
+func (b *SSOBot) FooterUnsubscribeHandler(c tb.Context) error {
+	groupNum := c.Callback().Data
+	sub, err := b.subscriptionService.UnsubscribeFromGroup(c.Chat().ID, groupNum)
+	switch {
+	case errors.Is(err, models.ErrSubscriptionNotFound):
+		return c.Send("Ви не підписані на оновлення", b.markups.main.unsubscribed.ReplyMarkup)
+	case errors.Is(err, models.ErrInvalidGroup):
+		return c.Send("Ви не підписані на цю групу")
+	case err != nil:
+		slog.Error("failed to unsubscribe from group", "error", err, "groupNum", groupNum)
+		return c.Send("Не вдалось відписатись. Будь ласка, спробуйте пізніше.")
+	}
+	if len(sub.Groups) == 0 {
+		return c.Send("Ви відписались від оновлень", b.markups.main.unsubscribed.ReplyMarkup)
+	}
+	return c.Send("Ви відписались від групи "+groupNum, b.markups.main.subscribed.ReplyMarkup)
+}
+
+func (b *SSOBot) UnmuteHandler(c tb.Context) error {
+	_, err := b.subscriptionService.Unmute(c.Chat().ID)
+	if errors.Is(err, models.ErrSubscriptionNotFound) {
+		return c.Send("Ви не підписані на оновлення", b.markups.main.unsubscribed.ReplyMarkup)
+	} else if err != nil {
+		slog.Error("failed to unmute", "error", err)
+		return c.Send("Не вдалось увімкнути сповіщення. Будь ласка, спробуйте пізніше.")
+	}
+
+	return c.Send("🔔 Сповіщення увімкнено")
+}
+
+func (b *SSOBot) formatMutedUntil(t time.Time) string {
+	now := time.Now().In(b.location)
+	t = t.In(b.location)
+	if t.Year() == now.Year() && t.YearDay() == now.YearDay() {
+		return t.Format("15:04")
+	}
+	return t.Format("15:04 02.01")
+}
+
+const maxMuteDuration = 7 * 24 * time.Hour
+
+var (
+	errInvalidMuteTime     = errors.New("invalid mute time")
+	errPastMuteTime        = errors.New("mute time is in the past")
+	errInvalidMuteDuration = errors.New("invalid mute duration")
+	errMuteDurationTooLong = errors.New("mute duration is too long")
+)
+
+// parseMuteUntil parses either "HH:MM" time of today in now's location or a duration like "2h" or "1h30m"
+func parseMuteUntil(val string, now time.Time) (time.Time, error) {
+	if strings.Contains(val, ":") {
+		t, err := time.ParseInLocation("15:04", val, now.Location())
+		if err != nil {
+			return time.Time{}, errInvalidMuteTime
+		}
+		until := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !until.After(now) {
+			return time.Time{}, errPastMuteTime
+		}
+		return until, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		return time.Time{}, errInvalidMuteDuration
+	}
+	if d > maxMuteDuration {
+		return time.Time{}, errMuteDurationTooLong
+	}
+	return now.Add(d), nil
+}
+
 func (b *SSOBot) UnsubscribeHandler(c tb.Context) error {
-	if err := b.subscriptionService.Unsubscribe(c.Sender().ID); err != nil {
+	if err := b.subscriptionService.Unsubscribe(c.Chat().ID); err != nil {
 		slog.Error("failed to unsubscribe", "error", err)
 		return c.Send("Не вдалось відписатись. Будь ласка, спробуйте пізніше.", b.markups.main.subscribed.ReplyMarkup)
 	}
 	return c.Send("Ви відписані", b.markups.main.unsubscribed.ReplyMarkup)
 }
 
+// DeleteDataHandler removes everything stored about the chat, including settings kept after unsubscribing
+func (b *SSOBot) DeleteDataHandler(c tb.Context) error {
+	if err := b.subscriptionService.DeleteData(c.Chat().ID); err != nil {
+		slog.Error("failed to delete chat data", "error", err)
+		return c.Send("Не вдалось видалити дані. Будь ласка, спробуйте пізніше.")
+	}
+	return c.Send("Усі ваші дані видалено", b.markups.main.unsubscribed.ReplyMarkup)
+}
+
+// InlineQueryHandler offers group schedule to share in any chat, e.g. "@bot 5"
+func (b *SSOBot) InlineQueryHandler(c tb.Context) error {
+	groupNum := strings.TrimSpace(c.Query().Text)
+	if _, err := strconv.Atoi(groupNum); err != nil {
+		return c.Answer(&tb.QueryResponse{})
+	}
+
+	result := &tb.ArticleResult{
+		Title: "Група " + groupNum,
+	}
+	// time format of the sender is honored if they are subscribed in private chat
+	sub, _, err := b.subscriptionService.GetSubscription(c.Sender().ID)
+	if err != nil {
+		slog.Error("failed to get subscription", "error", err, "chatID", c.Sender().ID)
+	}
+	msg, ok, err := b.subscriptionService.GroupSchedule(groupNum, sub.Settings.TwelveHourTime)
+	switch {
+	case err != nil:
+		slog.Error("failed to get group schedule", "error", err, "groupNum", groupNum)
+		return c.Answer(&tb.QueryResponse{})
+	case !ok:
+		result.Description = "Графік для групи недоступний"
+		result.Text = "Графік для групи " + groupNum + " недоступний"
+	default:
+		result.Description = "Надіслати графік відключень"
+		result.SetContent(&tb.InputTextMessageContent{Text: msg, DisablePreview: true})
+	}
+	result.SetResultID("group_" + groupNum)
+
+	return c.Answer(&tb.QueryResponse{
+		Results:   tb.Results{result},
+		CacheTime: 60, //nolint:gomnd
+	})
+}
+
+// MaintenanceHandler toggles maintenance mode: "/maintenance on <text>" or "/maintenance off"
+func (b *SSOBot) MaintenanceHandler(c tb.Context) error {
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Send("Використання: /maintenance on|off <текст>")
+	}
+
+	var err error
+	switch args[0] {
+	case "on":
+		err = b.maintenanceService.Enable(strings.Join(args[1:], " "))
+	case "off":
+		err = b.maintenanceService.Disable()
+	default:
+		return c.Send("Використання: /maintenance on|off <текст>")
+	}
+	if err != nil {
+		slog.Error("failed to toggle maintenance mode", "error", err)
+		return c.Send("Не вдалось змінити режим обслуговування: " + err.Error())
+	}
+
+	if b.maintenanceService.IsEnabled() {
+		return c.Send("Режим обслуговування увімкнено:\n" + b.maintenanceService.Banner())
+	}
+	return c.Send("Режим обслуговування вимкнено")
+}
+
+// TasksHandler shows last run status of each scheduler task
+func (b *SSOBot) TasksHandler(c tb.Context) error {
+	var buf strings.Builder
+	for _, status := range b.taskStatusProvider.TaskStatuses() {
+		buf.WriteString(renderTaskStatus(status, b.location))
+		buf.WriteString("\n")
+	}
+	return c.Send(buf.String())
+}
+
+func renderTaskStatus(status models.TaskStatus, loc *time.Location) string {
+	if status.Disabled {
+		return "⚫️ " + status.Name + ": вимкнена"
+	}
+	if status.LastStart.IsZero() {
+		return "⚪️ " + status.Name + ": ще не запускалась"
+	}
+
+	const timeFormat = "02.01 15:04:05"
+	icon := "🟢"
+	if status.LastError != "" {
+		icon = "🔴"
+	}
+	res := fmt.Sprintf("%s %s: %s (%s)", icon, status.Name,
+		status.LastStart.In(loc).Format(timeFormat), status.Duration.Round(time.Millisecond))
+	if status.LastError != "" {
+		lastSuccess := "ніколи"
+		if !status.LastSuccess.IsZero() {
+			lastSuccess = status.LastSuccess.In(loc).Format(timeFormat)
+		}
+		res += fmt.Sprintf("\n    успіх: %s\n    помилка: %s", lastSuccess, status.LastError)
+	}
+	if status.Skipped > 0 {
+		res += fmt.Sprintf("\n    пропущено запусків: %d", status.Skipped)
+	}
+	if r := status.Report; r.Attempted > 0 || r.Skipped > 0 {
+		res += fmt.Sprintf("\n    надіслано: %d, помилок: %d, заблоковано: %d, відкладено: %d",
+			r.Sent, r.Failed, r.Disabled, r.Skipped)
+	}
+	return res
+}
+
+// DBStatsHandler shows storage usage per bucket
+func (b *SSOBot) DBStatsHandler(c tb.Context) error {
+	stats, err := b.dbStatsProvider.DBStats()
+	if err != nil {
+		slog.Error("failed to get db stats", "error", err)
+		return c.Send("Не вдалось отримати статистику сховища: " + err.Error())
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Розмір файлу: %s\nВільні сторінки: %.1f%%\n\n", formatBytes(stats.FileSize),
+		stats.FreePageRatio*100)
+	for _, bucket := range stats.Buckets {
+		fmt.Fprintf(&buf, "%s: %d ключів, %s\n", bucket.Name, bucket.Keys, formatBytes(int64(bucket.Size)))
+	}
+	if stats.Partial {
+		buf.WriteString("\n⚠️ Не всі бакети перевірено за відведений час")
+	}
+	return c.Send(buf.String())
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// StatsHandler shows subscriptions per acquisition source and new subscriptions per day
+func (b *SSOBot) StatsHandler(c tb.Context) error {
+	stats, err := b.subscriptionService.Stats()
+	if err != nil {
+		slog.Error("failed to get stats", "error", err)
+		return c.Send("Не вдалось отримати статистику: " + err.Error())
+	}
+
+	sources := make([]string, 0, len(stats.BySource))
+	for source := range stats.BySource {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return stats.BySource[sources[i]] > stats.BySource[sources[j]]
+	})
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Підписок: %d\n\nДжерела:\n", stats.Total)
+	for _, source := range sources {
+		fmt.Fprintf(&buf, "  %s: %d\n", source, stats.BySource[source])
+	}
+	buf.WriteString("\nНові підписки:\n")
+	for _, day := range stats.ByDay {
+		fmt.Fprintf(&buf, "  %s: %d\n", day.Date, day.Count)
+	}
+
+	if suspended := b.suspensionService.Suspended(); len(suspended) > 0 {
+		buf.WriteString("\nПризупинені групи:\n")
+		for _, g := range suspended {
+			fmt.Fprintf(&buf, "  %s з %s", g.Group, g.Since.In(b.location).Format("2006-01-02 15:04"))
+			if g.Reason != "" {
+				buf.WriteString(": " + g.Reason)
+			}
+			buf.WriteString("\n")
+		}
+	}
+
+	return c.Send(buf.String())
+}
+
+// SuspendGroupHandler stops updates of the group, e.g. "/suspend_group 9 невірні дані постачальника".
+// Subscribers of the group are notified once if the reason is provided
+func (b *SSOBot) SuspendGroupHandler(c tb.Context) error {
+	groupNum, reason, _ := strings.Cut(strings.TrimSpace(c.Message().Payload), " ")
+	if !b.validGroup(groupNum) {
+		return c.Send("Використання: /suspend_group <група> [причина]")
+	}
+
+	if err := b.suspensionService.Suspend(groupNum, strings.TrimSpace(reason)); err != nil {
+		slog.Error("failed to suspend group", "error", err, "groupNum", groupNum)
+		return c.Send("Не вдалось призупинити групу: " + err.Error())
+	}
+	return c.Send("Оновлення групи " + groupNum + " призупинено")
+}
+
+// ResumeGroupHandler lifts suspension of the group, e.g. "/resume_group 9"
+func (b *SSOBot) ResumeGroupHandler(c tb.Context) error {
+	groupNum := strings.TrimSpace(c.Message().Payload)
+	if !b.validGroup(groupNum) {
+		return c.Send("Використання: /resume_group <група>")
+	}
+
+	if err := b.suspensionService.Resume(groupNum); err != nil {
+		slog.Error("failed to resume group", "error", err, "groupNum", groupNum)
+		return c.Send("Не вдалось відновити групу: " + err.Error())
+	}
+	return c.Send("Оновлення групи " + groupNum + " відновлено")
+}
+
+// ResyncStateHandler asks to confirm marking the current schedule as sent to every subscription, e.g. after restoring
+// a backup, so subscribers don't get the whole schedule again
+func (b *SSOBot) ResyncStateHandler(c tb.Context) error {
+	return c.Send("Позначити поточний графік як надісланий усім підпискам? Повідомлення не надсилатимуться, "+
+		"наступні оновлення прийдуть лише після змін графіку.", b.markups.resyncState.ReplyMarkup)
+}
+
+func (b *SSOBot) ResyncStateConfirmHandler(c tb.Context) error {
+	n, err := b.subscriptionService.ResyncState()
+	if err != nil {
+		slog.Error("failed to resync subscriptions state", "error", err)
+		return c.Send("Не вдалось оновити стан підписок: " + err.Error())
+	}
+	return c.Send(fmt.Sprintf("Стан оновлено для %d підписок", n))
+}
+
+// ForceRefreshHandler stores freshly loaded schedule even if its groups don't match the stored ones
+func (b *SSOBot) ForceRefreshHandler(c tb.Context) error {
+	if err := b.shutdownsService.ForceRefreshShutdownsTable(); err != nil {
+		slog.Error("failed to force refresh shutdowns table", "error", err)
+		return c.Send("Не вдалось оновити графік: " + err.Error())
+	}
+	return c.Send("Графік оновлено")
+}
+
+// RevisionsHandler shows when the current schedule was changed by the provider
+func (b *SSOBot) RevisionsHandler(c tb.Context) error {
+	date, revs, err := b.shutdownsService.Revisions()
+	if err != nil {
+		slog.Error("failed to get schedule revisions", "error", err)
+		return c.Send("Не вдалось отримати історію змін графіку")
+	}
+	if len(revs) == 0 {
+		return c.Send("Історія змін графіку порожня")
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Графік на %s змінювався %d раз(и):\n", date, len(revs)-1)
+	for _, rev := range revs {
+		fmt.Fprintf(&buf, "  %s %s\n", rev.FirstSeen.In(b.location).Format("02.01 15:04:05"), rev.Hash)
+	}
+	return c.Send(buf.String())
+}
+
+func (b *SSOBot) validGroup(groupNum string) bool {
+	num, err := strconv.Atoi(groupNum)
+	return err == nil && num >= 1 && num <= b.subscriptionService.GroupsCount()
+}
+
+// SendToHandler sends the current schedule to the subscribed chat the way it is rendered for its subscription, e.g.
+// "/sendto 123", or the schedule of the given groups with the chat settings, e.g. "/sendto 123 1,5".
+// Subscription state is not changed, so regular updates of the chat are not affected
+func (b *SSOBot) SendToHandler(c tb.Context) error {
+	const usage = "Використання: /sendto <chatID> [групи через кому]"
+	args := strings.Fields(c.Message().Payload)
+	if len(args) == 0 || len(args) > 2 {
+		return c.Send(usage)
+	}
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return c.Send(usage)
+	}
+
+	sub, subscribed, err := b.subscriptionService.GetSubscription(chatID)
+	switch {
+	case err != nil:
+		slog.Error("failed to get subscription", "error", err, "chatID", chatID)
+		return c.Send("Не вдалось отримати підписку: " + err.Error())
+	case !subscribed:
+		return c.Send(fmt.Sprintf("Чат %d не має підписки", chatID))
+	}
+
+	var msg string
+	if len(args) == 2 {
+		groupNums := strings.Split(args[1], ",")
+		for _, groupNum := range groupNums {
+			if !b.validGroup(groupNum) {
+				return c.Send(fmt.Sprintf("Невідома група: %s", groupNum))
+			}
+		}
+		msg, err = b.subscriptionService.RenderGroupsSchedule(groupNums, sub.Settings)
+	} else {
+		msg, err = b.subscriptionService.RenderSchedule(chatID)
+	}
+	switch {
+	case errors.Is(err, models.ErrSubscriptionNotFound):
+		return c.Send(fmt.Sprintf("Чат %d не має підписки", chatID))
+	case err != nil:
+		slog.Error("failed to render schedule", "error", err, "chatID", chatID)
+		return c.Send("Не вдалось сформувати графік: " + err.Error())
+	}
+
+	// the message is sent by the bot directly, as the shared sender disables blocked chats and counts deliveries
+	_, err = b.bot.Send(tb.ChatID(chatID), msg, tb.NoPreview)
+	var migrated tb.GroupError
+	switch {
+	case isForbidden(err):
+		return c.Send(fmt.Sprintf("Чат %d заблокував бота, графік не надіслано", chatID))
+	case errors.As(err, &migrated) && migrated.MigratedTo != 0:
+		return c.Send(fmt.Sprintf("Чат %d перетворено на супергрупу %d, графік не надіслано", chatID, migrated.MigratedTo))
+	case err != nil:
+		slog.Error("failed to send schedule", "error", err, "chatID", chatID)
+		return c.Send(fmt.Sprintf("Не вдалось надіслати графік чату %d: %s\n\n%s", chatID, err, msg), tb.NoPreview)
+	}
+	return c.Send(fmt.Sprintf("Графік надіслано чату %d:\n\n%s", chatID, msg), tb.NoPreview)
+}
+
+var deliveryTexts = map[models.Delivery]string{
+	models.DeliveryNone:     "нічого не надіслано",
+	models.DeliverySent:     "надіслано",
+	models.DeliveryFailed:   "помилка",
+	models.DeliveryDisabled: "вимкнено",
+	models.DeliverySkipped:  "пропущено",
+}
+
+var deliveryReasonTexts = map[models.DeliveryReason]string{
+	models.ReasonNoChange:       "графік не змінився",
+	models.ReasonSameText:       "текст не змінився",
+	models.ReasonSuspendedGroup: "групу призупинено",
+	models.ReasonMalformedGroup: "некоректні дані групи",
+	models.ReasonMuted:          "сповіщення вимкнено",
+	models.ReasonAwaitingProbe:  "бот заблоковано, очікує перевірки",
+	models.ReasonQuietHours:     "без звуку поза вікном сповіщень",
+	models.ReasonRenderFailed:   "не вдалось сформувати повідомлення",
+	models.ReasonSendFailed:     "не вдалось надіслати",
+	models.ReasonStoreFailed:    "не вдалось зберегти підписку",
+	models.ReasonBlocked:        "бот заблоковано",
+	models.ReasonGroupsLost:     "груп немає у графіку",
+}
+
+// WhyHandler shows latest outcomes of schedule updates for the chat, e.g. "/why 123", to answer why a message
+// wasn't received. Outcomes are recorded when they change, so each one lasted until the next
+func (b *SSOBot) WhyHandler(c tb.Context) error {
+	chatID, err := strconv.ParseInt(strings.TrimSpace(c.Message().Payload), 10, 64)
+	if err != nil {
+		return c.Send("Використання: /why <chatID>")
+	}
+
+	log, err := b.subscriptionService.DeliveryLog(chatID)
+	if err != nil {
+		slog.Error("failed to get delivery log", "error", err, "chatID", chatID)
+		return c.Send("Не вдалось отримати журнал розсилки: " + err.Error())
+	}
+	if len(log) == 0 {
+		return c.Send(fmt.Sprintf("Журнал розсилки чату %d порожній", chatID))
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Розсилка чату %d, починаючи з останньої:\n", chatID)
+	for _, e := range log {
+		fmt.Fprintf(&buf, "  з %s %s", e.At.In(b.location).Format("02.01 15:04:05"), deliveryTexts[e.Delivery])
+		if e.Reason != "" {
+			fmt.Fprintf(&buf, " (%s)", deliveryReasonTexts[e.Reason])
+		}
+		buf.WriteString("\n")
+	}
+	return c.Send(buf.String())
+}
+
+const purgesShown = 50
+const purgeDaysShown = 30
+
+// PurgesHandler shows latest subscriptions disabled or purged because the bot was blocked, with counts per day
+func (b *SSOBot) PurgesHandler(c tb.Context) error {
+	events, err := b.subscriptionService.PurgeLog()
+	if err != nil {
+		slog.Error("failed to get purge log", "error", err)
+		return c.Send("Не вдалось отримати журнал: " + err.Error())
+	}
+	if len(events) == 0 {
+		return c.Send("Журнал порожній")
+	}
+
+	days := make([]string, 0)
+	byDay := make(map[string]map[models.PurgeAction]int)
+	for _, e := range events {
+		day := e.At.In(b.location).Format("2006-01-02")
+		if _, ok := byDay[day]; !ok {
+			if len(days) == purgeDaysShown {
+				break
+			}
+			days = append(days, day)
+			byDay[day] = make(map[models.PurgeAction]int)
+		}
+		byDay[day][e.Action]++
+	}
+
+	var buf strings.Builder
+	buf.WriteString("По днях (вимкнено / видалено):\n")
+	for _, day := range days {
+		fmt.Fprintf(&buf, "  %s: %d / %d\n", day,
+			byDay[day][models.PurgeActionDisabled], byDay[day][models.PurgeActionPurged])
+	}
+
+	buf.WriteString("\nОстанні:\n")
+	for i, e := range events {
+		if i == purgesShown {
+			break
+		}
+		fmt.Fprintf(&buf, "  %s %s %s\n", e.At.In(b.location).Format("2006-01-02 15:04"), e.ChatHash, e.Action)
+	}
+
+	return c.Send(buf.String())
+}
+
 type SSOBotBuilder struct {
-	bot *tb.Bot
+	bot         *tb.Bot
+	staleCutoff time.Duration
+	location    *time.Location
+	readOnly    bool
+	footer      *updateFooter
+}
+
+// ReadOnly makes the bot reply "Тимчасово недоступно" to commands and buttons which change data, so it can serve
+// reads from a read only database copy
+func (bb *SSOBotBuilder) ReadOnly(readOnly bool) *SSOBotBuilder {
+	bb.readOnly = readOnly
+	return bb
 }
 
-func (bb *SSOBotBuilder) Sender(handler BlockedByUserHandler) MessageSender {
+func (bb *SSOBotBuilder) Sender(handler BlockedByUserHandler, migratedHandler ChatMigratedHandler) MessageSender {
 	return &messageSender{
-		bot:            bb.bot,
-		blockedHandler: handler,
+		bot:             bb.bot,
+		blockedHandler:  handler,
+		migratedHandler: migratedHandler,
+		footer:          bb.footer,
+		chooseGroup:     newChooseGroupMarkup(),
 	}
 }
 
-func (bb *SSOBotBuilder) Build(subscriptionService SubscriptionService) *SSOBot {
+func (bb *SSOBotBuilder) Build(
+	subscriptionService SubscriptionService, maintenanceService MaintenanceService,
+	suspensionService SuspensionService, shutdownsService ShutdownsService, migrationService MigrationService,
+	taskStatusProvider TaskStatusProvider, dbStatsProvider DBStatsProvider, adminIDs []int64,
+) *SSOBot {
 	return &SSOBot{
-		bot:     bb.bot,
-		markups: newMarkups(subscriptionService.GroupsCount()),
+		bot:         bb.bot,
+		markups:     newMarkups(subscriptionService.GroupsCount(), bb.footer),
+		staleCutoff: bb.staleCutoff,
+		admins:      newAdminSet(adminIDs),
+		location:    bb.location,
+		readOnly:    bb.readOnly,
 
 		subscriptionService: subscriptionService,
+		maintenanceService:  maintenanceService,
+		suspensionService:   suspensionService,
+		shutdownsService:    shutdownsService,
+		migrationService:    migrationService,
+		taskStatusProvider:  taskStatusProvider,
+		dbStatsProvider:     dbStatsProvider,
+
+		sources: newPendingSources(),
 	}
 }
 
 type BlockedByUserHandler func(chatID int64)
 
+// ChatMigratedHandler is called when the group chat was upgraded to a supergroup and got a new ID
+type ChatMigratedHandler func(from, to int64)
+
 func NewBotBuilder() *SSOBotBuilder {
 	return &SSOBotBuilder{
-		bot: mustTBot(),
+		bot:         mustTBot(),
+		staleCutoff: mustStaleUpdatesCutoff(),
+		location:    clock.MustKyiv(),
+		footer:      newUpdateFooter(),
 	}
 }
 
-func mustTBot() *tb.Bot {
-	token := os.Getenv("TOKEN")
-	if token == "" {
-		slog.Error("TOKEN environment variable is missing")
-		panic("TOKEN environment variable is missing")
+func mustStaleUpdatesCutoff() time.Duration {
+	val := os.Getenv("STALE_UPDATES_CUTOFF")
+	if val == "" {
+		return defaultStaleUpdatesCutoff
 	}
 
+	res, err := time.ParseDuration(val)
+	if err != nil {
+		slog.Error("failed to parse STALE_UPDATES_CUTOFF environment variable", "value", val, "error", err)
+		panic(fmt.Errorf("parse STALE_UPDATES_CUTOFF: %w", err))
+	}
+	return res
+}
+
+func mustTBot() *tb.Bot {
 	bot, err := tb.NewBot(tb.Settings{
-		Token:  token,
+		Token:  mustToken(),
 		Poller: &tb.LongPoller{Timeout: 5 * time.Second}, //nolint:gomnd
 	})
 	if err != nil {
@@ -150,9 +1010,35 @@ func mustTBot() *tb.Bot {
 	return bot
 }
 
+// mustToken reads bot token from TOKEN environment variable or from the file TOKEN_FILE points to
+func mustToken() string {
+	if token := os.Getenv("TOKEN"); token != "" {
+		return token
+	}
+
+	path := os.Getenv("TOKEN_FILE")
+	if path == "" {
+		slog.Error("TOKEN environment variable is missing")
+		panic("TOKEN environment variable is missing")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("failed to read TOKEN_FILE", "path", path, "error", err)
+		panic(fmt.Errorf("read TOKEN_FILE=%s: %w", path, err))
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		slog.Error("TOKEN_FILE is empty", "path", path)
+		panic(fmt.Errorf("TOKEN_FILE=%s is empty", path))
+	}
+	return token
+}
+
 type subscribedMarkup struct {
 	*tb.ReplyMarkup
+	schedule         tb.Btn
 	chooseOtherGroup tb.Btn
+	settings         tb.Btn
 	unsubscribe      tb.Btn
 }
 
@@ -161,9 +1047,16 @@ type unsubscribedMarkup struct {
 	subscribe tb.Btn
 }
 
+// restorableMarkup is shown to unsubscribed chats which have previous configuration kept
+type restorableMarkup struct {
+	*tb.ReplyMarkup
+	restore tb.Btn
+}
+
 type mainMarkups struct {
 	subscribed   subscribedMarkup
 	unsubscribed unsubscribedMarkup
+	restorable   restorableMarkup
 }
 
 type groupsMarkup struct {
@@ -172,31 +1065,137 @@ type groupsMarkup struct {
 	backBtn            tb.Btn
 }
 
+type settingsButtons struct {
+	toggleBtns map[models.Setting]tb.Btn
+	backBtn    tb.Btn
+
+	// cache holds markup per settings combination. Sharing markups is safe, as telebot copies them before sending
+	cacheMx sync.Mutex
+	cache   map[models.Settings]*tb.ReplyMarkup
+}
+
+// updateFooter is attached to scheduled schedule updates, so each group of the update can be muted or managed in one
+// tap. Buttons carry the group number as callback data. It is shared by the bot and the sender, which send the
+// markups and handle their buttons
+type updateFooter struct {
+	group       tb.Btn
+	mute        tb.Btn
+	unsubscribe tb.Btn
+	// settings button shares unique with the main menu one, so the same handler opens the settings screen
+	settings tb.Btn
+
+	// cache holds footer per groups combination. Sharing markups is safe, as telebot copies them before sending
+	cacheMx sync.Mutex
+	cache   map[string]*tb.ReplyMarkup
+}
+
+// footerMuteDuration is how long "🔕" button in the update footer mutes the group for
+const footerMuteDuration = 3 * time.Hour
+
+func newUpdateFooter() *updateFooter {
+	return &updateFooter{
+		group:       tb.Btn{Unique: footerGroupCallback},
+		mute:        tb.Btn{Text: fmt.Sprintf("🔕 %d год", int(footerMuteDuration.Hours())), Unique: footerMuteCallback},
+		unsubscribe: tb.Btn{Unique: footerUnsubscribeCallback},
+		settings:    tb.Btn{Text: "⚙️ Налаштування", Unique: settingsCallback},
+		cache:       make(map[string]*tb.ReplyMarkup),
+	}
+}
+
+// markup returns footer with a row of buttons per group, in the given order
+func (f *updateFooter) markup(groups []string) *tb.ReplyMarkup {
+	key := strings.Join(groups, ",")
+	f.cacheMx.Lock()
+	defer f.cacheMx.Unlock()
+	if cached, ok := f.cache[key]; ok {
+		return cached
+	}
+
+	m := &tb.ReplyMarkup{}
+	rows := make([]tb.Row, 0, len(groups))
+	for _, groupNum := range groups {
+		rows = append(rows, m.Row(
+			m.Data("⚙️ Група "+groupNum, f.group.Unique, groupNum),
+			m.Data(f.mute.Text, f.mute.Unique, groupNum),
+		))
+	}
+	m.Inline(rows...)
+	f.cache[key] = m
+	return m
+}
+
+// groupMarkup returns actions for the group opened by "⚙️ Група" footer button
+func (f *updateFooter) groupMarkup(groupNum string) *tb.ReplyMarkup {
+	m := &tb.ReplyMarkup{}
+	m.Inline(
+		m.Row(m.Data(f.mute.Text, f.mute.Unique, groupNum)),
+		m.Row(m.Data("Відписатись від групи "+groupNum, f.unsubscribe.Unique, groupNum)),
+		m.Row(f.settings),
+	)
+	return m
+}
+
+// newChooseGroupMarkup opens groups list from messages sent by the bot on its own, e.g. when subscribed groups are gone
+func newChooseGroupMarkup() *tb.ReplyMarkup {
+	m := &tb.ReplyMarkup{}
+	// button shares unique with the main menu one, so the same handler shows groups
+	m.Inline(m.Row(m.Data("Обрати групу", chooseOtherGroupCallback)))
+	return m
+}
+
+// confirmMarkup asks bot admin to confirm a command which can't be undone
+type confirmMarkup struct {
+	*tb.ReplyMarkup
+	confirm tb.Btn
+}
+
+func newConfirmMarkup(unique string) confirmMarkup {
+	m := &tb.ReplyMarkup{}
+	confirm := m.Data("Підтвердити", unique)
+	m.Inline(m.Row(confirm))
+	return confirmMarkup{ReplyMarkup: m, confirm: confirm}
+}
+
 type markups struct {
-	main   mainMarkups
-	groups groupsMarkup
+	main        mainMarkups
+	groups      groupsMarkup
+	settings    settingsButtons
+	help        helpMarkups
+	footer      *updateFooter
+	resyncState confirmMarkup
 }
 
-func newMarkups(subscriptionGroupsCount int) *markups {
+func newMarkups(subscriptionGroupsCount int, footer *updateFooter) *markups {
 	mainSubscribed := &tb.ReplyMarkup{}
-	chooseOtherGroupBtn := mainSubscribed.Data("Обрати іншу групу", "choose_other_group")
-	unsubscribeBtn := mainSubscribed.Data("Відписатись", "unsubscribe")
+	scheduleBtn := mainSubscribed.Data("Поточний графік", scheduleCallback)
+	chooseOtherGroupBtn := mainSubscribed.Data("Обрати іншу групу", chooseOtherGroupCallback)
+	settingsBtn := mainSubscribed.Data("Налаштування", settingsCallback)
+	unsubscribeBtn := mainSubscribed.Data("Відписатись", unsubscribeCallback)
 	mainSubscribed.Inline(
+		mainSubscribed.Row(scheduleBtn),
 		mainSubscribed.Row(chooseOtherGroupBtn),
+		mainSubscribed.Row(settingsBtn),
 		mainSubscribed.Row(unsubscribeBtn),
 	)
 
 	mainUnsubscribed := &tb.ReplyMarkup{}
-	subscribeBtn := mainUnsubscribed.Data("Підписатись на оновлення", "subscribe")
+	subscribeBtn := mainUnsubscribed.Data("Підписатись на оновлення", subscribeCallback)
 	mainUnsubscribed.Inline(mainUnsubscribed.Row(subscribeBtn))
 
+	mainRestorable := &tb.ReplyMarkup{}
+	restoreBtn := mainRestorable.Data("Відновити попередні налаштування", restoreCallback)
+	mainRestorable.Inline(
+		mainRestorable.Row(restoreBtn),
+		mainRestorable.Row(subscribeBtn),
+	)
+
 	gm := &tb.ReplyMarkup{}
 	const buttonsPerRow = 5
 	groupBtns := make(map[string]tb.Btn, subscriptionGroupsCount)
 	groupMarkupRows := make([]tb.Row, 0, subscriptionGroupsCount/buttonsPerRow+1)
 	for i := 0; i < subscriptionGroupsCount; i++ {
 		groupNum := strconv.Itoa(i + 1)
-		groupBtns[groupNum] = gm.Data(groupNum, "subscribe_group_"+groupNum)
+		groupBtns[groupNum] = gm.Data(groupNum, subscribeGroupCallback(groupNum))
 
 		rowIndex := i / buttonsPerRow
 		if len(groupMarkupRows) <= rowIndex {
@@ -204,7 +1203,7 @@ func newMarkups(subscriptionGroupsCount int) *markups {
 		}
 		groupMarkupRows[rowIndex] = append(groupMarkupRows[rowIndex], groupBtns[groupNum])
 	}
-	back := gm.Data("Назад", "back")
+	back := gm.Data("Назад", backCallback)
 	groupMarkupRows = append(groupMarkupRows, tb.Row{back})
 	gm.Inline(groupMarkupRows...)
 
@@ -212,20 +1211,87 @@ func newMarkups(subscriptionGroupsCount int) *markups {
 		main: mainMarkups{
 			subscribed: subscribedMarkup{
 				ReplyMarkup:      mainSubscribed,
+				schedule:         scheduleBtn,
 				chooseOtherGroup: chooseOtherGroupBtn,
+				settings:         settingsBtn,
 				unsubscribe:      unsubscribeBtn,
 			},
 			unsubscribed: unsubscribedMarkup{
 				ReplyMarkup: mainUnsubscribed,
 				subscribe:   subscribeBtn,
 			},
+			restorable: restorableMarkup{
+				ReplyMarkup: mainRestorable,
+				restore:     restoreBtn,
+			},
 		},
 		groups: groupsMarkup{
 			ReplyMarkup:        gm,
 			subscribeGroupBtns: groupBtns,
 			backBtn:            back,
 		},
+		settings: settingsButtons{
+			toggleBtns: map[models.Setting]tb.Btn{
+				models.SettingKeepHistory:     {Unique: toggleSettingCallback(models.SettingKeepHistory)},
+				models.SettingAlwaysSilent:    {Unique: toggleSettingCallback(models.SettingAlwaysSilent)},
+				models.SettingTimeFormat:      {Unique: toggleSettingCallback(models.SettingTimeFormat)},
+				models.SettingShowFullDay:     {Unique: toggleSettingCallback(models.SettingShowFullDay)},
+				models.SettingPinnedCountdown: {Unique: toggleSettingCallback(models.SettingPinnedCountdown)},
+			},
+			backBtn: back,
+			cache:   make(map[models.Settings]*tb.ReplyMarkup),
+		},
+		help:        newHelpMarkups(),
+		footer:      footer,
+		resyncState: newConfirmMarkup(resyncStateCallback),
+	}
+}
+
+// settingsMarkup returns markup with button labels reflecting the settings values. There are only a few settings
+// combinations, so markups are built once per combination
+func (m *markups) settingsMarkup(settings models.Settings) *tb.ReplyMarkup {
+	m.settings.cacheMx.Lock()
+	defer m.settings.cacheMx.Unlock()
+
+	if markup, ok := m.settings.cache[settings]; ok {
+		return markup
 	}
+	markup := m.buildSettingsMarkup(settings)
+	m.settings.cache[settings] = markup
+	return markup
+}
+
+func (m *markups) buildSettingsMarkup(settings models.Settings) *tb.ReplyMarkup {
+	markup := &tb.ReplyMarkup{}
+	keepHistory := m.settings.toggleBtns[models.SettingKeepHistory]
+	keepHistory.Text = "Видаляти попередні графіки " + checkMark(!settings.KeepHistory)
+	alwaysSilent := m.settings.toggleBtns[models.SettingAlwaysSilent]
+	alwaysSilent.Text = "Завжди без звуку " + checkMark(settings.AlwaysSilent)
+	timeFormat := m.settings.toggleBtns[models.SettingTimeFormat]
+	timeFormat.Text = "Формат часу: 24 год"
+	if settings.TwelveHourTime {
+		timeFormat.Text = "Формат часу: 12 год (AM/PM)"
+	}
+	showFullDay := m.settings.toggleBtns[models.SettingShowFullDay]
+	showFullDay.Text = "Показувати весь день " + checkMark(settings.ShowFullDay)
+	pinnedCountdown := m.settings.toggleBtns[models.SettingPinnedCountdown]
+	pinnedCountdown.Text = "Закріплений відлік до зміни " + checkMark(settings.PinnedCountdown)
+	markup.Inline(
+		markup.Row(keepHistory),
+		markup.Row(alwaysSilent),
+		markup.Row(timeFormat),
+		markup.Row(showFullDay),
+		markup.Row(pinnedCountdown),
+		markup.Row(m.settings.backBtn),
+	)
+	return markup
+}
+
+func checkMark(enabled bool) string {
+	if enabled {
+		return "✅"
+	}
+	return "❌"
 }
 
 func (m *markups) chooseGroupBtns() []tb.Btn {
@@ -235,6 +1301,16 @@ func (m *markups) chooseGroupBtns() []tb.Btn {
 	}
 }
 
+func (m *markups) settingsBtns() []tb.Btn {
+	return []tb.Btn{
+		m.main.subscribed.settings,
+	}
+}
+
+func (m *markups) toggleSettingBtns() map[models.Setting]tb.Btn {
+	return m.settings.toggleBtns
+}
+
 func (m *markups) unsubscribeBtns() []tb.Btn {
 	return []tb.Btn{
 		m.main.subscribed.unsubscribe,
@@ -250,16 +1326,83 @@ func (m *markups) backToMainBtns() []tb.Btn {
 }
 
 type messageSender struct {
-	bot            *tb.Bot
-	blockedHandler BlockedByUserHandler
+	bot             *tb.Bot
+	blockedHandler  BlockedByUserHandler
+	migratedHandler ChatMigratedHandler
+	footer          *updateFooter
+	chooseGroup     *tb.ReplyMarkup
+}
+
+func (s *messageSender) Send(chatID int64, msg string, silent bool) (int, error) {
+	return s.send(chatID, msg, &tb.SendOptions{
+		DisableNotification:   silent,
+		DisableWebPagePreview: true,
+	})
+}
+
+// SendUpdate sends schedule update with the footer to mute or manage its groups
+func (s *messageSender) SendUpdate(chatID int64, msg string, groups []string, silent bool) (int, error) {
+	return s.send(chatID, msg, &tb.SendOptions{
+		DisableNotification:   silent,
+		DisableWebPagePreview: true,
+		ReplyMarkup:           s.footer.markup(groups),
+	})
 }
 
-func (s *messageSender) Send(chatID int64, msg string) error {
-	_, err := s.bot.Send(tb.ChatID(chatID), msg)
-	if errors.Is(err, tb.ErrBlockedByUser) {
-		slog.Debug("bot is banned, removing subscriber and all related data", "chatID", chatID)
+// SendChooseGroup sends message with a button to choose other group
+func (s *messageSender) SendChooseGroup(chatID int64, msg string, silent bool) (int, error) {
+	return s.send(chatID, msg, &tb.SendOptions{
+		DisableNotification:   silent,
+		DisableWebPagePreview: true,
+		ReplyMarkup:           s.chooseGroup,
+	})
+}
+
+func (s *messageSender) send(chatID int64, msg string, opts *tb.SendOptions) (int, error) {
+	m, err := s.bot.Send(tb.ChatID(chatID), msg, opts)
+	if isForbidden(err) {
+		slog.Debug("bot is banned, disabling subscriber", "chatID", chatID, "error", err)
 		s.blockedHandler(chatID)
+		return 0, nil
+	}
+	var migrated tb.GroupError
+	if errors.As(err, &migrated) && migrated.MigratedTo != 0 {
+		// the message is not resent right away, as the caller would store its ID for the old chat. The new chat gets
+		// it with the next run
+		slog.Info("chat was upgraded to supergroup", "chatID", chatID, "migratedTo", migrated.MigratedTo)
+		s.migratedHandler(chatID, migrated.MigratedTo)
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return m.ID, nil
+}
+
+// isForbidden returns true if bot can't send messages to the chat anymore (blocked by user or kicked from group)
+func isForbidden(err error) bool {
+	return errors.Is(err, tb.ErrBlockedByUser) ||
+		errors.Is(err, tb.ErrKickedFromGroup) ||
+		errors.Is(err, tb.ErrKickedFromSuperGroup) ||
+		errors.Is(err, tb.ErrKickedFromChannel)
+}
+
+func (s *messageSender) Delete(chatID int64, messageID int) error {
+	return s.bot.Delete(storedMessage(chatID, messageID))
+}
+
+func (s *messageSender) Edit(chatID int64, messageID int, msg string) error {
+	_, err := s.bot.Edit(storedMessage(chatID, messageID), msg, tb.NoPreview)
+	if errors.Is(err, tb.ErrSameMessageContent) || errors.Is(err, tb.ErrMessageNotModified) {
 		return nil
 	}
 	return err
 }
+
+func (s *messageSender) Pin(chatID int64, messageID int) error {
+	return s.bot.Pin(storedMessage(chatID, messageID), tb.Silent)
+}
+
+func storedMessage(chatID int64, messageID int) tb.StoredMessage {
+	return tb.StoredMessage{MessageID: strconv.Itoa(messageID), ChatID: chatID}
+}
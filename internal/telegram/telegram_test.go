@@ -0,0 +1,423 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/Roma7-7-7/sso-notifier/models"
+)
+
+func TestMarkups_settingsMarkup(t *testing.T) {
+	m := newMarkups(18, newUpdateFooter())
+	settings := models.Settings{KeepHistory: true, TwelveHourTime: true}
+
+	got := m.settingsMarkup(settings)
+	if got != m.settingsMarkup(settings) {
+		t.Errorf("settingsMarkup() is not cached")
+	}
+	if want := m.buildSettingsMarkup(settings); !reflect.DeepEqual(got, want) {
+		t.Errorf("settingsMarkup() = %v, want %v", got, want)
+	}
+	if got == m.settingsMarkup(models.Settings{}) {
+		t.Errorf("settingsMarkup() returns the same markup for different settings")
+	}
+}
+
+func BenchmarkMarkups_settingsMarkup(b *testing.B) {
+	m := newMarkups(18, newUpdateFooter())
+	settings := models.Settings{AlwaysSilent: true}
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.settingsMarkup(settings)
+		}
+	})
+	b.Run("build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.buildSettingsMarkup(settings)
+		}
+	})
+}
+
+type fakeSubscriptionService struct {
+	SubscriptionService
+	subs      map[int64]models.Subscription
+	muted     map[string]time.Time
+	schedules map[string]string
+}
+
+func (s *fakeSubscriptionService) GetSubscription(chatID int64) (models.Subscription, bool, error) {
+	sub, ok := s.subs[chatID]
+	return sub, ok, nil
+}
+
+// GroupSchedule reports no schedule until schedules are set, like the service before the first table is loaded
+func (s *fakeSubscriptionService) GroupSchedule(groupNum string, _ bool) (string, bool, error) {
+	msg, ok := s.schedules[groupNum]
+	return msg, ok, nil
+}
+
+func (s *fakeSubscriptionService) MuteGroup(chatID int64, groupNum string, until time.Time) (models.Subscription, error) {
+	sub, ok := s.subs[chatID]
+	if !ok {
+		return models.Subscription{}, models.ErrSubscriptionNotFound
+	}
+	if _, ok = sub.Groups[groupNum]; !ok {
+		return models.Subscription{}, models.ErrInvalidGroup
+	}
+	s.muted[groupNum] = until
+	return sub, nil
+}
+
+func (s *fakeSubscriptionService) Mute(chatID int64, until time.Time) (models.Subscription, error) {
+	sub, ok := s.subs[chatID]
+	if !ok {
+		return models.Subscription{}, models.ErrSubscriptionNotFound
+	}
+	sub.MutedUntil = until
+	s.subs[chatID] = sub
+	return sub, nil
+}
+
+func (s *fakeSubscriptionService) RenderSchedule(chatID int64) (string, error) {
+	if _, ok := s.subs[chatID]; !ok {
+		return "", models.ErrSubscriptionNotFound
+	}
+	return "schedule", nil
+}
+
+func (s *fakeSubscriptionService) RenderGroupsSchedule(groupNums []string, settings models.Settings) (string, error) {
+	return fmt.Sprintf("groups %s, 12h %t", strings.Join(groupNums, ","), settings.TwelveHourTime), nil
+}
+
+func (s *fakeSubscriptionService) GroupsCount() int { return 12 }
+
+func (s *fakeSubscriptionService) Disable(chatID int64) error {
+	sub := s.subs[chatID]
+	sub.DisabledAt = time.Now()
+	s.subs[chatID] = sub
+	return nil
+}
+
+func TestSSOBot_SendToHandler(t *testing.T) {
+	// chat 2 blocked the bot, chat 3 was upgraded to a supergroup
+	sent := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		switch params["chat_id"] {
+		case "2":
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`))
+		case "3":
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,` +
+				`"description":"Bad Request: group chat was upgraded to a supergroup chat",` +
+				`"parameters":{"migrate_to_chat_id":-1003}}`))
+		default:
+			sent[params["chat_id"]] = params["text"]
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`))
+		}
+	}))
+	defer server.Close()
+
+	bot, err := tb.NewBot(tb.Settings{URL: server.URL, Token: "token", Offline: true})
+	if err != nil {
+		t.Fatalf("NewBot() error = %v", err)
+	}
+	service := &fakeSubscriptionService{subs: map[int64]models.Subscription{
+		1: {ChatID: 1}, 2: {ChatID: 2}, 3: {ChatID: 3}, 5: {ChatID: 5, Settings: models.Settings{TwelveHourTime: true}},
+	}}
+	b := &SSOBot{bot: bot, subscriptionService: service}
+
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{name: "delivered", payload: "1", want: "Графік надіслано чату 1"},
+		{name: "blocked", payload: "2", want: "Чат 2 заблокував бота"},
+		{name: "migrated", payload: "3", want: "Чат 3 перетворено на супергрупу -1003"},
+		{name: "not subscribed", payload: "4", want: "Чат 4 не має підписки"},
+		{name: "groups", payload: "5 1,7", want: "Графік надіслано чату 5"},
+		{name: "groups not subscribed", payload: "4 1", want: "Чат 4 не має підписки"},
+		{name: "unknown group", payload: "5 13", want: "Невідома група: 13"},
+		{name: "usage", payload: "", want: "Використання"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeContext{chat: &tb.Chat{ID: 100}, message: &tb.Message{Payload: tt.payload}}
+			if err := b.SendToHandler(c); err != nil {
+				t.Fatalf("SendToHandler() error = %v", err)
+			}
+			if len(c.sent) != 1 || !strings.HasPrefix(c.sent[0], tt.want) {
+				t.Errorf("sent = %q, want %q", c.sent, tt.want)
+			}
+		})
+	}
+
+	want := map[string]string{"1": "schedule", "5": "groups 1,7, 12h true"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("bot sent %v, want %v", sent, want)
+	}
+	if service.subs[2].IsDisabled() {
+		t.Errorf("blocked target is disabled, want its state not changed")
+	}
+}
+
+func TestSSOBot_FooterMuteHandler(t *testing.T) {
+	service := &fakeSubscriptionService{
+		subs:  map[int64]models.Subscription{1: {ChatID: 1, Groups: map[string]string{"7": ""}}},
+		muted: make(map[string]time.Time),
+	}
+	b := &SSOBot{
+		markups:             newMarkups(18, newUpdateFooter()),
+		location:            time.UTC,
+		subscriptionService: service,
+	}
+
+	tests := []struct {
+		name   string
+		chatID int64
+		group  string
+		want   string
+	}{
+		{name: "subscribed group", chatID: 1, group: "7", want: "🔕 Сповіщення групи 7 вимкнено до "},
+		{name: "other group", chatID: 1, group: "3", want: "Ви не підписані на цю групу"},
+		{name: "not subscribed", chatID: 2, group: "7", want: "Ви не підписані на оновлення"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeContext{chat: &tb.Chat{ID: tt.chatID}, callback: &tb.Callback{Data: tt.group}}
+			if err := b.FooterMuteHandler(c); err != nil {
+				t.Fatalf("FooterMuteHandler() error = %v", err)
+			}
+			if len(c.sent) != 1 || !strings.HasPrefix(c.sent[0], tt.want) {
+				t.Errorf("sent = %q, want %q", c.sent, tt.want)
+			}
+		})
+	}
+
+	if until := service.muted["7"]; until.Sub(time.Now()) > footerMuteDuration || until.Before(time.Now()) {
+		t.Errorf("group muted until %v, want in %v", until, footerMuteDuration)
+	}
+	if _, ok := service.muted["3"]; ok {
+		t.Error("not subscribed group is muted")
+	}
+}
+
+func TestUpdateFooter_markup(t *testing.T) {
+	f := newUpdateFooter()
+	got := f.markup([]string{"3", "7"})
+	if got != f.markup([]string{"3", "7"}) {
+		t.Errorf("markup() is not cached")
+	}
+	if len(got.InlineKeyboard) != 2 {
+		t.Fatalf("markup() rows = %d, want a row per group", len(got.InlineKeyboard))
+	}
+	for i, groupNum := range []string{"3", "7"} {
+		for _, btn := range got.InlineKeyboard[i] {
+			if btn.Data != groupNum {
+				t.Errorf("button %q data = %q, want %q", btn.Text, btn.Data, groupNum)
+			}
+		}
+	}
+}
+
+func TestParseMuteUntil(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		val     string
+		want    time.Time
+		wantErr error
+	}{
+		{name: "time", val: "18:00", want: time.Date(2024, 6, 10, 18, 0, 0, 0, time.UTC)},
+		{name: "next minute", val: "12:31", want: time.Date(2024, 6, 10, 12, 31, 0, 0, time.UTC)},
+		{name: "current minute", val: "12:30", wantErr: errPastMuteTime},
+		{name: "past time", val: "09:00", wantErr: errPastMuteTime},
+		{name: "invalid time", val: "25:00", wantErr: errInvalidMuteTime},
+		{name: "malformed time", val: "18:", wantErr: errInvalidMuteTime},
+		{name: "duration", val: "2h", want: now.Add(2 * time.Hour)},
+		{name: "compound duration", val: "1h30m", want: now.Add(90 * time.Minute)},
+		{name: "max duration", val: "168h", want: now.Add(maxMuteDuration)},
+		{name: "too long", val: "169h", wantErr: errMuteDurationTooLong},
+		{name: "zero duration", val: "0s", wantErr: errInvalidMuteDuration},
+		{name: "negative duration", val: "-1h", wantErr: errInvalidMuteDuration},
+		{name: "invalid duration", val: "soon", wantErr: errInvalidMuteDuration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMuteUntil(tt.val, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseMuteUntil(%q) error = %v, want %v", tt.val, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseMuteUntil(%q) = %v, want %v", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSOBot_MuteHandler(t *testing.T) {
+	service := &fakeSubscriptionService{subs: map[int64]models.Subscription{1: {ChatID: 1}}}
+	b := &SSOBot{
+		markups:             newMarkups(18, newUpdateFooter()),
+		location:            time.UTC,
+		subscriptionService: service,
+	}
+
+	tests := []struct {
+		name   string
+		chatID int64
+		args   []string
+		want   string
+	}{
+		{name: "duration", chatID: 1, args: []string{"2h"}, want: "🔕 Сповіщення вимкнено до "},
+		{name: "no args", chatID: 1, want: "Вкажіть час або тривалість"},
+		{name: "too many args", chatID: 1, args: []string{"2h", "30m"}, want: "Вкажіть час або тривалість"},
+		{name: "invalid time", chatID: 1, args: []string{"25:00"}, want: "Невірний формат часу"},
+		{name: "past time", chatID: 1, args: []string{"00:00"}, want: "Цей час уже минув"},
+		{name: "invalid duration", chatID: 1, args: []string{"soon"}, want: "Невірна тривалість"},
+		{name: "too long", chatID: 1, args: []string{"200h"}, want: "Сповіщення можна вимкнути щонайбільше на 7 днів"},
+		{name: "not subscribed", chatID: 2, args: []string{"2h"}, want: "Ви не підписані на оновлення"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeContext{chat: &tb.Chat{ID: tt.chatID}, args: tt.args}
+			if err := b.MuteHandler(c); err != nil {
+				t.Fatalf("MuteHandler() error = %v", err)
+			}
+			if len(c.sent) != 1 || !strings.HasPrefix(c.sent[0], tt.want) {
+				t.Errorf("sent = %q, want %q", c.sent, tt.want)
+			}
+		})
+	}
+
+	if until := service.subs[1].MutedUntil; until.Sub(time.Now()) > 2*time.Hour || until.Before(time.Now()) {
+		t.Errorf("chat muted until %v, want in 2h", until)
+	}
+}
+
+func TestSSOBot_InlineQueryHandler(t *testing.T) {
+	service := &fakeSubscriptionService{}
+	b := &SSOBot{subscriptionService: service}
+
+	answer := func(text string) *tb.QueryResponse {
+		t.Helper()
+		c := &fakeContext{chat: &tb.Chat{ID: 1}, query: &tb.Query{Text: text}}
+		if err := b.InlineQueryHandler(c); err != nil {
+			t.Fatalf("InlineQueryHandler() error = %v", err)
+		}
+		if len(c.answers) != 1 {
+			t.Fatalf("answers = %d, want 1", len(c.answers))
+		}
+		return c.answers[0]
+	}
+
+	if resp := answer("abc"); len(resp.Results) != 0 {
+		t.Errorf("results for not a group = %v, want none", resp.Results)
+	}
+
+	// no schedule loaded yet
+	resp := answer("5")
+	if len(resp.Results) != 1 {
+		t.Fatalf("results = %v, want the unavailable result", resp.Results)
+	}
+	result := resp.Results[0].(*tb.ArticleResult)
+	if result.Text != "Графік для групи 5 недоступний" || result.Content != nil {
+		t.Errorf("result = %+v, want the unavailable text without schedule", result)
+	}
+
+	service.schedules = map[string]string{"5": "schedule"}
+	result = answer(" 5 ").Results[0].(*tb.ArticleResult)
+	content, ok := result.Content.(*tb.InputTextMessageContent)
+	if !ok || content.Text != "schedule" || result.ResultID() != "group_5" {
+		t.Errorf("result = %+v, want the group schedule", result)
+	}
+}
+
+type fakeMigrationService struct {
+	MigrationService
+	imported []int64
+}
+
+func (s *fakeMigrationService) Import(chatID int64, _ models.ChatType, _ string) (models.Subscription, error) {
+	s.imported = append(s.imported, chatID)
+	return models.Subscription{ChatID: chatID, Groups: map[string]string{"5": ""}}, nil
+}
+
+type fakeMaintenance struct {
+	MaintenanceService
+	enabled bool
+}
+
+func (m fakeMaintenance) IsEnabled() bool { return m.enabled }
+func (m fakeMaintenance) Banner() string  { return "maintenance" }
+
+func TestRoutes_StartImport(t *testing.T) {
+	// the sender is an admin of chat -1 only
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/getChatMember") {
+			var params map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&params)
+			status := "member"
+			if params["chat_id"] == "-1" {
+				status = "administrator"
+			}
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"status":"` + status + `","user":{"id":10}}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		chat        *tb.Chat
+		maintenance bool
+		imported    bool
+	}{
+		{name: "private chat", chat: &tb.Chat{ID: 10, Type: tb.ChatPrivate}, imported: true},
+		{name: "group chat admin", chat: &tb.Chat{ID: -1, Type: tb.ChatGroup}, imported: true},
+		{name: "group chat member", chat: &tb.Chat{ID: -2, Type: tb.ChatGroup}},
+		{name: "maintenance", chat: &tb.Chat{ID: 10, Type: tb.ChatPrivate}, maintenance: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, err := tb.NewBot(tb.Settings{URL: server.URL, Token: "token", Offline: true, Synchronous: true,
+				OnError: func(err error, _ tb.Context) { t.Errorf("handler error = %v", err) }})
+			if err != nil {
+				t.Fatalf("NewBot() error = %v", err)
+			}
+			migration := &fakeMigrationService{}
+			b := &SSOBot{
+				bot:                bot,
+				markups:            newMarkups(12, newUpdateFooter()),
+				admins:             newAdminSet(nil),
+				maintenanceService: fakeMaintenance{enabled: tt.maintenance},
+				migrationService:   migration,
+				sources:            newPendingSources(),
+			}
+			b.routes()
+
+			bot.ProcessUpdate(tb.Update{Message: &tb.Message{
+				Sender: &tb.User{ID: 10},
+				Chat:   tt.chat,
+				Text:   "/start import_token",
+			}})
+
+			if imported := len(migration.imported) == 1; imported != tt.imported {
+				t.Errorf("imported = %v, want %v", migration.imported, tt.imported)
+			}
+		})
+	}
+}
@@ -1,45 +1,474 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Roma7-7-7/sso-notifier/internal/api"
 	"github.com/Roma7-7-7/sso-notifier/internal/dal"
 	"github.com/Roma7-7-7/sso-notifier/internal/providers"
 	"github.com/Roma7-7-7/sso-notifier/internal/service"
 	"github.com/Roma7-7-7/sso-notifier/internal/service/communication"
+	"github.com/Roma7-7-7/sso-notifier/internal/service/maintenance"
 	"github.com/Roma7-7-7/sso-notifier/internal/service/shutdowns"
 	"github.com/Roma7-7-7/sso-notifier/internal/service/subscription"
+	"github.com/Roma7-7-7/sso-notifier/internal/service/summary"
+	"github.com/Roma7-7-7/sso-notifier/internal/service/suspension"
 	"github.com/Roma7-7-7/sso-notifier/internal/telegram"
+	"github.com/Roma7-7-7/sso-notifier/pkg/clock"
 )
 
+const defaultShutdownGracePeriod = 10 * time.Second
+
+const selfCheckTimeout = 30 * time.Second
+
+// subscriptionsSnapshotTTL lets tasks running within a few seconds share one read of all subscriptions
+const subscriptionsSnapshotTTL = 10 * time.Second
+
 func main() {
-	store := dal.NewBoltDBStore("data/app.db")
+	configPath := flag.String("config", "", "optional JSON file with configuration; environment variables take precedence")
+	flag.Parse()
+	cfg := mustLoadConfig(*configPath)
+	gracePeriod := mustShutdownGracePeriod()
+	readOnly := mustReadOnly()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var store *dal.BoltDBStore
+	if readOnly {
+		slog.Info("starting in read only mode")
+		store = dal.NewReadOnlyBoltDBStore("data/app.db")
+	} else {
+		store = dal.NewBoltDBStore("data/app.db")
+	}
 	defer store.Close()
+	mustSelfCheck(ctx, store)
 
-	bb := telegram.NewBotBuilder()
+	bb := telegram.NewBotBuilder().ReadOnly(readOnly)
 
-	subRepo := dal.NewSubscriptionRepo(store)
+	subRepo := dal.NewCachedSubscriptionRepo(dal.NewSubscriptionRepo(store), subscriptionsSnapshotTTL)
 	shutdownsRepo := dal.NewShutdownsRepo(store)
 	notificationRepo := dal.NewNotificationRepo(store)
+	channelPostRepo := dal.NewChannelPostRepo(store)
+	maintenanceRepo := dal.NewMaintenanceRepo(store)
+	tombstoneRepo := dal.NewTombstoneRepo(store)
+	purgeLogRepo := dal.NewPurgeLogRepo(store)
+	suspendedGroupsRepo := dal.NewSuspendedGroupsRepo(store)
+
+	// sender reports chats it can't send messages to or which got new IDs, and subscription service needs sender,
+	// so it is set below
+	var subService *subscription.Service
+	summaryCounters := summary.NewCounters()
+	sender := summary.NewCountingSender(bb.Sender(func(chatID int64) {
+		if err := subService.Disable(chatID); err != nil {
+			slog.Error("failed to disable subscription", "chatID", chatID, "error", err)
+		}
+	}, func(from, to int64) {
+		if err := subService.Migrate(from, to); err != nil {
+			slog.Error("failed to migrate subscription", "from", from, "to", to, "error", err)
+		}
+	}), summaryCounters)
+	adminIDs := mustAdminIDs()
+	adminNotifier := communication.NewAdminNotifier(notificationRepo, adminIDs)
+	shutdownsService := shutdowns.NewShutdownsService(
+		shutdownsRepo, dal.NewScheduleRevisionRepo(store), providers.ChernivtsiShutdowns, adminNotifier,
+		subscription.GroupsCount,
+	)
+	suspensionService, err := suspension.NewSuspensionService(suspendedGroupsRepo, subRepo, notificationRepo)
+	if err != nil {
+		slog.Error("failed to create suspension service", "error", err)
+		panic(err)
+	}
+	notificationService := communication.NewNotificationService(notificationRepo, subRepo, sender)
+	subService = subscription.NewSubscriptionService(
+		subRepo, tombstoneRepo, purgeLogRepo, dal.NewDeliveryLogRepo(store), shutdownsService, suspensionService, sender,
+		mustSubscriptionOptions(), clock.New(),
+	)
+	migrator := subscription.NewMigrator(subService, dal.NewImportNonceRepo(store), []byte(os.Getenv("EXPORT_SECRET")))
+	channelPublisher := subscription.NewChannelPublisher(
+		channelPostRepo, shutdownsService, sender, suspensionService, mustChannels(),
+	)
+	countdownPublisher := subscription.NewCountdownPublisher(
+		dal.NewCountdownPostRepo(store), subRepo, shutdownsService, sender, suspensionService, clock.New(),
+	)
+	maintenanceService, err := maintenance.NewMaintenanceService(maintenanceRepo)
+	if err != nil {
+		slog.Error("failed to create maintenance service", "error", err)
+		panic(err)
+	}
+
+	summaryService, err := summary.NewSummaryService(
+		dal.NewDailySummaryRepo(store), summaryCounters, subService, adminNotifier, clock.New(),
+	)
+	if err != nil {
+		slog.Error("failed to create summary service", "error", err)
+		panic(err)
+	}
+
+	scheduler, err := service.NewScheduler(
+		shutdownsService, subService, notificationService, channelPublisher, countdownPublisher, maintenanceService,
+		summaryService, adminNotifier, disabledTasks(), clock.New(),
+	)
+	if err != nil {
+		slog.Error("failed to create scheduler", "error", err)
+		panic(err)
+	}
+	// read only instance never sends scheduled messages, so it doesn't duplicate the primary one
+	if !readOnly {
+		scheduler.Start(ctx)
+	}
+
+	bot := bb.Build(
+		subService, maintenanceService, suspensionService, shutdownsService, migrator, scheduler, store, adminIDs,
+	)
+
+	reloader := newConfigReloader(cfg)
+	reloader.subscribe(func(values map[string]string) error {
+		adminIDs, err := parseAdminIDs(values["ADMIN_IDS"])
+		if err != nil {
+			return err
+		}
+		bot.SetAdminIDs(adminIDs)
+		adminNotifier.SetAdminIDs(adminIDs)
+		return nil
+	})
+	reloader.subscribe(func(values map[string]string) error {
+		options, err := parseSubscriptionOptions(func(key string) string { return values[key] })
+		if err != nil {
+			return err
+		}
+		subService.SetOptions(options)
+		return nil
+	})
+	go reloader.run()
 
-	sender := bb.Sender(purgeSubscriber(subRepo))
-	shutdownsService := shutdowns.NewShutdownsService(shutdownsRepo, providers.ChernivtsiShutdowns)
-	notificationService := communication.NewNotificationService(notificationRepo, sender)
-	subService := subscription.NewSubscriptionService(subRepo, shutdownsService, sender)
+	pprofServer := startPprof(os.Getenv("PPROF_ADDR"))
+	apiServer := startPublicAPI(os.Getenv("PUBLIC_API_ADDR"), shutdownsService)
 
-	scheduler := service.NewScheduler(shutdownsService, subService, notificationService)
-	go scheduler.SendNotificationsTask()
-	go scheduler.RefreshTable()
-	go scheduler.SendUpdates()
+	go func() {
+		<-ctx.Done()
+		slog.Info("stopping bot")
+		bot.Stop()
+	}()
 
 	slog.Info("Starting bot")
-	bb.Build(subService).Start()
+	bot.Start()
+
+	shutdownServer("pprof", pprofServer, gracePeriod)
+	shutdownServer("public api", apiServer, gracePeriod)
+	if !scheduler.Wait(gracePeriod) {
+		slog.Warn("scheduler tasks didn't stop within grace period, abandoning them", "gracePeriod", gracePeriod)
+	}
+	slog.Info("bot stopped")
+}
+
+// immutableConfigKeys can't be changed without restart
+var immutableConfigKeys = []string{
+	"TOKEN", "TOKEN_FILE", "STALE_UPDATES_CUTOFF", "CHANNELS", "EXPORT_SECRET", "PPROF_ADDR", "SHUTDOWN_GRACE_PERIOD",
+	"READ_ONLY", "SKIP_PROVIDER_CHECK", "DISABLED_TASKS", "PUBLIC_API_ADDR",
+}
+
+// configReloader re-reads configuration on SIGHUP and passes it to subscribers, which validate and apply
+// the values they own
+type configReloader struct {
+	cfg         config
+	subscribers []func(values map[string]string) error
+}
+
+func (r *configReloader) subscribe(fn func(values map[string]string) error) {
+	r.subscribers = append(r.subscribers, fn)
+}
+
+func (r *configReloader) run() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		slog.Info("reloading config")
+		values, err := r.cfg.load()
+		if err != nil {
+			slog.Error("failed to reload config", "error", err)
+			continue
+		}
+
+		for _, key := range immutableConfigKeys {
+			if values[key] != os.Getenv(key) {
+				slog.Warn("config value can't be changed without restart", "key", key)
+			}
+		}
+		for _, fn := range r.subscribers {
+			if err = fn(values); err != nil {
+				slog.Error("failed to apply reloaded config", "error", err)
+			}
+		}
+	}
+}
+
+func newConfigReloader(cfg config) *configReloader {
+	return &configReloader{cfg: cfg}
+}
+
+// config is a JSON object with the same keys as environment variables, e.g. {"TOKEN_FILE": "/etc/bot/token"}.
+// Environment variables take precedence over the file values
+type config struct {
+	path string
+	env  map[string]string
+}
+
+// load reads config file and merges it with environment variables captured on startup
+func (c config) load() (map[string]string, error) {
+	res := make(map[string]string)
+	if c.path != "" {
+		data, err := os.ReadFile(c.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file=%s: %w", c.path, err)
+		}
+		if err = json.Unmarshal(data, &res); err != nil {
+			return nil, fmt.Errorf("failed to parse config file=%s: %w", c.path, err)
+		}
+	}
+	for key, val := range c.env {
+		if val != "" {
+			res[key] = val
+		}
+	}
+	return res, nil
+}
+
+// mustLoadConfig exports config file values which are not set in the environment already
+func mustLoadConfig(path string) config {
+	res := config{path: path, env: make(map[string]string)}
+	for _, kv := range os.Environ() {
+		key, val, _ := strings.Cut(kv, "=")
+		res.env[key] = val
+	}
+
+	values, err := res.load()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		panic(err)
+	}
+	for key, val := range values {
+		if os.Getenv(key) != "" {
+			continue
+		}
+		if err = os.Setenv(key, val); err != nil {
+			slog.Error("failed to set config value", "key", key, "error", err)
+			panic(fmt.Errorf("set config value=%s: %w", key, err))
+		}
+	}
+
+	return res
+}
+
+// mustShutdownGracePeriod parses SHUTDOWN_GRACE_PERIOD environment variable, which limits how long in-flight tasks
+// are waited for on stop
+func mustShutdownGracePeriod() time.Duration {
+	val := os.Getenv("SHUTDOWN_GRACE_PERIOD")
+	if val == "" {
+		return defaultShutdownGracePeriod
+	}
+
+	res, err := time.ParseDuration(val)
+	if err != nil {
+		slog.Error("failed to parse SHUTDOWN_GRACE_PERIOD environment variable", "value", val, "error", err)
+		panic(fmt.Errorf("parse SHUTDOWN_GRACE_PERIOD: %w", err))
+	}
+	return res
+}
+
+// mustReadOnly parses READ_ONLY environment variable. Read only instance serves reads from a synced database copy,
+// e.g. as a hot standby
+func mustReadOnly() bool {
+	val := os.Getenv("READ_ONLY")
+	if val == "" {
+		return false
+	}
+
+	res, err := strconv.ParseBool(val)
+	if err != nil {
+		slog.Error("failed to parse READ_ONLY environment variable", "value", val, "error", err)
+		panic(fmt.Errorf("parse READ_ONLY: %w", err))
+	}
+	return res
+}
+
+// mustSelfCheck aborts startup if the store or the shutdowns provider is not usable. SKIP_PROVIDER_CHECK skips
+// the provider check, e.g. for offline development
+func mustSelfCheck(ctx context.Context, store *dal.BoltDBStore) {
+	var errs []error
+	if err := store.Check(); err != nil {
+		errs = append(errs, fmt.Errorf("store: %w", err))
+	}
+
+	skipProvider := false
+	if val := os.Getenv("SKIP_PROVIDER_CHECK"); val != "" {
+		var err error
+		if skipProvider, err = strconv.ParseBool(val); err != nil {
+			errs = append(errs, fmt.Errorf("parse SKIP_PROVIDER_CHECK: %w", err))
+		}
+	}
+	if !skipProvider {
+		ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+		defer cancel()
+		if err := providers.CheckChernivtsiShutdowns(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("provider: %w", err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		slog.Error("startup self check failed", "error", err)
+		panic(fmt.Errorf("self check: %w", err))
+	}
 }
 
-func purgeSubscriber(subRepo subscription.Repository) func(chatID int64) {
-	return func(chatID int64) {
-		if err := subRepo.Purge(chatID); err != nil {
-			slog.Error("failed to purge subscription", "chatID", chatID, "error", err)
+// disabledTasks parses DISABLED_TASKS environment variable, a comma separated list of scheduler task names. Parsing
+// can't fail, unknown names are rejected by service.NewScheduler
+func disabledTasks() []string {
+	res := make([]string, 0)
+	for _, name := range strings.Split(os.Getenv("DISABLED_TASKS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			res = append(res, name)
 		}
 	}
+	return res
+}
+
+// mustChannels parses CHANNELS environment variable in format "<group>:<channelID>,<group>:<channelID>"
+func mustChannels() map[string]int64 {
+	res := make(map[string]int64)
+
+	val := os.Getenv("CHANNELS")
+	if val == "" {
+		return res
+	}
+
+	// channel posts are stored by channel ID, so a channel can publish only one group
+	seen := make(map[int64]bool)
+	for _, pair := range strings.Split(val, ",") {
+		group, channel, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			slog.Error("invalid CHANNELS environment variable entry", "entry", pair)
+			panic(fmt.Errorf("invalid CHANNELS entry=%s", pair))
+		}
+		channelID, err := strconv.ParseInt(channel, 10, 64)
+		if err != nil {
+			slog.Error("invalid channel ID in CHANNELS environment variable", "entry", pair, "error", err)
+			panic(fmt.Errorf("parse CHANNELS entry=%s: %w", pair, err))
+		}
+		if seen[channelID] {
+			slog.Error("channel is used by more than one group in CHANNELS environment variable", "entry", pair)
+			panic(fmt.Errorf("duplicate channel in CHANNELS entry=%s", pair))
+		}
+		seen[channelID] = true
+		res[group] = channelID
+	}
+
+	return res
+}
+
+// mustAdminIDs parses ADMIN_IDS environment variable
+func mustAdminIDs() []int64 {
+	res, err := parseAdminIDs(os.Getenv("ADMIN_IDS"))
+	if err != nil {
+		slog.Error("invalid ADMIN_IDS environment variable", "error", err)
+		panic(err)
+	}
+	return res
+}
+
+// parseAdminIDs parses admin IDs in format "<chatID>,<chatID>"
+func parseAdminIDs(val string) ([]int64, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	res := make([]int64, 0)
+	for _, id := range strings.Split(val, ",") {
+		chatID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse ADMIN_IDS entry=%s: %w", id, err)
+		}
+		res = append(res, chatID)
+	}
+
+	return res, nil
+}
+
+// mustSubscriptionOptions parses NOTIFICATION_WINDOW and TOMBSTONE_RETENTION environment variables
+func mustSubscriptionOptions() subscription.Options {
+	res, err := parseSubscriptionOptions(os.Getenv)
+	if err != nil {
+		slog.Error("invalid subscription options", "error", err)
+		panic(err)
+	}
+	return res
+}
+
+// parseSubscriptionOptions parses notification window in format "<start hour>-<end hour>" and tombstone retention
+// duration
+func parseSubscriptionOptions(lookup func(key string) string) (subscription.Options, error) {
+	res := subscription.DefaultOptions()
+
+	if val := lookup("TOMBSTONE_RETENTION"); val != "" {
+		retention, err := time.ParseDuration(val)
+		if err != nil {
+			return res, fmt.Errorf("parse TOMBSTONE_RETENTION=%s: %w", val, err)
+		}
+		if retention < 0 {
+			return res, fmt.Errorf("negative TOMBSTONE_RETENTION=%s", val)
+		}
+		res.TombstoneRetention = retention
+	}
+
+	val := lookup("NOTIFICATION_WINDOW")
+	if val == "" {
+		return res, nil
+	}
+
+	start, end, ok := strings.Cut(val, "-")
+	if !ok {
+		return res, fmt.Errorf("invalid NOTIFICATION_WINDOW=%s", val)
+	}
+	var err error
+	if res.NotificationWindow.StartHour, err = strconv.Atoi(start); err != nil {
+		return res, fmt.Errorf("parse NOTIFICATION_WINDOW=%s start hour: %w", val, err)
+	}
+	if res.NotificationWindow.EndHour, err = strconv.Atoi(end); err != nil {
+		return res, fmt.Errorf("parse NOTIFICATION_WINDOW=%s end hour: %w", val, err)
+	}
+	if err = res.NotificationWindow.Validate(); err != nil {
+		return res, fmt.Errorf("invalid NOTIFICATION_WINDOW=%s: %w", val, err)
+	}
+
+	return res, nil
+}
+
+// startPublicAPI serves public schedule feed in background if PUBLIC_API_ADDR is set. It returns the server to shut
+// down, or nil if the feed is disabled
+func startPublicAPI(addr string, shutdowns api.ShutdownsService) *http.Server {
+	server := api.NewServer(addr, shutdowns)
+	if server == nil {
+		return nil
+	}
+
+	go func() {
+		slog.Info("serving public api", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("public api server failed", "error", err)
+		}
+	}()
+	return server
 }
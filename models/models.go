@@ -4,13 +4,166 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 )
 
 var ErrSubscriptionsLimitReached = errors.New("subscriptions limit reached")
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+var ErrConflict = errors.New("subscription was changed concurrently")
+var ErrInvalidGroup = errors.New("invalid group")
+var ErrInvalidTime = errors.New("invalid time")
+var ErrInvalidPeriod = errors.New("invalid period")
+var ErrExportDisabled = errors.New("export is disabled")
+var ErrInvalidExportToken = errors.New("invalid export token")
+var ErrExportTokenExpired = errors.New("export token expired")
+var ErrExportTokenUsed = errors.New("export token is used already")
+
+type ChatType string
+
+const (
+	ChatTypePrivate    ChatType = "private"
+	ChatTypeGroup      ChatType = "group"
+	ChatTypeSuperGroup ChatType = "supergroup"
+)
 
 type Subscription struct {
-	ChatID int64             `json:"chat_id"`
-	Groups map[string]string `json:"groups"`
+	ChatID        int64             `json:"chat_id"`
+	ChatType      ChatType          `json:"chat_type,omitempty"`
+	Groups        map[string]string `json:"groups"`
+	Settings      Settings          `json:"settings"`
+	LastMessageID int               `json:"last_message_id,omitempty"`
+	MutedUntil    time.Time         `json:"muted_until,omitempty"`
+	// MutedGroups holds groups muted until the time, e.g. from the schedule update footer
+	MutedGroups map[string]time.Time `json:"muted_groups,omitempty"`
+
+	// LastMessageHash is a hash of the last sent schedule text, so identical text is not sent twice
+	LastMessageHash string `json:"last_message_hash,omitempty"`
+
+	// DisabledAt is set when the bot can't send messages to the chat anymore. Disabled subscriptions are probed
+	// periodically and purged after repeated failures
+	DisabledAt    time.Time `json:"disabled_at,omitempty"`
+	ProbedAt      time.Time `json:"probed_at,omitempty"`
+	ProbeFailures int       `json:"probe_failures,omitempty"`
+
+	OnboardingCompleted bool `json:"onboarding_completed,omitempty"`
+
+	Source    string    `json:"source,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+
+	// UpdatesSent and NotificationsSent count delivered schedule updates and queued notifications
+	UpdatesSent       int       `json:"updates_sent,omitempty"`
+	NotificationsSent int       `json:"notifications_sent,omitempty"`
+	LastDeliveredAt   time.Time `json:"last_delivered_at,omitempty"`
+
+	// GroupsLostAt is set when none of the subscribed groups remain in the schedule and the chat was warned about it,
+	// so the warning is sent once. It is reset as soon as any of the groups is back
+	GroupsLostAt time.Time `json:"groups_lost_at,omitempty"`
+
+	// Version is incremented by every write. Subscription is stored only if it wasn't changed since it was read
+	Version int `json:"version,omitempty"`
+}
+
+const SourceOrganic = "organic"
+
+// GroupSuspension stops updates of the group, e.g. while provider publishes obviously wrong data for it
+type GroupSuspension struct {
+	Group  string    `json:"group"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+type PurgeAction string
+
+const (
+	// PurgeActionDisabled is recorded when the bot can't send messages to the chat for the first time
+	PurgeActionDisabled PurgeAction = "disabled"
+	// PurgeActionPurged is recorded when disabled subscription is removed after failed probes
+	PurgeActionPurged PurgeAction = "purged"
+)
+
+// PurgeEvent is a record of a subscription disabled or purged because the bot was blocked. Chat ID is not stored as
+// is, ChatHash is enough to tell whether the same chat is affected repeatedly
+type PurgeEvent struct {
+	ChatHash string      `json:"chat_hash"`
+	Action   PurgeAction `json:"action"`
+	At       time.Time   `json:"at"`
+}
+
+// Tombstone keeps configuration of unsubscribed chat for a while, so it can be restored on re-subscribe
+type Tombstone struct {
+	ChatID         int64     `json:"chat_id"`
+	Groups         []string  `json:"groups"`
+	Settings       Settings  `json:"settings"`
+	UnsubscribedAt time.Time `json:"unsubscribed_at"`
+}
+
+type SubscriptionStats struct {
+	Total    int
+	BySource map[string]int
+	// ByDay is the number of new subscriptions per day (Kyiv date in "2006-01-02" format), the oldest first
+	ByDay []DayCount
+}
+
+type DayCount struct {
+	Date  string
+	Count int
+}
+
+// IsGroupChat returns true for subscriptions made from group chats. Subscriptions created before chat type was
+// tracked are private ones as the bot didn't support group chats back then
+func (s Subscription) IsGroupChat() bool {
+	return s.ChatType == ChatTypeGroup || s.ChatType == ChatTypeSuperGroup
+}
+
+func (s Subscription) IsMuted(now time.Time) bool {
+	return now.Before(s.MutedUntil)
+}
+
+func (s Subscription) IsGroupMuted(groupNum string, now time.Time) bool {
+	return now.Before(s.MutedGroups[groupNum])
+}
+
+func (s Subscription) IsDisabled() bool {
+	return !s.DisabledAt.IsZero()
+}
+
+type Setting string
+
+const (
+	SettingKeepHistory     Setting = "keep_history"
+	SettingAlwaysSilent    Setting = "always_silent"
+	SettingTimeFormat      Setting = "time_format"
+	SettingShowFullDay     Setting = "show_full_day"
+	SettingPinnedCountdown Setting = "pinned_countdown"
+)
+
+type Settings struct {
+	KeepHistory  bool `json:"keep_history,omitempty"`
+	AlwaysSilent bool `json:"always_silent,omitempty"`
+	// TwelveHourTime switches times in messages from 24-hour (default) to 12-hour format
+	TwelveHourTime bool `json:"twelve_hour_time,omitempty"`
+	// ShowFullDay renders schedule from 00:00 instead of hiding periods that are already over
+	ShowFullDay bool `json:"show_full_day,omitempty"`
+	// PinnedCountdown keeps a pinned message with the time left until the next power status change
+	PinnedCountdown bool `json:"pinned_countdown,omitempty"`
+}
+
+func (s *Settings) Toggle(setting Setting) error {
+	switch setting {
+	case SettingKeepHistory:
+		s.KeepHistory = !s.KeepHistory
+	case SettingAlwaysSilent:
+		s.AlwaysSilent = !s.AlwaysSilent
+	case SettingTimeFormat:
+		s.TwelveHourTime = !s.TwelveHourTime
+	case SettingShowFullDay:
+		s.ShowFullDay = !s.ShowFullDay
+	case SettingPinnedCountdown:
+		s.PinnedCountdown = !s.PinnedCountdown
+	default:
+		return fmt.Errorf("unknown setting=%s", setting)
+	}
+	return nil
 }
 
 type Status string
@@ -30,6 +183,42 @@ type Period struct {
 	To   string `json:"to"`
 }
 
+// Validate checks that both period bounds are valid times and the period is not empty
+func (p Period) Validate() error {
+	from, err := ParseMinutes(p.From)
+	if err != nil {
+		return fmt.Errorf("%w %s-%s: %w", ErrInvalidPeriod, p.From, p.To, err)
+	}
+	to, err := ParseMinutes(p.To)
+	if err != nil {
+		return fmt.Errorf("%w %s-%s: %w", ErrInvalidPeriod, p.From, p.To, err)
+	}
+	if from >= to {
+		return fmt.Errorf("%w %s-%s: start is not before end", ErrInvalidPeriod, p.From, p.To)
+	}
+	return nil
+}
+
+// ParseMinutes converts schedule time in strict "HH:MM" format to minutes since midnight. "24:00" is the end of the
+// day, so any later time is rejected
+func ParseMinutes(value string) (int, error) {
+	if len(value) != 5 || value[2] != ':' {
+		return 0, fmt.Errorf("%w=%q: expected HH:MM", ErrInvalidTime, value)
+	}
+	for _, i := range []int{0, 1, 3, 4} {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, fmt.Errorf("%w=%q: expected HH:MM", ErrInvalidTime, value)
+		}
+	}
+
+	hours := int(value[0]-'0')*10 + int(value[1]-'0')
+	minutes := int(value[3]-'0')*10 + int(value[4]-'0')
+	if hours > 24 || minutes > 59 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("%w=%q: out of range", ErrInvalidTime, value)
+	}
+	return hours*60 + minutes, nil
+}
+
 type ShutdownGroup struct {
 	Number int
 	Items  []Status
@@ -60,6 +249,10 @@ type ShutdownsTable struct {
 	Date    string                   `json:"date"`
 	Periods []Period                 `json:"periods"`
 	Groups  map[string]ShutdownGroup `json:"groups"`
+	// UpdatedAt is the time the table was fetched from the provider
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	// Source is the provider page URL
+	Source string `json:"source,omitempty"`
 }
 
 func (s ShutdownsTable) Validate() error {
@@ -69,6 +262,11 @@ func (s ShutdownsTable) Validate() error {
 	if len(s.Periods) == 0 {
 		return fmt.Errorf("shutdowns table periods list is empty")
 	}
+	for _, p := range s.Periods {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("invalid shutdowns table: %w", err)
+		}
+	}
 	for _, g := range s.Groups {
 		if err := g.Validate(len(s.Periods)); err != nil {
 			return fmt.Errorf("invalid shutdowns table group=%v: %w", g, err)
@@ -77,8 +275,156 @@ func (s ShutdownsTable) Validate() error {
 	return nil
 }
 
+// ScheduleRevision is a distinct version of the shutdowns table of a date and the time it was first fetched
+type ScheduleRevision struct {
+	Hash      string    `json:"hash"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
 type Notification struct {
 	ID     int    `json:"id"`
 	Target int64  `json:"target"`
 	Msg    string `json:"message"`
+	// Admin marks notifications to bot admins, which are delivered during maintenance too
+	Admin bool `json:"admin,omitempty"`
+}
+
+// ChannelPost is the daily schedule message published to a group channel. It is stored by the channel ID only: every
+// channel publishes a single group, and only the post of the current table date is ever edited. Once the date changes,
+// a new post is published and replaces the record, while the previous post stays in the channel as is
+type ChannelPost struct {
+	ChannelID int64  `json:"channel_id"`
+	Group     string `json:"group"`
+	Date      string `json:"date"`
+	Hash      string `json:"hash"`
+	MessageID int    `json:"message_id"`
+}
+
+// CountdownPost is the pinned message of the chat with the time left until the next power status change
+type CountdownPost struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+type Maintenance struct {
+	Enabled bool   `json:"enabled"`
+	Text    string `json:"text"`
+}
+
+// DBStats describes storage usage. Partial is set when not all buckets were inspected within the time budget
+type DBStats struct {
+	Buckets       []BucketStats
+	FileSize      int64
+	FreePageRatio float64
+	Partial       bool
+}
+
+type BucketStats struct {
+	Name string
+	Keys int
+	// Size is the number of bytes used by the bucket pages
+	Size int
+}
+
+// DailySummary holds counters for the admin summary of the Kyiv day Date
+type DailySummary struct {
+	Date         string `json:"date"`
+	Sent         int    `json:"sent"`
+	Failed       int    `json:"failed"`
+	TotalAtStart int    `json:"total_at_start"`
+	SummarySent  bool   `json:"summary_sent"`
+}
+
+type Delivery int
+
+const (
+	// DeliveryNone means there was nothing to send
+	DeliveryNone Delivery = iota
+	DeliverySent
+	DeliveryFailed
+	// DeliveryDisabled means the chat blocked the bot, so its subscription is disabled
+	DeliveryDisabled
+	// DeliverySkipped means the chat is not sent to for now, e.g. because it is muted
+	DeliverySkipped
+)
+
+// DeliveryReason details why schedule update was or wasn't delivered to the chat
+type DeliveryReason string
+
+const (
+	ReasonNoChange       DeliveryReason = "no_change"
+	ReasonSameText       DeliveryReason = "same_text"
+	ReasonSuspendedGroup DeliveryReason = "suspended_group"
+	ReasonMalformedGroup DeliveryReason = "malformed_group"
+	ReasonMuted          DeliveryReason = "muted"
+	ReasonAwaitingProbe  DeliveryReason = "awaiting_probe"
+	// ReasonQuietHours means update was sent without sound outside of the notification window
+	ReasonQuietHours   DeliveryReason = "quiet_hours"
+	ReasonRenderFailed DeliveryReason = "render_failed"
+	ReasonSendFailed   DeliveryReason = "send_failed"
+	ReasonStoreFailed  DeliveryReason = "store_failed"
+	ReasonBlocked      DeliveryReason = "blocked"
+	// ReasonGroupsLost means none of the subscribed groups remain in the schedule, e.g. after provider renumbered them
+	ReasonGroupsLost DeliveryReason = "groups_lost"
+)
+
+// DeliveryLogEntry is the schedule updates outcome of the chat, which lasted from At until the next entry
+type DeliveryLogEntry struct {
+	At       time.Time      `json:"at"`
+	Delivery Delivery       `json:"delivery"`
+	Reason   DeliveryReason `json:"reason,omitempty"`
+}
+
+// SameOutcome reports whether both entries describe the same outcome regardless of time
+func (e DeliveryLogEntry) SameOutcome(other DeliveryLogEntry) bool {
+	return e.Delivery == other.Delivery && e.Reason == other.Reason
+}
+
+// RunReport counts deliveries of a sending task run
+type RunReport struct {
+	Attempted int
+	Sent      int
+	Failed    int
+	Disabled  int
+	Skipped   int
+}
+
+func (r *RunReport) Record(d Delivery) {
+	switch d {
+	case DeliverySent:
+		r.Attempted++
+		r.Sent++
+	case DeliveryFailed:
+		r.Attempted++
+		r.Failed++
+	case DeliveryDisabled:
+		r.Attempted++
+		r.Disabled++
+	case DeliverySkipped:
+		r.Skipped++
+	case DeliveryNone:
+	}
+}
+
+// FailureRatio is the share of attempted deliveries which failed. Chats which blocked the bot are not failures
+func (r RunReport) FailureRatio() float64 {
+	if r.Attempted == 0 {
+		return 0
+	}
+	return float64(r.Failed) / float64(r.Attempted)
+}
+
+type TaskStatus struct {
+	Name        string
+	LastStart   time.Time
+	LastSuccess time.Time
+	LastError   string
+	Duration    time.Duration
+	// Skipped is the number of ticks skipped because the previous run was still in progress
+	Skipped int
+	// Report is the deliveries of the last run of a sending task
+	Report RunReport
+	// Disabled is set for tasks which are not run by this instance
+	Disabled bool
 }
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseMinutes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "00:00", want: 0},
+		{value: "09:30", want: 570},
+		{value: "23:59", want: 1439},
+		{value: "24:00", want: 1440},
+		{value: "24:01", wantErr: true},
+		{value: "25:00", wantErr: true},
+		{value: "12:60", wantErr: true},
+		{value: "25:99", wantErr: true},
+		{value: "-1:00", wantErr: true},
+		{value: "12:-5", wantErr: true},
+		{value: "9:30", wantErr: true},
+		{value: "09.30", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMinutes(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTime) {
+					t.Errorf("ParseMinutes() error = %v, want %v", err, ErrInvalidTime)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseMinutes() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseMinutes(f *testing.F) {
+	for _, seed := range []string{"00:00", "12:30", "24:00", "24:01", "25:99", "-1:00", "ab:cd"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		got, err := ParseMinutes(value)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidTime) {
+				t.Errorf("ParseMinutes(%q) error = %v, want %v", value, err, ErrInvalidTime)
+			}
+			return
+		}
+		if got < 0 || got > 24*60 {
+			t.Errorf("ParseMinutes(%q) = %d is out of the day", value, got)
+		}
+		if formatted := fmt.Sprintf("%02d:%02d", got/60, got%60); formatted != value {
+			t.Errorf("ParseMinutes(%q) = %d doesn't round trip, got %s", value, got, formatted)
+		}
+	})
+}
+
+func TestPeriod_Validate(t *testing.T) {
+	tests := []struct {
+		period  Period
+		wantErr bool
+	}{
+		{period: Period{From: "00:00", To: "00:30"}},
+		{period: Period{From: "23:30", To: "24:00"}},
+		{period: Period{From: "12:00", To: "12:00"}, wantErr: true},
+		{period: Period{From: "13:00", To: "12:00"}, wantErr: true},
+		{period: Period{From: "23:30", To: "24:30"}, wantErr: true},
+		{period: Period{From: "", To: "01:00"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period.From+"-"+tt.period.To, func(t *testing.T) {
+			err := tt.period.Validate()
+			if tt.wantErr != errors.Is(err, ErrInvalidPeriod) {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package clock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KyivLocationName is the IANA name of the time zone all schedules are published in
+const KyivLocationName = "Europe/Kyiv"
+
+var kyiv = sync.OnceValues(func() (*time.Location, error) {
+	loc, err := time.LoadLocation(KyivLocationName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s location: %w", KyivLocationName, err)
+	}
+	return loc, nil
+})
+
+// Kyiv returns the Kyiv time location. It is loaded once and shared by every caller
+func Kyiv() (*time.Location, error) {
+	return kyiv()
+}
+
+// MustKyiv is like Kyiv but panics if the location can not be loaded
+func MustKyiv() *time.Location {
+	loc, err := Kyiv()
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
@@ -0,0 +1,119 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Mock is a manually driven clock. Time moves only with Advance, which fires all due tickers and timers.
+// Like the real ones, mock channels have a buffer of one and ticks are dropped if receiver is not keeping up
+type Mock struct {
+	mx      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at       time.Time
+	interval time.Duration
+	c        chan time.Time
+}
+
+func (m *Mock) Now() time.Time {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.now
+}
+
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	w := &waiter{at: m.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- m.now
+		return w.c
+	}
+	m.waiters = append(m.waiters, w)
+	return w.c
+}
+
+func (m *Mock) Ticker(d time.Duration) Ticker {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	w := &waiter{at: m.now.Add(d), interval: d, c: make(chan time.Time, 1)}
+	m.waiters = append(m.waiters, w)
+	return &mockTicker{mock: m, waiter: w}
+}
+
+// Advance moves time forward by d firing due tickers and timers in chronological order
+func (m *Mock) Advance(d time.Duration) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	end := m.now.Add(d)
+	for {
+		next := m.nextWaiter()
+		if next == nil || next.at.After(end) {
+			break
+		}
+
+		m.now = next.at
+		select {
+		case next.c <- m.now:
+		default:
+		}
+		if next.interval > 0 {
+			next.at = next.at.Add(next.interval)
+		} else {
+			m.remove(next)
+		}
+	}
+	m.now = end
+}
+
+// Waiters returns number of active tickers and timers that haven't fired yet
+func (m *Mock) Waiters() int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return len(m.waiters)
+}
+
+func (m *Mock) nextWaiter() *waiter {
+	var res *waiter
+	for _, w := range m.waiters {
+		if res == nil || w.at.Before(res.at) {
+			res = w
+		}
+	}
+	return res
+}
+
+func (m *Mock) remove(w *waiter) {
+	for i := range m.waiters {
+		if m.waiters[i] == w {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type mockTicker struct {
+	mock   *Mock
+	waiter *waiter
+}
+
+func (t *mockTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t *mockTicker) Stop() {
+	t.mock.mx.Lock()
+	defer t.mock.mx.Unlock()
+	t.mock.remove(t.waiter)
+}
+
+func NewMock(now time.Time) *Mock {
+	return &Mock{now: now}
+}
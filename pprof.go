@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// newPprofServer returns server exposing profiling endpoints on addr, or nil if addr is empty, so profiles are
+// never exposed unless explicitly configured
+func newPprofServer(addr string) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// startPprof serves profiling endpoints in background if PPROF_ADDR is set. It returns the server to shut down,
+// or nil if profiling is disabled
+func startPprof(addr string) *http.Server {
+	server := newPprofServer(addr)
+	if server == nil {
+		return nil
+	}
+
+	go func() {
+		slog.Info("serving pprof", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("pprof server failed", "error", err)
+		}
+	}()
+	return server
+}
+
+// shutdownServer stops the server waiting up to timeout for in-flight requests. Nil server is ignored
+func shutdownServer(name string, server *http.Server, timeout time.Duration) {
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shut down server gracefully", "server", name, "error", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_newPprofServer(t *testing.T) {
+	if server := newPprofServer(""); server != nil {
+		t.Errorf("newPprofServer() without address = %v, want nil", server)
+	}
+
+	server := newPprofServer("127.0.0.1:0")
+	tests := map[string]int{
+		"/debug/pprof/":          http.StatusOK,
+		"/debug/pprof/cmdline":   http.StatusOK,
+		"/debug/pprof/goroutine": http.StatusOK,
+		"/":                      http.StatusNotFound,
+	}
+	for path, want := range tests {
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func Test_shutdownServer(t *testing.T) {
+	server := startPprof("127.0.0.1:0")
+	shutdownServer("pprof", server, time.Second)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("ListenAndServe() after shutdown error = %v, want %v", err, http.ErrServerClosed)
+	}
+
+	shutdownServer("disabled", nil, time.Second)
+}